@@ -0,0 +1,74 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fireynis/velocity_checker/pkg/models"
+	"fireynis/velocity_checker/pkg/queue"
+	"log"
+	"strconv"
+	"time"
+)
+
+//pollInterval is how long consume waits for more requests when the queue is empty, retryDelay how long it waits after
+//a request fails in a way that might pass on a retry, such as the database being unreachable
+const (
+	pollInterval = time.Second
+	retryDelay   = 5 * time.Second
+)
+
+//consume decides the load requests taken from requests and publishes each decision to decisions, keyed by customer so a
+//customer's loads are decided in the order they were queued. It stops once requests is empty when drain is set and
+//otherwise when ctx is cancelled.
+func (a *application) consume(ctx context.Context, requests queue.IConsumer, decisions queue.IPublisher, explain bool, drain bool) error {
+	consumer := &queue.Consumer{
+		Source:       requests,
+		Replies:      decisions,
+		Handler:      a.handleRequest(explain),
+		PollInterval: pollInterval,
+		RetryDelay:   retryDelay,
+	}
+
+	if drain {
+		handled, err := consumer.Drain()
+		log.Printf("Decided %d queued loads", handled)
+		return err
+	}
+	return consumer.Run(ctx)
+}
+
+//handleRequest decides a queued load request. Requests that can never be decided are rejected so they are dead
+//lettered. A load that has already been decided, as when a request is redelivered after the consumer died before
+//acking it, gets its stored decision rather than being decided twice.
+func (a *application) handleRequest(explain bool) queue.Handler {
+	return func(message *queue.Message) ([]byte, error) {
+		load, err := a.readLoad(message.Body)
+		if err != nil {
+			return nil, queue.Reject(err)
+		}
+
+		stored, err := a.loads.GetByTransactionId(load.CustomerId, load.TransactionId)
+		if err == nil {
+			return json.Marshal(jsonOutput{
+				Id:         strconv.FormatInt(stored.TransactionId, 10),
+				CustomerId: strconv.FormatInt(stored.CustomerId, 10),
+				Accepted:   stored.Accepted,
+				RiskScore:  stored.RiskScore,
+				Outcome:    stored.Outcome,
+				Reason:     stored.Reason,
+			})
+		} else if !errors.Is(err, models.ErrNoRecord) {
+			return nil, err
+		}
+
+		decision, err := a.decide(message.Body, explain)
+		if errors.Is(err, errInvalidInput) {
+			return nil, queue.Reject(err)
+		}
+		if err != nil {
+			return nil, err
+		}
+		return json.Marshal(decision)
+	}
+}
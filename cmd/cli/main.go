@@ -5,32 +5,88 @@ import (
 	"context"
 	"encoding/json"
 	"errors"
+	"fireynis/velocity_checker/pkg/audit"
+	"fireynis/velocity_checker/pkg/currency"
+	"fireynis/velocity_checker/pkg/detect"
+	"fireynis/velocity_checker/pkg/engine"
 	"fireynis/velocity_checker/pkg/helpers"
+	"fireynis/velocity_checker/pkg/linkage"
 	"fireynis/velocity_checker/pkg/models"
 	"fireynis/velocity_checker/pkg/models/postgres"
+	"fireynis/velocity_checker/pkg/notify"
+	"fireynis/velocity_checker/pkg/outbox"
+	"fireynis/velocity_checker/pkg/policy"
+	"fireynis/velocity_checker/pkg/queue"
+	"fireynis/velocity_checker/pkg/report"
 	"fireynis/velocity_checker/pkg/validators"
+	"fireynis/velocity_checker/pkg/webhook"
 	"flag"
 	"fmt"
-	"github.com/jackc/pgx/v4"
+	"github.com/jackc/pgx/v4/pgxpool"
 	"github.com/joho/godotenv"
+	"io"
 	"log"
 	"os"
+	"os/signal"
 	"path/filepath"
 	"strconv"
+	"strings"
+	"syscall"
+	"text/tabwriter"
 	"time"
 )
 
+//errInvalidInput marks a load request that can never be decided, as opposed to a failure that might pass on a retry
+var errInvalidInput = errors.New("invalid input")
+
 type application struct {
-	loads         models.ILoads
-	loadValidator validators.ILoadValidator
+	loads       models.ILoads
+	traces      models.ITraces
+	auditLog    models.IAudit
+	alerts      models.IAlerts
+	links       models.ILinks
+	balances    models.IBalances
+	corrections models.ICorrections
+	engine      *engine.Engine
+	structuring *detect.Structuring
+	recheck     *report.Recheck
+	rates       *currency.Rates
+	timestamps  *helpers.Timestamps
+	webhooks    *webhook.Dispatcher
+	decisions   models.IDecisions
+	outbox      models.IOutbox
+	relay       *outbox.Relay
 }
 
 func main() {
 
+	//The first argument picks the command when it isn't a flag, parsing the input file is the default
+	command := "parse"
+	args := os.Args[1:]
+	if len(args) >= 1 && !strings.HasPrefix(args[0], "-") {
+		command = args[0]
+		args = args[1:]
+	}
+
 	var flagPathToFile = flag.String("file", "", "The path to the file to be read in. Overrides the .env INPUT_FILE.")
 	var flagPathToOutFile = flag.String("output_file", "", "The path to the file to be read in. Overrides the .env OUTPUT_FILE. Leave both blank to output to console")
 	var flagDsn = flag.String("dsn", "", "The connection string for the postgres database. Overrides the .env DATABASE_DSN")
-	flag.Parse()
+	var flagExplain = flag.Bool("explain", false, "Include the per limit evaluation trace with each decision in the output")
+	var flagBaseCurrency = flag.String("base_currency", "", "The ISO 4217 currency limits are evaluated in. Overrides the .env BASE_CURRENCY. Defaults to USD")
+	var flagFxRates = flag.String("fx_rates", "", "The path to a csv of exchange rates into the base currency. Overrides the .env FX_RATES_FILE")
+	var flagMaxFutureSkew = flag.String("max_future_skew", "", "How far ahead of now a load's time can be. Overrides the .env MAX_FUTURE_SKEW. Unbounded when blank")
+	var flagMaxBackdate = flag.String("max_backdate", "", "How far behind now a load's time can be. Overrides the .env MAX_BACKDATE. Unbounded when blank")
+	var flagEvaluateOn = flag.String("evaluate_on", "", "Evaluate loads on their own time or the time they were read, client or received. Overrides the .env EVALUATE_ON. Defaults to client")
+	var flagRecheckLate = flag.Bool("recheck_late", false, "Flag decisions a late load would have changed. Can be turned on in .env with RECHECK_LATE=true")
+	var flagWebhooks = flag.String("webhooks", "", "The path to a json file of webhook subscriptions. Overrides the .env WEBHOOKS_FILE. No webhooks are sent when blank")
+	var flagOutbox = flag.String("outbox", "", "Comma separated sinks to relay decision events to from the outbox, stdout, urls or file paths. Overrides the .env OUTBOX. Off when blank")
+	var flagQueueDir = flag.String("queue_dir", "", "The directory the consume command takes load requests from, under requests, and publishes decisions to, under decisions. Overrides the .env QUEUE_DIR")
+	var flagDrain = flag.Bool("drain", false, "Stop consuming once there are no load requests left rather than waiting for more")
+	var flagNotify = flag.String("notify", "", "Where to send approaching-limit notifications, stdout, a webhook url or a file path. Overrides the .env NOTIFY. Off when blank")
+	var flagPolicy = flag.String("policy", "", "The path to a policy json file. Used by publish-policy and backtest.")
+	var flagFrom = flag.String("from", "", "The first day (YYYY-MM-DD) a report covers. Used by shadow-report, structuring-report, correction-report and backtest.")
+	var flagTo = flag.String("to", "", "The last day (YYYY-MM-DD) a report covers. Used by shadow-report, structuring-report, correction-report and backtest.")
+	_ = flag.CommandLine.Parse(args)
 
 	//I don't really need the env vars since the flags can override them.
 	//Only if the flags are empty are they necessary
@@ -39,22 +95,6 @@ func main() {
 		log.Fatal("Error loading .env file")
 	}
 
-	var pathToFile string
-	if len(*flagPathToFile) >= 1 {
-		pathToFile = *flagPathToFile
-	} else if len(os.Getenv("INPUT_FILE")) >= 1 {
-		pathToFile = os.Getenv("INPUT_FILE")
-	} else {
-		log.Fatalf("A file path is requried")
-	}
-
-	var pathToOutFile string
-	if len(*flagPathToOutFile) >= 1 {
-		pathToOutFile = *flagPathToOutFile
-	} else if len(os.Getenv("OUTPUT_FILE")) >= 1 {
-		pathToOutFile = os.Getenv("OUTPUT_FILE")
-	}
-
 	var dsn string
 	if len(*flagDsn) >= 1 {
 		dsn = *flagDsn
@@ -64,22 +104,247 @@ func main() {
 		log.Fatalf("A databse DSN is required")
 	}
 
-	dbConn, err := pgx.Connect(context.Background(), dsn)
+	maxFutureSkew := *flagMaxFutureSkew
+	if len(maxFutureSkew) < 1 {
+		maxFutureSkew = os.Getenv("MAX_FUTURE_SKEW")
+	}
+	maxBackdate := *flagMaxBackdate
+	if len(maxBackdate) < 1 {
+		maxBackdate = os.Getenv("MAX_BACKDATE")
+	}
+	evaluateOn := *flagEvaluateOn
+	if len(evaluateOn) < 1 {
+		evaluateOn = os.Getenv("EVALUATE_ON")
+	}
+	timestamps, err := helpers.NewTimestamps(maxFutureSkew, maxBackdate, evaluateOn)
+	if err != nil {
+		log.Fatalf("Unable to parse the timestamp settings. %s", err)
+	}
 
+	base := currency.Default
+	if len(*flagBaseCurrency) >= 1 {
+		base = *flagBaseCurrency
+	} else if len(os.Getenv("BASE_CURRENCY")) >= 1 {
+		base = os.Getenv("BASE_CURRENCY")
+	}
+
+	var rates *currency.Rates
+	if len(*flagFxRates) >= 1 {
+		rates, err = currency.LoadRates(*flagFxRates, base)
+	} else if len(os.Getenv("FX_RATES_FILE")) >= 1 {
+		rates, err = currency.LoadRates(os.Getenv("FX_RATES_FILE"), base)
+	} else {
+		rates, err = currency.NewRates(base)
+	}
 	if err != nil {
-		log.Fatalf("Unable to connect to database. %s", err)
+		log.Fatalf("Unable to load exchange rates. %s", err)
+	}
+
+	webhooksFile := *flagWebhooks
+	if len(webhooksFile) < 1 {
+		webhooksFile = os.Getenv("WEBHOOKS_FILE")
+	}
+	var subscriptions []webhook.Subscription
+	if len(webhooksFile) >= 1 {
+		subscriptions, err = webhook.OpenSubscriptions(webhooksFile)
+		if err != nil {
+			log.Fatalf("Unable to load the webhook subscriptions. %s", err)
+		}
+	}
+
+	outboxTargets := *flagOutbox
+	if len(outboxTargets) < 1 {
+		outboxTargets = os.Getenv("OUTBOX")
+	}
+	sinks, err := outbox.OpenSinks(outboxTargets)
+	if err != nil {
+		log.Fatalf("Unable to open the outbox sinks. %s", err)
 	}
-	defer dbConn.Close(context.Background())
 
+	notifyTarget := *flagNotify
+	if len(notifyTarget) < 1 {
+		notifyTarget = os.Getenv("NOTIFY")
+	}
+	notifier, err := notify.Open(notifyTarget)
+	if err != nil {
+		log.Fatalf("Unable to open the notification sink. %s", err)
+	}
+
+	dbPool, err := pgxpool.Connect(context.Background(), dsn)
+
+	if err != nil {
+		log.Fatalf("Unable to connect to database. %s", err)
+	}
+	defer dbPool.Close()
+
+	loads := &postgres.LoadModel{DB: dbPool}
+	policies := &postgres.PolicyModel{DB: dbPool}
+	alerts := &postgres.AlertModel{DB: dbPool}
+	links := &postgres.LinkModel{DB: dbPool}
+	balances := &postgres.BalanceModel{DB: dbPool}
+	corrections := &postgres.CorrectionModel{DB: dbPool}
 	app := &application{
-		loads:         &postgres.LoadModel{DB: dbConn},
-		loadValidator: &validators.LoadValidator{},
+		loads:       loads,
+		traces:      &postgres.TraceModel{DB: dbPool},
+		auditLog:    &postgres.AuditModel{DB: dbPool},
+		alerts:      alerts,
+		links:       links,
+		balances:    balances,
+		corrections: corrections,
+		decisions:   &postgres.DecisionModel{DB: dbPool},
+		engine: &engine.Engine{
+			Loads:         loads,
+			Policies:      policies,
+			Validator:     &validators.LoadValidator{},
+			Links:         links,
+			Balances:      balances,
+			Notifier:      notifier,
+			Notifications: &postgres.NotificationModel{DB: dbPool},
+		},
+		structuring: &detect.Structuring{Loads: loads, Policies: policies, Alerts: alerts},
+		rates:       rates,
+		timestamps:  timestamps,
+	}
+
+	if *flagRecheckLate || os.Getenv("RECHECK_LATE") == "true" {
+		app.recheck = &report.Recheck{Loads: loads, Policies: policies, Links: links, Corrections: corrections}
+	}
+
+	if len(subscriptions) > 0 {
+		app.webhooks = &webhook.Dispatcher{Subscriptions: subscriptions, Deliveries: &postgres.DeliveryModel{DB: dbPool}}
+	}
+
+	if len(sinks) > 0 {
+		app.outbox = &postgres.OutboxModel{DB: dbPool}
+		app.relay = &outbox.Relay{Outbox: app.outbox, Sinks: sinks}
 	}
 
-	app.parseFile(pathToFile, pathToOutFile)
+	switch command {
+	case "parse":
+		var pathToFile string
+		if len(*flagPathToFile) >= 1 {
+			pathToFile = *flagPathToFile
+		} else if len(os.Getenv("INPUT_FILE")) >= 1 {
+			pathToFile = os.Getenv("INPUT_FILE")
+		} else {
+			log.Fatalf("A file path is requried")
+		}
+
+		var pathToOutFile string
+		if len(*flagPathToOutFile) >= 1 {
+			pathToOutFile = *flagPathToOutFile
+		} else if len(os.Getenv("OUTPUT_FILE")) >= 1 {
+			pathToOutFile = os.Getenv("OUTPUT_FILE")
+		}
+
+		app.parseFile(pathToFile, pathToOutFile, *flagExplain)
+		app.deliverWebhooks()
+		app.relayOutbox()
+	case "publish-policy":
+		newPolicy, err := readPolicy(*flagPolicy)
+		if err != nil {
+			log.Fatal(err)
+		}
+		version, err := app.publishPolicy(newPolicy)
+		if err != nil {
+			log.Fatal(err)
+		}
+		fmt.Printf("published policy version %d\n", version)
+	case "shadow-report":
+		startDate, endDate, err := helpers.ParseDateRange(*flagFrom, *flagTo)
+		if err != nil {
+			log.Fatal(err)
+		}
+		err = app.shadowReport(os.Stdout, startDate, endDate)
+		if err != nil {
+			log.Fatal(err)
+		}
+	case "structuring-report":
+		startDate, endDate, err := helpers.ParseDateRange(*flagFrom, *flagTo)
+		if err != nil {
+			log.Fatal(err)
+		}
+		err = app.structuringReport(os.Stdout, startDate, endDate)
+		if err != nil {
+			log.Fatal(err)
+		}
+	case "correction-report":
+		startDate, endDate, err := helpers.ParseDateRange(*flagFrom, *flagTo)
+		if err != nil {
+			log.Fatal(err)
+		}
+		err = app.correctionReport(os.Stdout, startDate, endDate)
+		if err != nil {
+			log.Fatal(err)
+		}
+	case "backtest":
+		startDate, endDate, err := helpers.ParseDateRange(*flagFrom, *flagTo)
+		if err != nil {
+			log.Fatal(err)
+		}
+		candidate, err := readPolicy(*flagPolicy)
+		if err != nil {
+			log.Fatal(err)
+		}
+		err = app.backtest(os.Stdout, candidate, startDate, endDate)
+		if err != nil {
+			log.Fatal(err)
+		}
+	case "consume":
+		queueDir := *flagQueueDir
+		if len(queueDir) < 1 {
+			queueDir = os.Getenv("QUEUE_DIR")
+		}
+		if len(queueDir) < 1 {
+			log.Fatalf("A queue directory is required")
+		}
+
+		requests, err := queue.OpenDir(filepath.Join(queueDir, "requests"))
+		if err != nil {
+			log.Fatalf("Unable to open the requests queue. %s", err)
+		}
+		decisions, err := queue.OpenDir(filepath.Join(queueDir, "decisions"))
+		if err != nil {
+			log.Fatalf("Unable to open the decisions queue. %s", err)
+		}
+		//Anything still in processing was left by a consumer that died before acking it
+		recovered, err := requests.Recover()
+		if err != nil {
+			log.Fatalf("Unable to recover the requests queue. %s", err)
+		}
+		if recovered > 0 {
+			log.Printf("Requeued %d load requests left in processing", recovered)
+		}
+
+		ctx, cancel := context.WithCancel(context.Background())
+		signals := make(chan os.Signal, 1)
+		signal.Notify(signals, os.Interrupt, syscall.SIGTERM)
+		go func() {
+			<-signals
+			cancel()
+		}()
+
+		err = app.consume(ctx, requests, decisions, *flagExplain, *flagDrain)
+		cancel()
+		if err != nil {
+			log.Fatal(err)
+		}
+		app.deliverWebhooks()
+		app.relayOutbox()
+	case "deliver-webhooks":
+		app.deliverWebhooks()
+	case "relay-outbox":
+		app.relayOutbox()
+	case "verify-audit":
+		if !app.verifyAudit(os.Stdout) {
+			os.Exit(1)
+		}
+	default:
+		log.Fatalf("Unknown command %s", command)
+	}
 }
 
-func (a *application) parseFile(filePath, pathToOutFile string) {
+func (a *application) parseFile(filePath, pathToOutFile string, explain bool) {
 	cleanPath, err := filepath.Abs(filePath)
 
 	if err != nil {
@@ -114,80 +379,325 @@ func (a *application) parseFile(filePath, pathToOutFile string) {
 
 	scanner := bufio.NewScanner(file)
 	for scanner.Scan() {
-		var tempLoad helpers.ImportLoad
-		err = json.Unmarshal(scanner.Bytes(), &tempLoad)
-
+		decision, err := a.decide(scanner.Bytes(), explain)
 		if err != nil {
-			log.Printf("Unable to parse json. Line: %s. %s", scanner.Text(), err)
+			log.Print(err)
 			continue
 		}
 
-		load, err := helpers.InputToLoad(tempLoad)
-
+		outJson, err := json.Marshal(decision)
 		if err != nil {
-			log.Print(err)
+			log.Printf("Unable to marshall output json. %s", err)
 			continue
 		}
 
-		err = a.withinLimits(&load)
+		if outToFile {
+			_, _ = output.WriteString(string(outJson) + "\n")
+		} else {
+			fmt.Printf("%s\n", outJson)
+		}
+	}
+}
+
+//decide runs a json load request through the decision logic shared by every command that decides loads. The load is
+//checked, evaluated and stored along with everything that hangs off a decision, and the decision returned. An error
+//wrapping errInvalidInput means the request itself is wrong and will never be decided.
+func (a *application) decide(line []byte, explain bool) (*jsonOutput, error) {
+	load, err := a.readLoad(line)
+	if err != nil {
+		return nil, err
+	}
+
+	trace, err := a.withinLimits(&load)
+	if err != nil {
+		return nil, err
+	}
+
+	err = a.insertLoad(&load)
+	if err != nil {
+		return nil, errors.New(fmt.Sprintf("Unable to insert into loads table. %s", err))
+	}
+
+	engine.RecordShadowOutcomes(trace, load.Accepted)
+
+	err = a.traces.Insert(load.Id, trace)
+	if err != nil {
+		log.Printf("Unable to insert into decision_traces table. %s", err)
+	}
+
+	err = linkage.Record(a.links, &load)
+	if err != nil {
+		log.Printf("Unable to insert into customer_links table. %s", err)
+	}
+
+	err = a.engine.Notify(&load, trace)
+	if err != nil {
+		log.Printf("Unable to send approaching-limit notifications. %s", err)
+	}
 
+	if load.Accepted {
+		_, err = a.structuring.Check(&load)
 		if err != nil {
-			log.Print(err)
-			continue
+			log.Printf("Unable to check for structuring. %s", err)
 		}
+	}
 
-		_, err = a.loads.Insert(&load)
+	//Only set when late loads are to be checked for the decisions they would have changed
+	if a.recheck != nil {
+		_, err = a.recheck.Check(&load)
 		if err != nil {
-			log.Printf("Unable to insert into loads table. %s", err)
-			continue
+			log.Printf("Unable to recheck the decisions after a late load. %s", err)
 		}
+	}
+
+	decision := jsonOutput{
+		Id:         strconv.FormatInt(load.TransactionId, 10),
+		CustomerId: strconv.FormatInt(load.CustomerId, 10),
+		Accepted:   load.Accepted,
+		RiskScore:  load.RiskScore,
+		Outcome:    load.Outcome,
+		Reason:     load.Reason,
+	}
+	if explain {
+		decision.PolicyVersion = load.PolicyVersion
+		decision.Trace = trace
+	}
+	return &decision, nil
+}
+
+//readLoad parses a json load request and applies the timestamp bounds and exchange rates to it
+func (a *application) readLoad(line []byte) (models.Load, error) {
+	var tempLoad helpers.ImportLoad
+	err := json.Unmarshal(line, &tempLoad)
+	if err != nil {
+		return models.Load{}, fmt.Errorf("%w: unable to parse json. Line: %s. %s", errInvalidInput, line, err)
+	}
+
+	load, err := helpers.InputToLoad(tempLoad)
+	if err != nil {
+		return models.Load{}, fmt.Errorf("%w: %s", errInvalidInput, err)
+	}
+
+	err = a.timestamps.Apply(&load)
+	if err != nil {
+		return models.Load{}, fmt.Errorf("%w: %s", errInvalidInput, err)
+	}
+
+	err = a.rates.Apply(&load)
+	if err != nil {
+		return models.Load{}, fmt.Errorf("%w: %s", errInvalidInput, err)
+	}
+	return load, nil
+}
 
-		outJson, err := json.Marshal(jsonOutput{
-			Id:         strconv.FormatInt(load.TransactionId, 10),
-			CustomerId: strconv.FormatInt(load.CustomerId, 10),
-			Accepted:   load.Accepted,
-		})
+//insertLoad stores the load along with its audit entry, the move in the customer's balance, its webhook deliveries, and
+//its decision event when the outbox is on, in one transaction. A decision that can't be audited or settled isn't stored. A load accepted on a
+//balance that has moved since is declined instead.
+func (a *application) insertLoad(load *models.Load) error {
+	var event *models.OutboxEvent
+	if a.outbox != nil {
+		var err error
+		event, err = outbox.DecisionEvent(load)
 		if err != nil {
-			log.Printf("Unable to marshall output json. %s", err)
-			continue
+			return err
 		}
+	}
 
-		if outToFile {
-			_, _ = output.WriteString(string(outJson) + "\n")
-		} else {
-			fmt.Printf("%s\n", outJson)
-		}
+	deliveries, err := a.webhooks.NewDeliveries(audit.EventDecision, load)
+	if err != nil {
+		return err
+	}
+
+	_, err = a.decisions.Insert(load, audit.NewEntry(audit.EventDecision, load, audit.ActorEngine, ""), event, deliveries)
+	//The balance moved past what the engine checked while the load was being decided, so it is stored declined
+	if errors.Is(err, models.ErrBalanceChanged) {
+		engine.DeclineBalanceChanged(load)
+		return a.insertLoad(load)
+	}
+	return err
+}
+
+//relayOutbox sends the outbox's unrelayed events to its sinks. Any left after a failure are sent by a later run.
+func (a *application) relayOutbox() {
+	if a.relay == nil {
+		return
+	}
+	relayed, err := a.relay.RelayPending()
+	if err != nil {
+		log.Printf("Unable to relay the outbox. %s", err)
+	}
+	if relayed > 0 {
+		log.Printf("Relayed %d outbox events", relayed)
 	}
 }
 
-func (a *application) withinLimits(load *models.Load) error {
+//deliverWebhooks sends the webhook deliveries that are due. Those that fail are left for a later run to retry.
+func (a *application) deliverWebhooks() {
+	delivered, err := a.webhooks.DeliverDue()
+	if err != nil {
+		log.Printf("Unable to deliver webhooks. %s", err)
+	}
+	if delivered > 0 {
+		log.Printf("Delivered %d webhooks", delivered)
+	}
+}
+
+func (a *application) withinLimits(load *models.Load) ([]models.RuleTrace, error) {
 	_, err := a.loads.GetByTransactionId(load.CustomerId, load.TransactionId)
 
 	//Ignoring a second load with the same id on a customer
 	if err == nil {
-		return errors.New(fmt.Sprintf("duplicate transaction, %+v", load))
+		return nil, errors.New(fmt.Sprintf("duplicate transaction, %+v", load))
 	} else if !errors.Is(err, models.ErrNoRecord) {
-		return errors.New(fmt.Sprintf("error checking for duplicate record. %s", err))
+		return nil, errors.New(fmt.Sprintf("error checking for duplicate record. %s", err))
 	}
 
-	startDate := time.Date(load.Time.Year(), load.Time.Month(), load.Time.Day(), 0, 0, 0, 0, time.UTC)
-	endDate := time.Date(load.Time.Year(), load.Time.Month(), load.Time.Day(), 23, 59, 59, 999, time.UTC)
-	loadModels, err := a.loads.GetByCustomerTransactionsByDateRange(load.CustomerId, startDate, endDate)
+	return a.engine.Evaluate(load)
+}
+
+//publishPolicy stores the policy as the next version. Its effective_from can be in the future to schedule a change of
+//limits.
+func (a *application) publishPolicy(newPolicy *models.Policy) (int64, error) {
+	if newPolicy.EffectiveFrom.IsZero() {
+		return 0, errors.New("policy needs an effective_from time")
+	}
+
+	return a.engine.Policies.Insert(newPolicy)
+}
+
+//backtest replays the stored loads in the date range against the candidate policy and writes every decision that would
+//change. Nothing is written to the loads table.
+func (a *application) backtest(out io.Writer, candidate *models.Policy, startDate time.Time, endDate time.Time) error {
+	result, err := report.Backtest(a.loads, candidate, startDate, endDate)
 	if err != nil {
-		if errors.Is(err, models.ErrNoRecord) {
-			return nil
-		} else {
-			return err
+		return err
+	}
+
+	_, _ = fmt.Fprintf(out, "replayed %d loads, %d decisions would change, %d customers affected\n\n",
+		result.Replayed, len(result.Flips), len(result.CustomersAffected))
+
+	writer := tabwriter.NewWriter(out, 0, 0, 2, ' ', 0)
+	_, _ = fmt.Fprintln(writer, "RULE\tFAILED\tFLIPPED")
+	for _, impact := range result.Rules {
+		_, _ = fmt.Fprintf(writer, "%s\t%d\t%d\n", impact.Rule, impact.Failed, impact.Flipped)
+	}
+	_, _ = fmt.Fprintln(writer)
+
+	_, _ = fmt.Fprintln(writer, "ID\tCUSTOMER_ID\tTIME\tWAS\tNOW\tFAILED RULES")
+	for _, flip := range result.Flips {
+		_, _ = fmt.Fprintf(writer, "%d\t%d\t%s\t%s\t%s\t%s\n", flip.Load.TransactionId, flip.Load.CustomerId,
+			flip.Load.Time.UTC().Format(time.RFC3339), decisionName(flip.WasAccepted), decisionName(flip.Load.Accepted),
+			strings.Join(flip.FailedRules, ","))
+	}
+	return writer.Flush()
+}
+
+func decisionName(accepted bool) string {
+	if accepted {
+		return "accepted"
+	}
+	return "rejected"
+}
+
+//readPolicy parses and validates a policy json file
+func readPolicy(filePath string) (*models.Policy, error) {
+	if len(filePath) < 1 {
+		return nil, errors.New("a policy file path is required")
+	}
+
+	cleanPath, err := filepath.Abs(filePath)
+	if err != nil {
+		return nil, errors.New(fmt.Sprintf("unable to clean file path. %s", err))
+	}
+
+	file, err := os.Open(cleanPath)
+	if err != nil {
+		return nil, errors.New(fmt.Sprintf("unable to open policy file. %s", err))
+	}
+	defer file.Close()
+
+	return policy.Parse(file)
+}
+
+//shadowReport writes how each shadow rule compared to the live decisions made in the date range
+func (a *application) shadowReport(out io.Writer, startDate time.Time, endDate time.Time) error {
+	traced, err := a.traces.GetByDateRange(startDate, endDate)
+	if err != nil {
+		return errors.New(fmt.Sprintf("unable to read decision_traces table. %s", err))
+	}
+
+	writer := tabwriter.NewWriter(out, 0, 0, 2, ' ', 0)
+	_, _ = fmt.Fprintln(writer, "RULE\tEVALUATED\tWOULD REJECT\tDISAGREED WITH LIVE\tCUSTOMERS AFFECTED")
+	for _, summary := range report.Shadow(traced) {
+		_, _ = fmt.Fprintf(writer, "%s\t%d\t%d\t%d\t%d\n", summary.Rule, summary.Evaluated, summary.Failed, summary.Disagreed, summary.CustomersAffected)
+	}
+	return writer.Flush()
+}
+
+//structuringReport writes the structuring alerts raised for windows ending in the date range
+func (a *application) structuringReport(out io.Writer, startDate time.Time, endDate time.Time) error {
+	alerts, err := a.alerts.GetByDateRange(startDate, endDate)
+	if err != nil {
+		return errors.New(fmt.Sprintf("unable to read alerts table. %s", err))
+	}
+
+	writer := tabwriter.NewWriter(out, 0, 0, 2, ' ', 0)
+	_, _ = fmt.Fprintln(writer, "CUSTOMER_ID\tPATTERN\tFROM\tTO\tTRANSACTION IDS\tDETAIL")
+	for _, alert := range alerts {
+		ids := make([]string, 0, len(alert.TransactionIds))
+		for _, id := range alert.TransactionIds {
+			ids = append(ids, strconv.FormatInt(id, 10))
 		}
+		_, _ = fmt.Fprintf(writer, "%d\t%s\t%s\t%s\t%s\t%s\n", alert.CustomerId, alert.Pattern,
+			alert.WindowStart.UTC().Format("2006-01-02"), alert.WindowEnd.UTC().Format("2006-01-02"),
+			strings.Join(ids, ","), alert.Detail)
+	}
+	return writer.Flush()
+}
+
+//correctionReport lists the decisions late loads flagged between the dates
+func (a *application) correctionReport(out io.Writer, startDate time.Time, endDate time.Time) error {
+	corrections, err := a.corrections.GetByDateRange(startDate, endDate)
+	if err != nil {
+		return errors.New(fmt.Sprintf("unable to read corrections table. %s", err))
+	}
+
+	writer := tabwriter.NewWriter(out, 0, 0, 2, ' ', 0)
+	_, _ = fmt.Fprintln(writer, "CUSTOMER_ID\tID\tWAS\tWOULD BE\tFAILED RULES\tLATE CUSTOMER_ID\tLATE ID")
+	for _, correction := range corrections {
+		_, _ = fmt.Fprintf(writer, "%d\t%d\t%s\t%s\t%s\t%d\t%d\n", correction.CustomerId, correction.TransactionId,
+			correction.WasOutcome, correction.Outcome, strings.Join(correction.FailedRules, ","), correction.LateCustomerId,
+			correction.LateTransactionId)
+	}
+	return writer.Flush()
+}
+
+//verifyAudit walks the audit chain writing any breaks to out. It returns whether the chain is intact.
+func (a *application) verifyAudit(out io.Writer) bool {
+	count, breaks, err := audit.Verify(a.auditLog)
+	if err != nil {
+		log.Printf("Unable to read audit_log table. %s", err)
+		return false
+	}
+
+	for _, brk := range breaks {
+		_, _ = fmt.Fprintf(out, "entry %d: %s\n", brk.EntryId, brk.Reason)
+	}
+	if len(breaks) > 0 {
+		_, _ = fmt.Fprintf(out, "audit chain broken: %d problems in %d entries\n", len(breaks), count)
+		return false
 	}
-	load.Accepted = a.loadValidator.LessThanThreeLoadsDaily(loadModels) &&
-		a.loadValidator.LessThanFiveThousandLoadedDaily(loadModels, load) &&
-		a.loadValidator.LessThanTwentyThousandLoadedWeekly(loadModels, load)
-	return nil
+	_, _ = fmt.Fprintf(out, "audit chain intact: %d entries\n", count)
+	return true
 }
 
 type jsonOutput struct {
-	Id         string `json:"id"`
-	CustomerId string `json:"customer_id"`
-	Accepted   bool   `json:"accepted"`
+	Id            string             `json:"id"`
+	CustomerId    string             `json:"customer_id"`
+	Accepted      bool               `json:"accepted"`
+	RiskScore     int64              `json:"risk_score"`
+	Outcome       string             `json:"outcome"`
+	Reason        string             `json:"reason,omitempty"`
+	PolicyVersion int64              `json:"policy_version,omitempty"`
+	Trace         []models.RuleTrace `json:"trace,omitempty"`
 }
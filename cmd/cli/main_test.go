@@ -1,9 +1,20 @@
 package main
 
 import (
+	"bytes"
+	"context"
+	"fireynis/velocity_checker/pkg/audit"
+	"fireynis/velocity_checker/pkg/currency"
+	"fireynis/velocity_checker/pkg/detect"
+	"fireynis/velocity_checker/pkg/engine"
+	"fireynis/velocity_checker/pkg/helpers"
 	"fireynis/velocity_checker/pkg/models"
+	"fireynis/velocity_checker/pkg/models/memory"
 	"fireynis/velocity_checker/pkg/models/mock"
+	"fireynis/velocity_checker/pkg/queue"
 	"fireynis/velocity_checker/pkg/validators"
+	"path/filepath"
+	"reflect"
 	"testing"
 	"time"
 )
@@ -80,6 +91,63 @@ func Test_application_withinLimits(t *testing.T) {
 			wantErr:      false,
 			wantAccepted: false,
 		},
+		{
+			name: "Over the daily limit of a later policy version",
+			fields: fields{
+				loads:         &mock.Load{},
+				loadValidator: &validators.LoadValidator{},
+			},
+			args: args{
+				load: &models.Load{
+					Id:            0,
+					TransactionId: 2,
+					CustomerId:    4,
+					Amount:        250000,
+					Time:          time.Date(2001, 1, 1, 16, 0, 0, 0, time.UTC),
+					Accepted:      false,
+				},
+			},
+			wantErr:      false,
+			wantAccepted: false,
+		},
+		{
+			name: "Failing shadow rule doesn't reject",
+			fields: fields{
+				loads:         &mock.Load{},
+				loadValidator: &validators.LoadValidator{},
+			},
+			args: args{
+				load: &models.Load{
+					Id:            0,
+					TransactionId: 2,
+					CustomerId:    4,
+					Amount:        250000,
+					Time:          time.Date(2002, 1, 1, 16, 0, 0, 0, time.UTC),
+					Accepted:      false,
+				},
+			},
+			wantErr:      false,
+			wantAccepted: true,
+		},
+		{
+			name: "Over an expression limit",
+			fields: fields{
+				loads:         &mock.Load{},
+				loadValidator: &validators.LoadValidator{},
+			},
+			args: args{
+				load: &models.Load{
+					Id:            0,
+					TransactionId: 2,
+					CustomerId:    4,
+					Amount:        250000,
+					Time:          time.Date(2003, 1, 1, 16, 0, 0, 0, time.UTC),
+					Accepted:      false,
+				},
+			},
+			wantErr:      false,
+			wantAccepted: false,
+		},
 		{
 			name: "Acceptable load",
 			fields: fields{
@@ -103,10 +171,10 @@ func Test_application_withinLimits(t *testing.T) {
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
 			a := &application{
-				loads:         tt.fields.loads,
-				loadValidator: tt.fields.loadValidator,
+				loads:  tt.fields.loads,
+				engine: &engine.Engine{Loads: tt.fields.loads, Policies: &mock.Policy{}, Validator: tt.fields.loadValidator},
 			}
-			if err := a.withinLimits(tt.args.load); (err != nil) != tt.wantErr {
+			if _, err := a.withinLimits(tt.args.load); (err != nil) != tt.wantErr {
 				t.Errorf("withinLimits() error = %v, wantErr %v", err, tt.wantErr)
 			}
 			if tt.args.load.Accepted != tt.wantAccepted {
@@ -115,3 +183,106 @@ func Test_application_withinLimits(t *testing.T) {
 		})
 	}
 }
+
+func Test_application_verifyAudit(t *testing.T) {
+	auditLog := &mock.Audit{}
+	for _, load := range []*models.Load{
+		{Id: 1, TransactionId: 1, CustomerId: 1, Amount: 250000, Accepted: true},
+		{Id: 2, TransactionId: 2, CustomerId: 1, Amount: 250000, Accepted: true},
+	} {
+		_ = auditLog.Append(audit.NewEntry(audit.EventDecision, load, audit.ActorEngine, ""))
+	}
+	a := &application{auditLog: auditLog}
+
+	var out bytes.Buffer
+	if !a.verifyAudit(&out) {
+		t.Errorf("verifyAudit() intact chain reported broken: %s", out.String())
+	}
+
+	auditLog.Entries[0].Accepted = false
+	out.Reset()
+	if a.verifyAudit(&out) {
+		t.Errorf("verifyAudit() tampered chain reported intact: %s", out.String())
+	}
+}
+
+func Test_application_shadowReport(t *testing.T) {
+	a := &application{traces: &mock.Trace{}}
+
+	startDate, endDate, err := helpers.ParseDateRange("2000-01-01", "2000-01-01")
+	if err != nil {
+		t.Fatalf("helpers.ParseDateRange() unexpected error %v", err)
+	}
+
+	var out bytes.Buffer
+	err = a.shadowReport(&out, startDate, endDate)
+	if err != nil {
+		t.Fatalf("shadowReport() unexpected error %v", err)
+	}
+
+	want := "RULE                         EVALUATED  WOULD REJECT  DISAGREED WITH LIVE  CUSTOMERS AFFECTED\n" +
+		"daily_load_amount_tightened  1          1             1                    1\n"
+	if out.String() != want {
+		t.Errorf("shadowReport() got\n%s\nwant\n%s", out.String(), want)
+	}
+}
+
+func Test_application_consume(t *testing.T) {
+	loads := memory.NewLoad(nil)
+	policies := &mock.Policy{}
+	rates, _ := currency.NewRates("USD")
+	auditLog := &mock.Audit{}
+	balances := &mock.Balance{}
+	a := &application{
+		loads:       loads,
+		traces:      &mock.Trace{},
+		auditLog:    auditLog,
+		decisions:   &mock.Decision{Loads: loads, AuditLog: auditLog, Balances: balances},
+		links:       &mock.Link{},
+		balances:    balances,
+		engine:      &engine.Engine{Loads: loads, Policies: policies, Validator: &validators.LoadValidator{}},
+		structuring: &detect.Structuring{Loads: loads, Policies: policies, Alerts: &mock.Alert{}},
+		rates:       rates,
+		timestamps:  &helpers.Timestamps{EvaluateOn: helpers.EvaluateOnClient},
+	}
+
+	root := t.TempDir()
+	requests, _ := queue.OpenDir(filepath.Join(root, "requests"))
+	decisions, _ := queue.OpenDir(filepath.Join(root, "decisions"))
+	for _, request := range []string{
+		`{"id":"1","customer_id":"1","load_amount":"$100.00","time":"2000-01-01T00:00:00Z"}`,
+		`{"id":"1","customer_id":"1","load_amount":"$100.00","time":"2000-01-01T00:00:00Z"}`,
+		`{"id":"2","customer_id":"1","load_amount":"$6000.00","time":"2000-01-01T01:00:00Z"}`,
+		`{"id":"3","customer_id":"1","load_amount":"lots","time":"2000-01-01T02:00:00Z"}`,
+	} {
+		_ = requests.Publish("1", []byte(request))
+	}
+
+	err := a.consume(context.Background(), requests, decisions, false, true)
+	if err != nil {
+		t.Fatalf("consume() unexpected error %v", err)
+	}
+
+	//The redelivered request gets the stored decision and the bad one is dead lettered
+	want := []string{
+		`{"id":"1","customer_id":"1","accepted":true,"risk_score":0,"outcome":"approve"}`,
+		`{"id":"1","customer_id":"1","accepted":true,"risk_score":0,"outcome":"approve"}`,
+		`{"id":"2","customer_id":"1","accepted":false,"risk_score":0,"outcome":"decline"}`,
+	}
+	var got []string
+	for {
+		message, err := decisions.Receive()
+		if err != nil {
+			break
+		}
+		got = append(got, string(message.Body))
+		_ = decisions.Ack(message)
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("consume() published %v, want %v", got, want)
+	}
+	stored, _ := loads.GetByDateRange(time.Date(2000, 1, 1, 0, 0, 0, 0, time.UTC), time.Date(2000, 1, 2, 0, 0, 0, 0, time.UTC))
+	if len(stored) != 2 {
+		t.Errorf("consume() stored %d loads, want 2", len(stored))
+	}
+}
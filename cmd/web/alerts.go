@@ -0,0 +1,29 @@
+package main
+
+import (
+	"fireynis/velocity_checker/pkg/helpers"
+	"fmt"
+	"net/http"
+)
+
+//listAlerts returns the structuring alerts whose window ends between the from and to dates, oldest first
+func (a *application) listAlerts(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		w.Header().Set("Allow", http.MethodGet)
+		http.Error(w, "Method not allowed", 405)
+		return
+	}
+
+	start, end, err := helpers.ParseDateRange(r.URL.Query().Get("from"), r.URL.Query().Get("to"))
+	if err != nil {
+		http.Error(w, err.Error(), 400)
+		return
+	}
+
+	alerts, err := a.alerts.GetByDateRange(start, end)
+	if err != nil {
+		http.Error(w, fmt.Sprintf("Error retrieving data. %s", err), 500)
+		return
+	}
+	a.writeJson(w, alerts)
+}
@@ -0,0 +1,100 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fireynis/velocity_checker/pkg/helpers"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"net/http"
+)
+
+//maxBatchBytes caps the size of a batch upload, as the whole batch is read before the first reply is written
+const maxBatchBytes = 10 << 20
+
+//batchLine is the reply to one line of a batch, either its decision or the error and status POST / would have failed
+//with. Line counts from 1 and includes blank lines, so it always matches the line number in the upload.
+type batchLine struct {
+	Line int `json:"line"`
+	*jsonOutput
+	Error  string `json:"error,omitempty"`
+	Status int    `json:"status,omitempty"`
+}
+
+//batchLoads decides newline delimited json loads, in the same format as the CLI input, and streams back a json line
+//for each as it is decided. The lines are decided one after another in the order they were sent, so every load is
+//checked against the loads before it for the same customer. A line that can't be decided gets an error in its reply
+//rather than stopping the batch, blank lines are skipped.
+//
+//The whole batch is read before anything is written back. Once a handler writes, net/http stops reading an HTTP/1.1
+//request body, so streaming replies while the batch was still being read would cut it short.
+func (a *application) batchLoads(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		w.Header().Set("Allow", http.MethodPost)
+		http.Error(w, "Method not allowed", 405)
+		return
+	}
+
+	body, err := ioutil.ReadAll(http.MaxBytesReader(w, r.Body, maxBatchBytes))
+	if err != nil {
+		http.Error(w, fmt.Sprintf("Unable to read the batch, it can be at most %d bytes. %s", maxBatchBytes, err), 413)
+		return
+	}
+
+	explain := r.URL.Query().Get("explain") == "true"
+	flusher, _ := w.(http.Flusher)
+	encoder := json.NewEncoder(w)
+	w.Header().Set("Content-Type", "application/x-ndjson")
+
+	scanner := bufio.NewScanner(bytes.NewReader(body))
+	line := 0
+	for scanner.Scan() {
+		line++
+		if len(scanner.Bytes()) < 1 {
+			continue
+		}
+
+		err := encoder.Encode(a.batchLine(line, scanner.Bytes(), explain))
+		if err != nil {
+			log.Printf("Unable to write the batch reply. %s", err)
+			return
+		}
+		if flusher != nil {
+			flusher.Flush()
+		}
+	}
+
+	//Only a line too long to read stops the batch early, the lines after it can't be told apart
+	if scanner.Err() != nil {
+		_ = encoder.Encode(batchLine{Line: line + 1, Error: fmt.Sprintf("Unable to read the batch. %s", scanner.Err()), Status: 400})
+	}
+}
+
+//batchLine decides a single line of a batch
+func (a *application) batchLine(line int, data []byte, explain bool) batchLine {
+	var inData helpers.ImportLoad
+	err := json.Unmarshal(data, &inData)
+	if err != nil {
+		return batchLine{Line: line, Error: "Unable to parse json", Status: 400}
+	}
+
+	load, err := a.readLoad(inData)
+	if err != nil {
+		return batchLine{Line: line, Error: err.Error(), Status: 400}
+	}
+
+	trace, err := a.decide(&load)
+	if err != nil {
+		status := 500
+		if errors.Is(err, errDuplicate) {
+			status = 400
+		}
+		return batchLine{Line: line, Error: err.Error(), Status: status}
+	}
+
+	output := newJsonOutput(&load, trace, explain)
+	return batchLine{Line: line, jsonOutput: &output}
+}
@@ -0,0 +1,29 @@
+package main
+
+import (
+	"fireynis/velocity_checker/pkg/helpers"
+	"fmt"
+	"net/http"
+)
+
+//listCorrections returns the decisions late loads would have changed, flagged between the from and to dates
+func (a *application) listCorrections(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		w.Header().Set("Allow", http.MethodGet)
+		http.Error(w, "Method not allowed", 405)
+		return
+	}
+
+	start, end, err := helpers.ParseDateRange(r.URL.Query().Get("from"), r.URL.Query().Get("to"))
+	if err != nil {
+		http.Error(w, err.Error(), 400)
+		return
+	}
+
+	corrections, err := a.corrections.GetByDateRange(start, end)
+	if err != nil {
+		http.Error(w, fmt.Sprintf("Error retrieving data. %s", err), 500)
+		return
+	}
+	a.writeJson(w, corrections)
+}
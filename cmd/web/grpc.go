@@ -0,0 +1,227 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fireynis/velocity_checker/pkg/engine"
+	"fireynis/velocity_checker/pkg/helpers"
+	"fireynis/velocity_checker/pkg/models"
+	"fireynis/velocity_checker/pkg/rpc"
+	"fmt"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"io"
+	"log"
+	"net"
+	"time"
+)
+
+//rpcServer serves the gRPC API off the application, so it shares the engine and stores with the JSON handlers
+type rpcServer struct {
+	rpc.UnimplementedVelocityServer
+	app *application
+}
+
+//grpcServer is the gRPC server with the velocity service registered
+func (a *application) grpcServer() *grpc.Server {
+	server := grpc.NewServer()
+	rpc.RegisterVelocityServer(server, &rpcServer{app: a})
+	return server
+}
+
+//serveGrpc serves the gRPC API on its own port. It never returns so should be run in its own goroutine.
+func (a *application) serveGrpc(port string) {
+	listener, err := net.Listen("tcp", ":"+port)
+	if err != nil {
+		log.Fatalf("Unable to listen for gRPC. %s", err)
+	}
+	log.Fatal(a.grpcServer().Serve(listener))
+}
+
+func (s *rpcServer) Evaluate(ctx context.Context, in *rpc.LoadRequest) (*rpc.Decision, error) {
+	load, err := s.readLoad(in)
+	if err != nil {
+		return nil, rpcError(err)
+	}
+
+	trace, err := s.app.decide(&load)
+	if err != nil {
+		return nil, rpcError(err)
+	}
+	return newDecision(&load, trace, in.Explain), nil
+}
+
+//Simulate runs the load through the engine without storing it. A load the customer has already sent is refused, the
+//same as Evaluate, as it would otherwise be counted twice.
+func (s *rpcServer) Simulate(ctx context.Context, in *rpc.LoadRequest) (*rpc.Decision, error) {
+	load, err := s.readLoad(in)
+	if err != nil {
+		return nil, rpcError(err)
+	}
+
+	err = s.app.checkDuplicate(&load)
+	if err != nil {
+		return nil, rpcError(err)
+	}
+
+	trace, err := s.app.engine.Evaluate(&load)
+	if err != nil {
+		return nil, rpcError(errors.New(fmt.Sprintf("Error retrieving data. %s", err)))
+	}
+	return newDecision(&load, trace, in.Explain), nil
+}
+
+//GetLoad returns the stored decision with its trace. A load whose trace wasn't stored comes back without one.
+func (s *rpcServer) GetLoad(ctx context.Context, in *rpc.GetLoadRequest) (*rpc.Decision, error) {
+	load, err := s.app.loads.GetByTransactionId(in.CustomerId, in.Id)
+	if err != nil {
+		return nil, rpcError(err)
+	}
+
+	trace, err := s.app.traces.GetByLoadId(load.Id)
+	if err != nil && !errors.Is(err, models.ErrNoRecord) {
+		return nil, rpcError(err)
+	}
+	return newDecision(load, trace, true), nil
+}
+
+func (s *rpcServer) GetCustomerUsage(ctx context.Context, in *rpc.GetCustomerUsageRequest) (*rpc.CustomerUsage, error) {
+	at := time.Now().UTC()
+	if len(in.At) > 0 {
+		var err error
+		at, err = time.Parse(time.RFC3339, in.At)
+		if err != nil {
+			return nil, status.Error(codes.InvalidArgument, "at must be an RFC 3339 time")
+		}
+	}
+
+	headroom, err := s.app.engine.Headroom(in.CustomerId, at)
+	if err != nil {
+		return nil, rpcError(err)
+	}
+	return newCustomerUsage(headroom), nil
+}
+
+//BatchEvaluate decides the loads one at a time in the order they arrive, so a customer's loads are always evaluated
+//against the ones sent before them. Only a failure to receive or send ends the stream.
+func (s *rpcServer) BatchEvaluate(stream rpc.Velocity_BatchEvaluateServer) error {
+	for index := int64(0); ; index++ {
+		in, err := stream.Recv()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+
+		reply := &rpc.BatchDecision{Index: index}
+		reply.Decision, err = s.Evaluate(stream.Context(), in)
+		if err != nil {
+			failure := status.Convert(err)
+			reply.Error = failure.Message()
+			reply.Code = failure.Code().String()
+		}
+
+		err = stream.Send(reply)
+		if err != nil {
+			return err
+		}
+	}
+}
+
+//readLoad turns the request into a load the same way the JSON handler does
+func (s *rpcServer) readLoad(in *rpc.LoadRequest) (models.Load, error) {
+	inData := helpers.ImportLoad{
+		TransactionId: in.Id,
+		CustomerId:    in.CustomerId,
+		Amount:        in.LoadAmount,
+		Type:          in.Type,
+		Currency:      in.Currency,
+		DeviceId:      in.DeviceId,
+		Address:       in.Address,
+		FundingSource: in.FundingSource,
+		FundingType:   in.FundingType,
+		Channel:       in.Channel,
+		MerchantId:    in.MerchantId,
+		IpAddress:     in.IpAddress,
+		Metadata:      in.Metadata,
+	}
+	//A blank time is left as zero for InputToLoad to reject as missing
+	if len(in.Time) > 0 {
+		var err error
+		inData.Time, err = time.Parse(time.RFC3339, in.Time)
+		if err != nil {
+			return models.Load{}, fmt.Errorf("%w. time %q isn't an RFC 3339 time", errInvalidInput, in.Time)
+		}
+	}
+	return s.app.readLoad(inData)
+}
+
+//rpcError maps the errors the JSON handlers turn into status codes onto gRPC statuses
+func rpcError(err error) error {
+	switch {
+	case errors.Is(err, errInvalidInput):
+		return status.Error(codes.InvalidArgument, err.Error())
+	case errors.Is(err, errDuplicate):
+		return status.Error(codes.AlreadyExists, err.Error())
+	case errors.Is(err, models.ErrNoRecord):
+		return status.Error(codes.NotFound, err.Error())
+	default:
+		return status.Error(codes.Internal, err.Error())
+	}
+}
+
+//newDecision is the decision on a load, with its policy version and trace when explain is set
+func newDecision(load *models.Load, trace []models.RuleTrace, explain bool) *rpc.Decision {
+	decision := &rpc.Decision{
+		Id:         load.TransactionId,
+		CustomerId: load.CustomerId,
+		Accepted:   load.Accepted,
+		RiskScore:  load.RiskScore,
+		Outcome:    load.Outcome,
+		Reason:     load.Reason,
+	}
+	if explain {
+		decision.PolicyVersion = load.PolicyVersion
+		for _, rule := range trace {
+			decision.Trace = append(decision.Trace, &rpc.RuleTrace{
+				Rule:           rule.Rule,
+				WindowStart:    rule.WindowStart.Format(time.RFC3339Nano),
+				WindowEnd:      rule.WindowEnd.Format(time.RFC3339Nano),
+				TransactionIds: rule.TransactionIds,
+				Total:          rule.Total,
+				Threshold:      rule.Threshold,
+				Passed:         rule.Passed,
+				Shadow:         rule.Shadow,
+				Expression:     rule.Expression,
+				Values:         rule.Values,
+				Tier:           rule.Tier,
+				CustomerIds:    rule.CustomerIds,
+			})
+		}
+	}
+	return decision
+}
+
+func newCustomerUsage(headroom *engine.Headroom) *rpc.CustomerUsage {
+	usage := &rpc.CustomerUsage{
+		CustomerId:    headroom.CustomerId,
+		At:            headroom.At.Format(time.RFC3339Nano),
+		PolicyVersion: headroom.PolicyVersion,
+	}
+	for _, limit := range headroom.Limits {
+		usage.Limits = append(usage.Limits, &rpc.LimitUsage{
+			Name:      limit.Name,
+			Metric:    limit.Metric,
+			Window:    limit.Window,
+			Used:      limit.Used,
+			Max:       limit.Max,
+			Remaining: limit.Remaining,
+		})
+	}
+	if headroom.CoolingOffUntil != nil {
+		usage.CoolingOffUntil = headroom.CoolingOffUntil.Format(time.RFC3339Nano)
+	}
+	return usage
+}
@@ -0,0 +1,180 @@
+package main
+
+import (
+	"context"
+	"fireynis/velocity_checker/pkg/rpc"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/grpc/test/bufconn"
+	"io"
+	"net"
+	"testing"
+)
+
+//newTestGrpcClient serves the application's gRPC API over an in-process listener. The application's loads are kept
+//in memory so later calls see the loads stored by earlier ones.
+func newTestGrpcClient(t *testing.T) rpc.VelocityClient {
+	app := newTestApplication(t)
+	useMemoryLoads(app)
+
+	listener := bufconn.Listen(1024 * 1024)
+	server := app.grpcServer()
+	go func() {
+		_ = server.Serve(listener)
+	}()
+	t.Cleanup(server.Stop)
+
+	dialer := func(ctx context.Context, address string) (net.Conn, error) {
+		return listener.Dial()
+	}
+	conn, err := grpc.DialContext(context.Background(), "bufnet", grpc.WithContextDialer(dialer), grpc.WithInsecure())
+	if err != nil {
+		t.Fatalf("Unable to dial the gRPC server %v", err)
+	}
+	t.Cleanup(func() {
+		_ = conn.Close()
+	})
+	return rpc.NewVelocityClient(conn)
+}
+
+func TestGrpcServer(t *testing.T) {
+	client := newTestGrpcClient(t)
+	ctx := context.Background()
+	load := &rpc.LoadRequest{Id: "1", CustomerId: "1", LoadAmount: "$100.00", Time: "2000-01-01T00:00:00Z", Explain: true}
+
+	simulated, err := client.Simulate(ctx, load)
+	if err != nil {
+		t.Fatalf("Simulate() unexpected error %v", err)
+	}
+	if simulated.Outcome != "approve" || simulated.PolicyVersion != 1 || len(simulated.Trace) != 3 {
+		t.Errorf("Simulate() want an explained approval; got %v", simulated)
+	}
+	_, err = client.GetLoad(ctx, &rpc.GetLoadRequest{CustomerId: 1, Id: 1})
+	if status.Code(err) != codes.NotFound {
+		t.Errorf("GetLoad() after Simulate() want NotFound; got %v", err)
+	}
+
+	decision, err := client.Evaluate(ctx, load)
+	if err != nil {
+		t.Fatalf("Evaluate() unexpected error %v", err)
+	}
+	if !decision.Accepted || decision.Outcome != "approve" {
+		t.Errorf("Evaluate() want an approval; got %v", decision)
+	}
+	stored, err := client.GetLoad(ctx, &rpc.GetLoadRequest{CustomerId: 1, Id: 1})
+	if err != nil {
+		t.Fatalf("GetLoad() unexpected error %v", err)
+	}
+	if stored.Id != 1 || stored.CustomerId != 1 || stored.Outcome != "approve" {
+		t.Errorf("GetLoad() want the stored approval; got %v", stored)
+	}
+
+	usage, err := client.GetCustomerUsage(ctx, &rpc.GetCustomerUsageRequest{CustomerId: 1, At: "2000-01-01T12:00:00Z"})
+	if err != nil {
+		t.Fatalf("GetCustomerUsage() unexpected error %v", err)
+	}
+	wantRemaining := map[string]int64{"daily_load_count": 2, "daily_load_amount": 490000, "weekly_load_amount": 1990000}
+	if len(usage.Limits) != len(wantRemaining) {
+		t.Fatalf("GetCustomerUsage() want %d limits; got %v", len(wantRemaining), usage.Limits)
+	}
+	for _, limit := range usage.Limits {
+		if limit.Remaining != wantRemaining[limit.Name] {
+			t.Errorf("GetCustomerUsage() want %d remaining on %s; got %d", wantRemaining[limit.Name], limit.Name, limit.Remaining)
+		}
+	}
+
+	tests := []struct {
+		name     string
+		call     func() error
+		wantCode codes.Code
+	}{
+		{"Duplicate evaluate", func() error { _, err := client.Evaluate(ctx, load); return err }, codes.AlreadyExists},
+		{"Duplicate simulate", func() error { _, err := client.Simulate(ctx, load); return err }, codes.AlreadyExists},
+		{"Bad amount", func() error {
+			_, err := client.Evaluate(ctx, &rpc.LoadRequest{Id: "2", CustomerId: "1", LoadAmount: "lots", Time: "2000-01-01T00:00:00Z"})
+			return err
+		}, codes.InvalidArgument},
+		{"Bad time", func() error {
+			_, err := client.Evaluate(ctx, &rpc.LoadRequest{Id: "2", CustomerId: "1", LoadAmount: "$1.00", Time: "yesterday"})
+			return err
+		}, codes.InvalidArgument},
+		{"Unknown load", func() error { _, err := client.GetLoad(ctx, &rpc.GetLoadRequest{CustomerId: 9, Id: 1}); return err }, codes.NotFound},
+		{"Bad usage time", func() error {
+			_, err := client.GetCustomerUsage(ctx, &rpc.GetCustomerUsageRequest{CustomerId: 1, At: "yesterday"})
+			return err
+		}, codes.InvalidArgument},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := tt.call()
+			if status.Code(err) != tt.wantCode {
+				t.Errorf("want %s; got %v", tt.wantCode, err)
+			}
+		})
+	}
+}
+
+func TestGrpcBatchEvaluate(t *testing.T) {
+	client := newTestGrpcClient(t)
+
+	stream, err := client.BatchEvaluate(context.Background())
+	if err != nil {
+		t.Fatalf("BatchEvaluate() unexpected error %v", err)
+	}
+	requests := []*rpc.LoadRequest{
+		{Id: "1", CustomerId: "2", LoadAmount: "$3000.00", Time: "2000-01-01T00:00:00Z"},
+		{Id: "2", CustomerId: "2", LoadAmount: "$3000.00", Time: "2000-01-01T01:00:00Z"},
+		{Id: "3", CustomerId: "2", LoadAmount: "lots", Time: "2000-01-01T02:00:00Z"},
+		{Id: "1", CustomerId: "2", LoadAmount: "$1.00", Time: "2000-01-01T03:00:00Z"},
+		{Id: "4", CustomerId: "2", LoadAmount: "$1000.00", Time: "2000-01-02T00:00:00Z"},
+	}
+	for _, request := range requests {
+		err = stream.Send(request)
+		if err != nil {
+			t.Fatalf("Send() unexpected error %v", err)
+		}
+	}
+	err = stream.CloseSend()
+	if err != nil {
+		t.Fatalf("CloseSend() unexpected error %v", err)
+	}
+
+	var replies []*rpc.BatchDecision
+	for {
+		reply, err := stream.Recv()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			t.Fatalf("Recv() unexpected error %v", err)
+		}
+		replies = append(replies, reply)
+	}
+
+	want := []struct {
+		outcome string
+		code    string
+	}{
+		{"approve", ""},
+		{"decline", ""},
+		{"", codes.InvalidArgument.String()},
+		{"", codes.AlreadyExists.String()},
+		{"approve", ""},
+	}
+	if len(replies) != len(want) {
+		t.Fatalf("want %d replies; got %d", len(want), len(replies))
+	}
+	for i, reply := range replies {
+		if reply.Index != int64(i) {
+			t.Errorf("reply %d: want index %d; got %d", i, i, reply.Index)
+		}
+		if reply.Code != want[i].code {
+			t.Errorf("reply %d: want code %q; got %q (%s)", i, want[i].code, reply.Code, reply.Error)
+		}
+		if reply.GetDecision().GetOutcome() != want[i].outcome {
+			t.Errorf("reply %d: want outcome %q; got %v", i, want[i].outcome, reply.Decision)
+		}
+	}
+}
@@ -0,0 +1,357 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fireynis/velocity_checker/pkg/audit"
+	"fireynis/velocity_checker/pkg/engine"
+	"fireynis/velocity_checker/pkg/helpers"
+	"fireynis/velocity_checker/pkg/linkage"
+	"fireynis/velocity_checker/pkg/models"
+	"fireynis/velocity_checker/pkg/outbox"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+)
+
+func (a *application) parseLoad(w http.ResponseWriter, r *http.Request) {
+	if r.URL.Path != "/" {
+		http.NotFound(w, r)
+		return
+	}
+
+	decoder := json.NewDecoder(r.Body)
+
+	var inData helpers.ImportLoad
+
+	err := decoder.Decode(&inData)
+
+	if err != nil {
+		http.Error(w, fmt.Sprintf("Unable to parse json"), 400)
+		return
+	}
+
+	load, err := a.readLoad(inData)
+	if err != nil {
+		http.Error(w, err.Error(), 400)
+		return
+	}
+
+	trace, err := a.decide(&load)
+	if err != nil {
+		if errors.Is(err, errDuplicate) {
+			http.Error(w, err.Error(), 400)
+		} else {
+			http.Error(w, err.Error(), 500)
+		}
+		return
+	}
+
+	a.writeJson(w, newJsonOutput(&load, trace, r.URL.Query().Get("explain") == "true"))
+}
+
+//Errors from readLoad and decide that are the caller's fault, as opposed to failures on our side
+var (
+	errInvalidInput = errors.New("Data in is incorrect")
+	errDuplicate    = errors.New("Record already exists")
+)
+
+//readLoad checks the input and turns it into a load with its time and amount settled. Every error it returns wraps
+//errInvalidInput.
+func (a *application) readLoad(inData helpers.ImportLoad) (models.Load, error) {
+	load, err := helpers.InputToLoad(inData)
+	if err != nil {
+		return models.Load{}, fmt.Errorf("%w. %s", errInvalidInput, err)
+	}
+
+	err = a.timestamps.Apply(&load)
+	if err != nil {
+		return models.Load{}, fmt.Errorf("%w. %s", errInvalidInput, err)
+	}
+
+	err = a.rates.Apply(&load)
+	if err != nil {
+		return models.Load{}, fmt.Errorf("%w. %s", errInvalidInput, err)
+	}
+	return load, nil
+}
+
+//decide evaluates the load and stores it along with everything that follows from the decision. Only the duplicate
+//check, the evaluation and the insert, which writes the audit entry and webhook deliveries and settles the balance too,
+//can fail it. The rest is logged as the decision is already stored by then.
+func (a *application) decide(load *models.Load) ([]models.RuleTrace, error) {
+	err := a.checkDuplicate(load)
+	if err != nil {
+		return nil, err
+	}
+
+	trace, err := a.engine.Evaluate(load)
+	if err != nil {
+		return nil, errors.New(fmt.Sprintf("Error retrieving data. %s", err))
+	}
+
+	err = a.insertLoad(load)
+	//The unique index catches a load that raced another past the duplicate check
+	if errors.Is(err, models.ErrDuplicate) {
+		return nil, errDuplicate
+	}
+	if err != nil {
+		log.Printf("Unable to insert into loads table. %s", err)
+		return nil, errors.New(fmt.Sprintf("Unable to insert into loads table. %s", err))
+	}
+
+	engine.RecordShadowOutcomes(trace, load.Accepted)
+
+	//A missing trace only costs us the explanation, the decision itself is already stored
+	err = a.traces.Insert(load.Id, trace)
+	if err != nil {
+		log.Printf("Unable to insert into decision_traces table. %s", err)
+	}
+
+	err = linkage.Record(a.links, load)
+	if err != nil {
+		log.Printf("Unable to insert into customer_links table. %s", err)
+	}
+
+	err = a.engine.Notify(load, trace)
+	if err != nil {
+		log.Printf("Unable to send approaching-limit notifications. %s", err)
+	}
+
+	//Structuring is only ever built out of accepted loads
+	if load.Accepted {
+		_, err = a.structuring.Check(load)
+		if err != nil {
+			log.Printf("Unable to check for structuring. %s", err)
+		}
+	}
+
+	//Only set when late loads are to be checked for the decisions they would have changed
+	if a.recheck != nil {
+		_, err = a.recheck.Check(load)
+		if err != nil {
+			log.Printf("Unable to recheck the decisions after a late load. %s", err)
+		}
+	}
+	return trace, nil
+}
+
+//getTrace returns the stored evaluation trace for a load identified by the customer_id and id query parameters
+func (a *application) getTrace(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		w.Header().Set("Allow", http.MethodGet)
+		http.Error(w, "Method not allowed", 405)
+		return
+	}
+
+	customerId, err := strconv.ParseInt(r.URL.Query().Get("customer_id"), 10, 64)
+	if err != nil {
+		http.Error(w, "A numeric customer_id is required", 400)
+		return
+	}
+	transactionId, err := strconv.ParseInt(r.URL.Query().Get("id"), 10, 64)
+	if err != nil {
+		http.Error(w, "A numeric id is required", 400)
+		return
+	}
+
+	load, err := a.loads.GetByTransactionId(customerId, transactionId)
+	if err != nil {
+		if errors.Is(err, models.ErrNoRecord) {
+			http.NotFound(w, r)
+		} else {
+			http.Error(w, fmt.Sprintf("Error retrieving data. %s", err), 500)
+		}
+		return
+	}
+
+	trace, err := a.traces.GetByLoadId(load.Id)
+	if err != nil {
+		if errors.Is(err, models.ErrNoRecord) {
+			http.NotFound(w, r)
+		} else {
+			http.Error(w, fmt.Sprintf("Error retrieving data. %s", err), 500)
+		}
+		return
+	}
+
+	a.writeJson(w, jsonOutput{
+		Id:            load.TransactionId,
+		CustomerId:    load.CustomerId,
+		Accepted:      load.Accepted,
+		RiskScore:     load.RiskScore,
+		Outcome:       load.Outcome,
+		Reason:        load.Reason,
+		PolicyVersion: load.PolicyVersion,
+		Trace:         trace,
+	})
+}
+
+//overrideLoad lets an operator reverse a stored decision. The actor and note are required as they go on the audit log.
+//A load waiting on review is refused, it has to be approved or declined through the review queue so it leaves the
+//queue and isn't later declined by the SLA.
+func (a *application) overrideLoad(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		w.Header().Set("Allow", http.MethodPost)
+		http.Error(w, "Method not allowed", 405)
+		return
+	}
+
+	var inData overrideInput
+	err := json.NewDecoder(r.Body).Decode(&inData)
+	if err != nil {
+		http.Error(w, "Unable to parse json", 400)
+		return
+	}
+	if len(inData.Actor) < 1 || len(inData.Note) < 1 {
+		http.Error(w, "An actor and note are required", 400)
+		return
+	}
+
+	load, err := a.loads.GetByTransactionId(inData.CustomerId, inData.Id)
+	if err != nil {
+		if errors.Is(err, models.ErrNoRecord) {
+			http.NotFound(w, r)
+		} else {
+			http.Error(w, fmt.Sprintf("Error retrieving data. %s", err), 500)
+		}
+		return
+	}
+	if load.Status == models.StatusPendingReview {
+		http.Error(w, "Load is pending review, approve or decline it through /admin/reviews", 409)
+		return
+	}
+
+	previous := *load
+	load.Accepted = inData.Accepted
+	load.Outcome = models.OutcomeDecline
+	if load.Accepted {
+		load.Outcome = models.OutcomeApprove
+	}
+	deliveries, err := a.webhooks.NewDeliveries(audit.EventOverride, load)
+	if err != nil {
+		log.Printf("Unable to build the override's webhook deliveries. %s", err)
+		http.Error(w, fmt.Sprintf("Unable to build the override's webhook deliveries. %s", err), 500)
+		return
+	}
+	var event *models.OutboxEvent
+	if a.outbox != nil {
+		event, err = outbox.OverrideEvent(load)
+		if err != nil {
+			log.Printf("Unable to build the override's outbox event. %s", err)
+			http.Error(w, fmt.Sprintf("Unable to build the override's outbox event. %s", err), 500)
+			return
+		}
+	}
+
+	//The load, the move in the balance, the audit entry, the outbox event and the webhook deliveries are stored together
+	//or not at all
+	err = a.decisions.Update(&previous, load, audit.NewEntry(audit.EventOverride, load, inData.Actor, inData.Note), event, deliveries)
+	if err != nil {
+		if errors.Is(err, models.ErrChanged) {
+			http.Error(w, "Load was changed while it was being overridden, try again", 409)
+		} else {
+			log.Printf("Unable to store the override. %s", err)
+			http.Error(w, fmt.Sprintf("Unable to store the override. %s", err), 500)
+		}
+		return
+	}
+
+	a.writeJson(w, jsonOutput{
+		Id:         load.TransactionId,
+		CustomerId: load.CustomerId,
+		Accepted:   load.Accepted,
+		RiskScore:  load.RiskScore,
+		Outcome:    load.Outcome,
+		Reason:     load.Reason,
+	})
+}
+
+func (a *application) writeJson(w http.ResponseWriter, output interface{}) {
+	outJson, err := json.Marshal(output)
+	if err != nil {
+		log.Printf("Unable to marshall output json. %s", err)
+		http.Error(w, fmt.Sprintf("Unable to marshall output json. %s", err), 500)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	_, _ = w.Write(outJson)
+}
+
+//insertLoad stores the load along with its audit entry, the move in the customer's balance, its webhook deliveries, and
+//its decision event when the outbox is on, in one transaction. A decision that can't be audited or settled isn't stored. A load accepted on a
+//balance that has moved since is declined instead.
+func (a *application) insertLoad(load *models.Load) error {
+	var event *models.OutboxEvent
+	if a.outbox != nil {
+		var err error
+		event, err = outbox.DecisionEvent(load)
+		if err != nil {
+			return err
+		}
+	}
+
+	deliveries, err := a.webhooks.NewDeliveries(audit.EventDecision, load)
+	if err != nil {
+		return err
+	}
+
+	_, err = a.decisions.Insert(load, audit.NewEntry(audit.EventDecision, load, audit.ActorEngine, ""), event, deliveries)
+	//The balance moved past what the engine checked while the load was being decided, so it is stored declined
+	if errors.Is(err, models.ErrBalanceChanged) {
+		engine.DeclineBalanceChanged(load)
+		return a.insertLoad(load)
+	}
+	return err
+}
+
+//checkDuplicate returns errDuplicate when the customer already has a load with the same transaction id
+func (a *application) checkDuplicate(load *models.Load) error {
+	_, err := a.loads.GetByTransactionId(load.CustomerId, load.TransactionId)
+	//Ignoring a second load with the same id on a customer
+	if err == nil {
+		return errDuplicate
+	} else if !errors.Is(err, models.ErrNoRecord) {
+		log.Printf("Error checking for duplicate record. %s", err)
+		return errors.New(fmt.Sprintf("Error checking for duplicate record. %s", err))
+	}
+	return nil
+}
+
+//newJsonOutput is the decision on a load, with its policy version and trace when explain is set
+func newJsonOutput(load *models.Load, trace []models.RuleTrace, explain bool) jsonOutput {
+	output := jsonOutput{
+		Id:         load.TransactionId,
+		CustomerId: load.CustomerId,
+		Accepted:   load.Accepted,
+		RiskScore:  load.RiskScore,
+		Outcome:    load.Outcome,
+		Reason:     load.Reason,
+	}
+	if explain {
+		output.PolicyVersion = load.PolicyVersion
+		output.Trace = trace
+	}
+	return output
+}
+
+type jsonOutput struct {
+	Id            int64              `json:"id"`
+	CustomerId    int64              `json:"customer_id"`
+	Accepted      bool               `json:"accepted"`
+	RiskScore     int64              `json:"risk_score"`
+	Outcome       string             `json:"outcome"`
+	Reason        string             `json:"reason,omitempty"`
+	PolicyVersion int64              `json:"policy_version,omitempty"`
+	Trace         []models.RuleTrace `json:"trace,omitempty"`
+}
+
+type overrideInput struct {
+	Id         int64  `json:"id"`
+	CustomerId int64  `json:"customer_id"`
+	Accepted   bool   `json:"accepted"`
+	Actor      string `json:"actor"`
+	Note       string `json:"note"`
+}
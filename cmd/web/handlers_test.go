@@ -1,11 +1,20 @@
 package main
 
 import (
+	"encoding/json"
+	"errors"
+	"fireynis/velocity_checker/pkg/engine"
+	"fireynis/velocity_checker/pkg/models"
+	"fireynis/velocity_checker/pkg/models/mock"
+	"fireynis/velocity_checker/pkg/webhook"
+	"fmt"
 	"io/ioutil"
 	"net/http"
 	"net/http/httptest"
+	"reflect"
 	"strings"
 	"testing"
+	"time"
 )
 
 func TestParseLoad(t *testing.T) {
@@ -21,10 +30,23 @@ func TestParseLoad(t *testing.T) {
 		wantCode   int
 		wantString string
 	}{
-		{"Valid ID", "/", "{\"id\":\"4\",\"customer_id\":\"1\",\"load_amount\":\"$250.00\",\"time\":\"2000-01-01T00:00:00Z\"}", http.StatusOK, "{\"id\":4,\"customer_id\":1,\"accepted\":false}"},
-		{"Valid ID", "/", "{\"id\":\"2\",\"customer_id\":\"2\",\"load_amount\":\"$250.00\",\"time\":\"2000-01-01T00:00:00Z\"}", http.StatusOK, "{\"id\":2,\"customer_id\":2,\"accepted\":false}"},
-		{"Valid ID", "/", "{\"id\":\"2\",\"customer_id\":\"3\",\"load_amount\":\"$250.00\",\"time\":\"2000-01-02T00:00:00Z\"}", http.StatusOK, "{\"id\":2,\"customer_id\":3,\"accepted\":false}"},
-		{"Valid ID", "/", "{\"id\":\"2\",\"customer_id\":\"4\",\"load_amount\":\"$250.00\",\"time\":\"2000-01-01T00:00:00Z\"}", http.StatusOK, "{\"id\":2,\"customer_id\":4,\"accepted\":true}"},
+		{"Valid ID", "/", "{\"id\":\"4\",\"customer_id\":\"1\",\"load_amount\":\"$250.00\",\"time\":\"2000-01-01T00:00:00Z\"}", http.StatusOK, "{\"id\":4,\"customer_id\":1,\"accepted\":false,\"risk_score\":0,\"outcome\":\"decline\"}"},
+		{"Valid ID", "/", "{\"id\":\"2\",\"customer_id\":\"2\",\"load_amount\":\"$250.00\",\"time\":\"2000-01-01T00:00:00Z\"}", http.StatusOK, "{\"id\":2,\"customer_id\":2,\"accepted\":false,\"risk_score\":0,\"outcome\":\"decline\"}"},
+		{"Valid ID", "/", "{\"id\":\"2\",\"customer_id\":\"3\",\"load_amount\":\"$250.00\",\"time\":\"2000-01-02T00:00:00Z\"}", http.StatusOK, "{\"id\":2,\"customer_id\":3,\"accepted\":false,\"risk_score\":0,\"outcome\":\"decline\"}"},
+		{"Valid ID", "/", "{\"id\":\"2\",\"customer_id\":\"4\",\"load_amount\":\"$250.00\",\"time\":\"2000-01-01T00:00:00Z\"}", http.StatusOK, "{\"id\":2,\"customer_id\":4,\"accepted\":true,\"risk_score\":0,\"outcome\":\"approve\"}"},
+		{"Overdrawing debit", "/", "{\"id\":\"3\",\"customer_id\":\"4\",\"type\":\"debit\",\"load_amount\":\"$250.01\",\"time\":\"2000-01-01T01:00:00Z\"}", http.StatusOK, "{\"id\":3,\"customer_id\":4,\"accepted\":false,\"risk_score\":0,\"outcome\":\"decline\"}"},
+		{"Debit", "/", "{\"id\":\"4\",\"customer_id\":\"4\",\"type\":\"debit\",\"load_amount\":\"$250.00\",\"time\":\"2000-01-01T01:00:00Z\"}", http.StatusOK, "{\"id\":4,\"customer_id\":4,\"accepted\":true,\"risk_score\":0,\"outcome\":\"approve\"}"},
+		{"Unknown type", "/", "{\"id\":\"5\",\"customer_id\":\"4\",\"type\":\"refund\",\"load_amount\":\"$250.00\",\"time\":\"2000-01-01T01:00:00Z\"}", http.StatusBadRequest, "Data in is incorrect. unknown_type: unknown type \"refund\"\n"},
+		{"Extended fields", "/", "{\"id\":\"3\",\"customer_id\":\"6\",\"load_amount\":\"$250.00\",\"time\":\"2000-01-01T00:00:00Z\",\"channel\":\"Mobile\",\"funding_type\":\"card\",\"funding_source\":\"fp-1\",\"ip_address\":\"192.0.2.1\",\"metadata\":{\"campaign\":\"spring\"}}", http.StatusOK, "{\"id\":3,\"customer_id\":6,\"accepted\":true,\"risk_score\":0,\"outcome\":\"approve\"}"},
+		{"Euros", "/", "{\"id\":\"1\",\"customer_id\":\"7\",\"load_amount\":\"100.00\",\"currency\":\"eur\",\"time\":\"2000-01-01T00:00:00Z\"}", http.StatusOK, "{\"id\":1,\"customer_id\":7,\"accepted\":true,\"risk_score\":0,\"outcome\":\"approve\"}"},
+		{"No rate", "/", "{\"id\":\"2\",\"customer_id\":\"7\",\"load_amount\":\"100.00\",\"currency\":\"GBP\",\"time\":\"2000-01-01T00:00:00Z\"}", http.StatusBadRequest, "Data in is incorrect. no GBP rate effective at 2000-01-01T00:00:00Z\n"},
+		{"Unknown channel", "/", "{\"id\":\"4\",\"customer_id\":\"6\",\"load_amount\":\"$250.00\",\"time\":\"2000-01-01T00:00:00Z\",\"channel\":\"fax\"}", http.StatusBadRequest, "Data in is incorrect. unknown_channel: unknown channel \"fax\"\n"},
+		{"Bad ip address", "/", "{\"id\":\"4\",\"customer_id\":\"6\",\"load_amount\":\"$250.00\",\"time\":\"2000-01-01T00:00:00Z\",\"ip_address\":\"999.1.1.1\"}", http.StatusBadRequest, "Data in is incorrect. invalid_ip_address: invalid ip_address \"999.1.1.1\"\n"},
+		{"Negative amount", "/", "{\"id\":\"4\",\"customer_id\":\"6\",\"load_amount\":\"-$250.00\",\"time\":\"2000-01-01T00:00:00Z\"}", http.StatusBadRequest, "Data in is incorrect. negative_amount: negative amount -250.00\n"},
+		{"NaN amount", "/", "{\"id\":\"4\",\"customer_id\":\"6\",\"load_amount\":\"NaN\",\"time\":\"2000-01-01T00:00:00Z\"}", http.StatusBadRequest, "Data in is incorrect. invalid_amount: invalid amount \"NaN\"\n"},
+		{"Amount beyond int64 cents", "/", "{\"id\":\"4\",\"customer_id\":\"6\",\"load_amount\":\"$92233720368547758.08\",\"time\":\"2000-01-01T00:00:00Z\"}", http.StatusBadRequest, "Data in is incorrect. amount_out_of_range: amount too large, \"92233720368547758.08\" doesn't fit in USD minor units\n"},
+		{"Missing time", "/", "{\"id\":\"4\",\"customer_id\":\"6\",\"load_amount\":\"$250.00\"}", http.StatusBadRequest, "Data in is incorrect. missing_time: a time is required\n"},
+		{"Bad customer id", "/", "{\"id\":\"4\",\"customer_id\":\"six\",\"load_amount\":\"$250.00\",\"time\":\"2000-01-01T00:00:00Z\"}", http.StatusBadRequest, "Data in is incorrect. invalid_customer_id: customer_id \"six\" isn't a whole number\n"},
 	}
 
 	for _, tt := range tests {
@@ -50,3 +72,651 @@ func TestParseLoad(t *testing.T) {
 		})
 	}
 }
+
+func TestParseLoadExplain(t *testing.T) {
+	app := newTestApplication(t)
+
+	ts := httptest.NewTLSServer(app.routes())
+	defer ts.Close()
+
+	payload := "{\"id\":\"2\",\"customer_id\":\"2\",\"load_amount\":\"$250.00\",\"time\":\"2000-01-01T00:00:00Z\"}"
+	response, err := ts.Client().Post(ts.URL+"/?explain=true", "application/json", strings.NewReader(payload))
+	if err != nil {
+		t.Fatalf("Unexepcted error %v", err)
+	}
+	defer response.Body.Close()
+
+	var output jsonOutput
+	err = json.NewDecoder(response.Body).Decode(&output)
+	if err != nil {
+		t.Fatalf("Unable to decode response %v", err)
+	}
+
+	if output.PolicyVersion != 1 {
+		t.Errorf("want policy version 1; got %d", output.PolicyVersion)
+	}
+	if len(output.Trace) != 3 {
+		t.Fatalf("want 3 rules in trace; got %d", len(output.Trace))
+	}
+
+	wantPassed := map[string]bool{
+		"daily_load_count":   true,
+		"daily_load_amount":  false,
+		"weekly_load_amount": true,
+	}
+	for _, rule := range output.Trace {
+		if rule.Passed != wantPassed[rule.Rule] {
+			t.Errorf("rule %s: want passed %v; got %v", rule.Rule, wantPassed[rule.Rule], rule.Passed)
+		}
+	}
+	if output.Trace[1].Total != 525000 || output.Trace[1].Threshold != 500000 {
+		t.Errorf("want total 525000 and threshold 500000; got %d and %d", output.Trace[1].Total, output.Trace[1].Threshold)
+	}
+}
+
+func TestGetTrace(t *testing.T) {
+	app := newTestApplication(t)
+
+	ts := httptest.NewTLSServer(app.routes())
+	defer ts.Close()
+
+	tests := []struct {
+		name     string
+		urlPath  string
+		wantCode int
+	}{
+		{"Stored trace", "/trace?customer_id=4&id=1", http.StatusOK},
+		{"Unknown load", "/trace?customer_id=9&id=1", http.StatusNotFound},
+		{"Load without trace", "/trace?customer_id=1&id=1", http.StatusNotFound},
+		{"Bad customer id", "/trace?customer_id=a&id=1", http.StatusBadRequest},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			response, err := ts.Client().Get(ts.URL + tt.urlPath)
+			if err != nil {
+				t.Fatalf("Unexepcted error %v", err)
+			}
+			defer response.Body.Close()
+
+			if response.StatusCode != tt.wantCode {
+				t.Errorf("want %d; got %d", tt.wantCode, response.StatusCode)
+			}
+		})
+	}
+}
+
+func TestOverrideLoad(t *testing.T) {
+	app := newTestApplication(t)
+
+	ts := newAdminServer(app)
+	defer ts.Close()
+
+	tests := []struct {
+		name     string
+		payload  string
+		wantCode int
+	}{
+		{"Override", "{\"id\":1,\"customer_id\":1,\"accepted\":true,\"actor\":\"analyst\",\"note\":\"customer called in\"}", http.StatusOK},
+		{"Missing note", "{\"id\":1,\"customer_id\":1,\"accepted\":true,\"actor\":\"analyst\"}", http.StatusBadRequest},
+		{"Unknown load", "{\"id\":9,\"customer_id\":1,\"accepted\":true,\"actor\":\"analyst\",\"note\":\"n\"}", http.StatusNotFound},
+		{"Pending review", "{\"id\":1,\"customer_id\":5,\"accepted\":true,\"actor\":\"analyst\",\"note\":\"n\"}", http.StatusConflict},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			response, err := ts.Client().Post(ts.URL+"/override", "application/json", strings.NewReader(tt.payload))
+			if err != nil {
+				t.Fatalf("Unexepcted error %v", err)
+			}
+			defer response.Body.Close()
+
+			if response.StatusCode != tt.wantCode {
+				t.Errorf("want %d; got %d", tt.wantCode, response.StatusCode)
+			}
+		})
+	}
+
+	entries := app.auditLog.(*mock.Audit).Entries
+	if len(entries) != 1 || entries[0].Event != "override" || entries[0].Actor != "analyst" {
+		t.Errorf("want a single override entry by analyst; got %+v", entries)
+	}
+}
+
+func TestOverrideLoadStoredTogether(t *testing.T) {
+	app := newTestApplication(t)
+	decisions := app.decisions.(*mock.Decision)
+	deliveries := &mock.Delivery{}
+	decisions.Deliveries = deliveries
+	app.webhooks = &webhook.Dispatcher{
+		Subscriptions: []webhook.Subscription{{Id: "ledger", Url: "https://ledger.example.com", Events: []string{"override"}, Secret: "s3cret"}},
+		Deliveries:    deliveries,
+	}
+	store := &mock.Outbox{}
+	decisions.Outbox = store
+	app.outbox = store
+
+	ts := newAdminServer(app)
+	defer ts.Close()
+
+	override := func() int {
+		payload := "{\"id\":1,\"customer_id\":1,\"accepted\":false,\"actor\":\"analyst\",\"note\":\"chargeback\"}"
+		response, err := ts.Client().Post(ts.URL+"/override", "application/json", strings.NewReader(payload))
+		if err != nil {
+			t.Fatalf("Unexepcted error %v", err)
+		}
+		response.Body.Close()
+		return response.StatusCode
+	}
+
+	//A failed transaction stores none of the override
+	decisions.Fail = errors.New("connection reset")
+	if code := override(); code != http.StatusInternalServerError {
+		t.Errorf("want %d; got %d", http.StatusInternalServerError, code)
+	}
+	if entries := app.auditLog.(*mock.Audit).Entries; len(entries) != 0 || app.balances.(*mock.Balance).Balances[1] != 0 || len(deliveries.Deliveries) != 0 || len(store.Events) != 0 {
+		t.Errorf("want nothing stored; got entries %+v, balances %v and deliveries %+v", entries, app.balances.(*mock.Balance).Balances, deliveries.Deliveries)
+	}
+
+	decisions.Fail = nil
+	if code := override(); code != http.StatusOK {
+		t.Errorf("want %d; got %d", http.StatusOK, code)
+	}
+	if entries := app.auditLog.(*mock.Audit).Entries; len(entries) != 1 || app.balances.(*mock.Balance).Balances[1] != -250000 {
+		t.Errorf("want the entry and the load backed out; got entries %+v and balances %v", entries, app.balances.(*mock.Balance).Balances)
+	}
+	if len(deliveries.Deliveries) != 1 || deliveries.Deliveries[0].Event != "override" {
+		t.Errorf("want the override's delivery stored with it; got %+v", deliveries.Deliveries)
+	}
+	if len(store.Events) != 1 || store.Events[0].Event != "override" {
+		t.Errorf("want the override's outbox event stored with it; got %+v", store.Events)
+	}
+}
+
+func TestReviews(t *testing.T) {
+	app := newTestApplication(t)
+
+	ts := newAdminServer(app)
+	defer ts.Close()
+
+	response, err := ts.Client().Get(ts.URL + "/admin/reviews")
+	if err != nil {
+		t.Fatalf("Unexepcted error %v", err)
+	}
+	data, _ := ioutil.ReadAll(response.Body)
+	response.Body.Close()
+	want := "[{\"id\":1,\"customer_id\":5,\"load_amount\":\"$1000.00\",\"time\":\"2000-01-01T00:00:00Z\",\"risk_score\":60}]"
+	if string(data) != want {
+		t.Errorf("want %s; got %s", want, data)
+	}
+
+	tests := []struct {
+		name     string
+		urlPath  string
+		payload  string
+		wantCode int
+	}{
+		{"Claim", "/admin/reviews/claim", "{\"id\":1,\"customer_id\":5,\"analyst\":\"alice\"}", http.StatusOK},
+		{"Approve", "/admin/reviews/approve", "{\"id\":1,\"customer_id\":5,\"analyst\":\"alice\",\"note\":\"verified\"}", http.StatusOK},
+		{"Decline without note", "/admin/reviews/decline", "{\"id\":1,\"customer_id\":5,\"analyst\":\"alice\"}", http.StatusBadRequest},
+		{"Decline a decided load", "/admin/reviews/decline", "{\"id\":1,\"customer_id\":4,\"analyst\":\"alice\",\"note\":\"n\"}", http.StatusConflict},
+		{"Unknown load", "/admin/reviews/claim", "{\"id\":9,\"customer_id\":5,\"analyst\":\"alice\"}", http.StatusNotFound},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			response, err := ts.Client().Post(ts.URL+tt.urlPath, "application/json", strings.NewReader(tt.payload))
+			if err != nil {
+				t.Fatalf("Unexepcted error %v", err)
+			}
+			defer response.Body.Close()
+
+			if response.StatusCode != tt.wantCode {
+				t.Errorf("want %d; got %d", tt.wantCode, response.StatusCode)
+			}
+		})
+	}
+
+	//A wrong token is unauthorised, and with no token configured the admin endpoints are refused to everyone
+	for _, token := range []string{testAdminToken, ""} {
+		app.adminToken = token
+		wantCode := http.StatusUnauthorized
+		if len(token) < 1 {
+			wantCode = http.StatusForbidden
+		}
+
+		for _, urlPath := range []string{"/admin/reviews", "/debug/vars"} {
+			request, _ := http.NewRequest(http.MethodGet, ts.URL+urlPath, nil)
+			request.Header.Set("Authorization", "Bearer wrong")
+			response, err = ts.Client().Do(request)
+			if err != nil {
+				t.Fatalf("Unexepcted error %v", err)
+			}
+			response.Body.Close()
+			if response.StatusCode != wantCode {
+				t.Errorf("want %d for %s with admin token %q; got %d", wantCode, urlPath, token, response.StatusCode)
+			}
+		}
+	}
+}
+
+func TestListAlerts(t *testing.T) {
+	app := newTestApplication(t)
+	windowEnd := time.Date(2000, 1, 3, 23, 59, 59, 999999999, time.UTC)
+	_ = app.alerts.Insert(&models.Alert{
+		CustomerId:     1,
+		Pattern:        "near_limit_loads",
+		WindowStart:    time.Date(2000, 1, 1, 0, 0, 0, 0, time.UTC),
+		WindowEnd:      windowEnd,
+		TransactionIds: []int64{1, 2, 3},
+	})
+
+	ts := newAdminServer(app)
+	defer ts.Close()
+
+	tests := []struct {
+		name      string
+		query     string
+		wantCode  int
+		wantCount int
+	}{
+		{"Covering the window", "?from=2000-01-03&to=2000-01-03", http.StatusOK, 1},
+		{"Before the window", "?from=1999-12-01&to=2000-01-02", http.StatusOK, 0},
+		{"No dates", "", http.StatusBadRequest, 0},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			response, err := ts.Client().Get(ts.URL + "/admin/alerts" + tt.query)
+			if err != nil {
+				t.Fatalf("Unexepcted error %v", err)
+			}
+			defer response.Body.Close()
+
+			if response.StatusCode != tt.wantCode {
+				t.Fatalf("want %d; got %d", tt.wantCode, response.StatusCode)
+			}
+			if tt.wantCode != http.StatusOK {
+				return
+			}
+
+			var alerts []models.Alert
+			err = json.NewDecoder(response.Body).Decode(&alerts)
+			if err != nil {
+				t.Fatalf("Unexepcted error %v", err)
+			}
+			if len(alerts) != tt.wantCount {
+				t.Errorf("want %d alerts; got %d", tt.wantCount, len(alerts))
+			}
+		})
+	}
+}
+
+func TestListCorrections(t *testing.T) {
+	app := newTestApplication(t)
+	_ = app.corrections.Insert(&models.Correction{
+		CustomerId:        1,
+		TransactionId:     3,
+		WasOutcome:        models.OutcomeApprove,
+		Outcome:           models.OutcomeDecline,
+		FailedRules:       []string{"daily_load_amount"},
+		LateCustomerId:    1,
+		LateTransactionId: 4,
+	})
+	today := time.Now().UTC().Format("2006-01-02")
+
+	ts := newAdminServer(app)
+	defer ts.Close()
+
+	tests := []struct {
+		name      string
+		query     string
+		wantCode  int
+		wantCount int
+	}{
+		{"Today", "?from=" + today + "&to=" + today, http.StatusOK, 1},
+		{"Long ago", "?from=1999-12-01&to=2000-01-02", http.StatusOK, 0},
+		{"No dates", "", http.StatusBadRequest, 0},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			response, err := ts.Client().Get(ts.URL + "/admin/corrections" + tt.query)
+			if err != nil {
+				t.Fatalf("Unexepcted error %v", err)
+			}
+			defer response.Body.Close()
+
+			if response.StatusCode != tt.wantCode {
+				t.Fatalf("want %d; got %d", tt.wantCode, response.StatusCode)
+			}
+			if tt.wantCode != http.StatusOK {
+				return
+			}
+
+			var corrections []models.Correction
+			err = json.NewDecoder(response.Body).Decode(&corrections)
+			if err != nil {
+				t.Fatalf("Unexepcted error %v", err)
+			}
+			if len(corrections) != tt.wantCount {
+				t.Errorf("want %d corrections; got %d", tt.wantCount, len(corrections))
+			}
+		})
+	}
+}
+
+func TestGetHeadroom(t *testing.T) {
+	app := newTestApplication(t)
+	ts := httptest.NewTLSServer(app.routes())
+	defer ts.Close()
+
+	tests := []struct {
+		name          string
+		query         string
+		wantCode      int
+		wantRemaining map[string]int64
+	}{
+		{"Customer with loads", "?customer_id=1&at=2000-01-01T12:00:00Z", http.StatusOK, map[string]int64{"daily_load_count": 1, "daily_load_amount": 0, "weekly_load_amount": 1500000}},
+		{"No customer", "?at=2000-01-01T12:00:00Z", http.StatusBadRequest, nil},
+		{"Bad time", "?customer_id=1&at=yesterday", http.StatusBadRequest, nil},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			response, err := ts.Client().Get(ts.URL + "/headroom" + tt.query)
+			if err != nil {
+				t.Fatalf("Unexepcted error %v", err)
+			}
+			defer response.Body.Close()
+
+			if response.StatusCode != tt.wantCode {
+				t.Fatalf("want %d; got %d", tt.wantCode, response.StatusCode)
+			}
+			if tt.wantCode != http.StatusOK {
+				return
+			}
+
+			var headroom engine.Headroom
+			err = json.NewDecoder(response.Body).Decode(&headroom)
+			if err != nil {
+				t.Fatalf("Unexepcted error %v", err)
+			}
+			if len(headroom.Limits) != len(tt.wantRemaining) {
+				t.Fatalf("want %d limits; got %+v", len(tt.wantRemaining), headroom.Limits)
+			}
+			for _, limit := range headroom.Limits {
+				if limit.Remaining != tt.wantRemaining[limit.Name] {
+					t.Errorf("want %d remaining on %s; got %d", tt.wantRemaining[limit.Name], limit.Name, limit.Remaining)
+				}
+			}
+			if headroom.CoolingOffUntil != nil {
+				t.Errorf("want no cooling off; got %v", headroom.CoolingOffUntil)
+			}
+		})
+	}
+}
+
+func TestRedeliverWebhook(t *testing.T) {
+	subscriber := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	defer subscriber.Close()
+
+	app := newTestApplication(t)
+	deliveries := &mock.Delivery{}
+	app.webhooks = &webhook.Dispatcher{
+		Subscriptions: []webhook.Subscription{{Id: "ledger", Url: subscriber.URL, Events: []string{"decision"}, Secret: "s3cret"}},
+		Deliveries:    deliveries,
+		Client:        subscriber.Client(),
+	}
+	_ = deliveries.Insert(&models.Delivery{Url: subscriber.URL, Event: "decision", Payload: []byte(`{"id":1}`), Status: models.DeliveryFailed, Attempts: 8})
+
+	ts := newAdminServer(app)
+	defer ts.Close()
+
+	listFailed := func() []models.Delivery {
+		response, err := ts.Client().Get(ts.URL + "/admin/deliveries?status=failed")
+		if err != nil {
+			t.Fatalf("Unexepcted error %v", err)
+		}
+		defer response.Body.Close()
+
+		var failed []models.Delivery
+		err = json.NewDecoder(response.Body).Decode(&failed)
+		if err != nil {
+			t.Fatalf("Unexepcted error %v", err)
+		}
+		return failed
+	}
+
+	if failed := listFailed(); len(failed) != 1 || failed[0].Attempts != 8 {
+		t.Fatalf("want the one failed delivery; got %+v", failed)
+	}
+
+	tests := []struct {
+		name     string
+		body     string
+		wantCode int
+	}{
+		{"Unknown delivery", `{"id":2}`, http.StatusNotFound},
+		{"Bad json", `{"id":`, http.StatusBadRequest},
+		{"Failed delivery", `{"id":1}`, http.StatusOK},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			response, err := ts.Client().Post(ts.URL+"/admin/deliveries/redeliver", "application/json", strings.NewReader(tt.body))
+			if err != nil {
+				t.Fatalf("Unexepcted error %v", err)
+			}
+			defer response.Body.Close()
+
+			if response.StatusCode != tt.wantCode {
+				t.Fatalf("want %d; got %d", tt.wantCode, response.StatusCode)
+			}
+		})
+	}
+
+	if failed := listFailed(); len(failed) != 0 {
+		t.Errorf("want no failed deliveries after redelivering; got %+v", failed)
+	}
+}
+
+func TestParseLoadOutbox(t *testing.T) {
+	app := newTestApplication(t)
+	store := &mock.Outbox{}
+	deliveries := &mock.Delivery{}
+	decisions := &mock.Decision{Loads: app.loads, AuditLog: app.auditLog, Balances: app.balances, Outbox: store, Deliveries: deliveries}
+	app.outbox = store
+	app.decisions = decisions
+	app.webhooks = &webhook.Dispatcher{
+		Subscriptions: []webhook.Subscription{{Id: "ledger", Url: "https://ledger.example.com", Events: []string{"decision"}, Secret: "s3cret"}},
+		Deliveries:    deliveries,
+	}
+
+	ts := httptest.NewTLSServer(app.routes())
+	defer ts.Close()
+
+	tests := []struct {
+		name        string
+		payload     string
+		fail        error
+		wantCode    int
+		wantEvents  []string
+		wantEntries int
+		wantBalance int64
+	}{
+		{"Stored with its event", "{\"id\":\"2\",\"customer_id\":\"4\",\"load_amount\":\"$250.00\",\"time\":\"2000-01-01T00:00:00Z\"}", nil, http.StatusOK, []string{"decision:4:2"}, 1, 25000},
+		{"Neither stored", "{\"id\":\"3\",\"customer_id\":\"4\",\"load_amount\":\"$250.00\",\"time\":\"2000-01-01T01:00:00Z\"}", errors.New("connection reset"), http.StatusInternalServerError, []string{"decision:4:2"}, 1, 25000},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			decisions.Fail = tt.fail
+			response, err := ts.Client().Post(ts.URL+"/", "application/json", strings.NewReader(tt.payload))
+			if err != nil {
+				t.Fatalf("Unexepcted error %v", err)
+			}
+			defer response.Body.Close()
+
+			if response.StatusCode != tt.wantCode {
+				t.Errorf("want %d; got %d", tt.wantCode, response.StatusCode)
+			}
+			var keys []string
+			for _, event := range store.Events {
+				keys = append(keys, event.Key)
+			}
+			if !reflect.DeepEqual(keys, tt.wantEvents) {
+				t.Errorf("want events %v; got %v", tt.wantEvents, keys)
+			}
+			if entries := app.auditLog.(*mock.Audit).Entries; len(entries) != tt.wantEntries {
+				t.Errorf("want %d audit entries; got %d", tt.wantEntries, len(entries))
+			}
+			if balance, _ := app.balances.Get(4); balance != tt.wantBalance {
+				t.Errorf("want a balance of %d; got %d", tt.wantBalance, balance)
+			}
+			//Each decision stored has its one delivery stored with it
+			if len(deliveries.Deliveries) != tt.wantEntries {
+				t.Errorf("want %d webhook deliveries; got %d", tt.wantEntries, len(deliveries.Deliveries))
+			}
+		})
+	}
+}
+
+func TestParseLoadRacedDuplicate(t *testing.T) {
+	app := newTestApplication(t)
+	app.decisions = &mock.Decision{Loads: app.loads, AuditLog: app.auditLog, Fail: models.ErrDuplicate}
+	ts := httptest.NewTLSServer(app.routes())
+	defer ts.Close()
+
+	//The duplicate check passes but the insert hits the unique index, as when another writer got there first
+	payload := "{\"id\":\"2\",\"customer_id\":\"4\",\"load_amount\":\"$250.00\",\"time\":\"2000-01-01T00:00:00Z\"}"
+	response, err := ts.Client().Post(ts.URL+"/", "application/json", strings.NewReader(payload))
+	if err != nil {
+		t.Fatalf("Unexepcted error %v", err)
+	}
+	defer response.Body.Close()
+
+	data, _ := ioutil.ReadAll(response.Body)
+	if response.StatusCode != http.StatusBadRequest || string(data) != "Record already exists\n" {
+		t.Errorf("want %d and Record already exists; got %d and %s", http.StatusBadRequest, response.StatusCode, data)
+	}
+}
+
+func TestParseLoadBalanceChanged(t *testing.T) {
+	app := newTestApplication(t)
+	useMemoryLoads(app)
+	//The engine reads a balance that another load has since spent
+	app.engine.Balances = &mock.Balance{Balances: map[int64]int64{8: 50000}}
+	ts := httptest.NewTLSServer(app.routes())
+	defer ts.Close()
+
+	payload := "{\"id\":\"1\",\"customer_id\":\"8\",\"type\":\"debit\",\"load_amount\":\"$250.00\",\"time\":\"2000-01-01T00:00:00Z\"}"
+	response, err := ts.Client().Post(ts.URL+"/", "application/json", strings.NewReader(payload))
+	if err != nil {
+		t.Fatalf("Unexepcted error %v", err)
+	}
+	defer response.Body.Close()
+
+	data, _ := ioutil.ReadAll(response.Body)
+	want := "{\"id\":1,\"customer_id\":8,\"accepted\":false,\"risk_score\":0,\"outcome\":\"decline\",\"reason\":\"BALANCE_CHANGED\"}"
+	if response.StatusCode != http.StatusOK || string(data) != want {
+		t.Errorf("want %d and %s; got %d and %s", http.StatusOK, want, response.StatusCode, data)
+	}
+
+	stored, err := app.loads.GetByTransactionId(8, 1)
+	if err != nil || stored.Accepted {
+		t.Errorf("want the debit stored declined; got %+v, %v", stored, err)
+	}
+	if balance, _ := app.balances.Get(8); balance != 0 {
+		t.Errorf("want the balance left at 0; got %d", balance)
+	}
+}
+
+func TestBatchLoads(t *testing.T) {
+	app := newTestApplication(t)
+	useMemoryLoads(app)
+	ts := httptest.NewTLSServer(app.routes())
+	defer ts.Close()
+
+	batch := strings.Join([]string{
+		"{\"id\":\"1\",\"customer_id\":\"2\",\"load_amount\":\"$3000.00\",\"time\":\"2000-01-01T00:00:00Z\"}",
+		"{\"id\":\"2\",\"customer_id\":\"2\",\"load_amount\":\"$3000.00\",\"time\":\"2000-01-01T01:00:00Z\"}",
+		"",
+		"{\"id\":\"3\",\"customer_id\":\"2\",\"load_amount\":\"lots\",\"time\":\"2000-01-01T02:00:00Z\"}",
+		"not json",
+		"{\"id\":\"1\",\"customer_id\":\"2\",\"load_amount\":\"$1.00\",\"time\":\"2000-01-01T03:00:00Z\"}",
+		"{\"id\":\"1\",\"customer_id\":\"3\",\"load_amount\":\"$3000.00\",\"time\":\"2000-01-01T04:00:00Z\"}",
+	}, "\n") + "\n"
+
+	response, err := ts.Client().Post(ts.URL+"/loads/batch", "application/x-ndjson", strings.NewReader(batch))
+	if err != nil {
+		t.Fatalf("Unexepcted error %v", err)
+	}
+	defer response.Body.Close()
+
+	if response.StatusCode != http.StatusOK {
+		t.Fatalf("want %d; got %d", http.StatusOK, response.StatusCode)
+	}
+	if response.Header.Get("Content-Type") != "application/x-ndjson" {
+		t.Errorf("want application/x-ndjson; got %s", response.Header.Get("Content-Type"))
+	}
+
+	data, _ := ioutil.ReadAll(response.Body)
+	want := "{\"line\":1,\"id\":1,\"customer_id\":2,\"accepted\":true,\"risk_score\":0,\"outcome\":\"approve\"}\n" +
+		"{\"line\":2,\"id\":2,\"customer_id\":2,\"accepted\":false,\"risk_score\":0,\"outcome\":\"decline\"}\n" +
+		"{\"line\":4,\"error\":\"Data in is incorrect. invalid_amount: invalid amount \\\"lots\\\"\",\"status\":400}\n" +
+		"{\"line\":5,\"error\":\"Unable to parse json\",\"status\":400}\n" +
+		"{\"line\":6,\"error\":\"Record already exists\",\"status\":400}\n" +
+		"{\"line\":7,\"id\":1,\"customer_id\":3,\"accepted\":true,\"risk_score\":0,\"outcome\":\"approve\"}\n"
+	if string(data) != want {
+		t.Errorf("want\n%s\ngot\n%s", want, data)
+	}
+
+	response, err = ts.Client().Get(ts.URL + "/loads/batch")
+	if err != nil {
+		t.Fatalf("Unexepcted error %v", err)
+	}
+	response.Body.Close()
+	if response.StatusCode != http.StatusMethodNotAllowed {
+		t.Errorf("want %d; got %d", http.StatusMethodNotAllowed, response.StatusCode)
+	}
+}
+
+//TestBatchLoadsLarge sends a batch far larger than the server's read buffer over plain HTTP/1.1, where replying before
+//the body has been read in full would cut the batch short
+func TestBatchLoadsLarge(t *testing.T) {
+	app := newTestApplication(t)
+	useMemoryLoads(app)
+	ts := httptest.NewServer(app.routes())
+	defer ts.Close()
+
+	var batch strings.Builder
+	for customer := 1; customer <= 2000; customer++ {
+		fmt.Fprintf(&batch, "{\"id\":\"1\",\"customer_id\":\"%d\",\"load_amount\":\"$10.00\",\"time\":\"2000-01-01T00:00:00Z\"}\n", customer)
+	}
+
+	response, err := ts.Client().Post(ts.URL+"/loads/batch", "application/x-ndjson", strings.NewReader(batch.String()))
+	if err != nil {
+		t.Fatalf("Unexepcted error %v", err)
+	}
+	defer response.Body.Close()
+
+	replies := 0
+	decoder := json.NewDecoder(response.Body)
+	for decoder.More() {
+		var reply map[string]interface{}
+		err = decoder.Decode(&reply)
+		if err != nil {
+			t.Fatalf("Unexepcted error %v after %d replies", err, replies)
+		}
+		replies++
+		if reply["accepted"] != true || int(reply["line"].(float64)) != replies {
+			t.Fatalf("want line %d accepted; got %v", replies, reply)
+		}
+	}
+	if replies != 2000 {
+		t.Errorf("want 2000 replies; got %d", replies)
+	}
+}
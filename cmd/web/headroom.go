@@ -0,0 +1,39 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+//getHeadroom returns how much of each limit the customer identified by the customer_id query parameter has left, and
+//when their cooling-off lockout ends if they are in one. It is worked out as of now unless an RFC 3339 at is given.
+func (a *application) getHeadroom(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		w.Header().Set("Allow", http.MethodGet)
+		http.Error(w, "Method not allowed", 405)
+		return
+	}
+
+	customerId, err := strconv.ParseInt(r.URL.Query().Get("customer_id"), 10, 64)
+	if err != nil {
+		http.Error(w, "A numeric customer_id is required", 400)
+		return
+	}
+	at := time.Now().UTC()
+	if len(r.URL.Query().Get("at")) > 0 {
+		at, err = time.Parse(time.RFC3339, r.URL.Query().Get("at"))
+		if err != nil {
+			http.Error(w, "at must be an RFC 3339 time", 400)
+			return
+		}
+	}
+
+	headroom, err := a.engine.Headroom(customerId, at)
+	if err != nil {
+		http.Error(w, fmt.Sprintf("Error retrieving data. %s", err), 500)
+		return
+	}
+	a.writeJson(w, headroom)
+}
@@ -2,15 +2,20 @@ package main
 
 import (
 	"context"
-	"encoding/json"
-	"errors"
+	"fireynis/velocity_checker/pkg/currency"
+	"fireynis/velocity_checker/pkg/detect"
+	"fireynis/velocity_checker/pkg/engine"
 	"fireynis/velocity_checker/pkg/helpers"
 	"fireynis/velocity_checker/pkg/models"
 	"fireynis/velocity_checker/pkg/models/postgres"
+	"fireynis/velocity_checker/pkg/notify"
+	"fireynis/velocity_checker/pkg/outbox"
+	"fireynis/velocity_checker/pkg/report"
+	"fireynis/velocity_checker/pkg/review"
 	"fireynis/velocity_checker/pkg/validators"
+	"fireynis/velocity_checker/pkg/webhook"
 	"flag"
-	"fmt"
-	"github.com/jackc/pgx/v4"
+	"github.com/jackc/pgx/v4/pgxpool"
 	"github.com/joho/godotenv"
 	"log"
 	"net/http"
@@ -19,14 +24,41 @@ import (
 )
 
 type application struct {
-	loads         models.ILoads
-	loadValidator validators.ILoadValidator
+	loads       models.ILoads
+	traces      models.ITraces
+	auditLog    models.IAudit
+	alerts      models.IAlerts
+	links       models.ILinks
+	balances    models.IBalances
+	corrections models.ICorrections
+	engine      *engine.Engine
+	reviews     *review.Queue
+	structuring *detect.Structuring
+	recheck     *report.Recheck
+	rates       *currency.Rates
+	timestamps  *helpers.Timestamps
+	webhooks    *webhook.Dispatcher
+	decisions   models.IDecisions
+	outbox      models.IOutbox
+	relay       *outbox.Relay
+	adminToken  string
 }
 
 func main() {
 
 	var flagDsn = flag.String("dsn", "", "The connection string for the postgres database. Overrides the .env DATABASE_DSN")
 	var flagPort = flag.String("port", "8080", "Sets the port to listen on for the server. Can be set in .env which overrides this option. Defaults to 8080")
+	var flagGrpcPort = flag.String("grpc_port", "", "The port to serve the gRPC API on. Overrides the .env GRPC_PORT. Defaults to 9090")
+	var flagBaseCurrency = flag.String("base_currency", "", "The ISO 4217 currency limits are evaluated in. Overrides the .env BASE_CURRENCY. Defaults to USD")
+	var flagFxRates = flag.String("fx_rates", "", "The path to a csv of exchange rates into the base currency. Overrides the .env FX_RATES_FILE")
+	var flagReviewSla = flag.String("review_sla", "", "How long a load can wait for review before it is declined. Overrides the .env REVIEW_SLA. Defaults to 24h")
+	var flagMaxFutureSkew = flag.String("max_future_skew", "", "How far ahead of the server a load's time can be. Overrides the .env MAX_FUTURE_SKEW. Unbounded when blank or 0")
+	var flagMaxBackdate = flag.String("max_backdate", "", "How far behind the server a load's time can be. Overrides the .env MAX_BACKDATE. Unbounded when blank or 0")
+	var flagEvaluateOn = flag.String("evaluate_on", "", "Evaluate loads on the client's time or the time they were received, client or received. Overrides the .env EVALUATE_ON. Defaults to client")
+	var flagRecheckLate = flag.Bool("recheck_late", false, "Flag decisions a late load would have changed. Can be turned on in .env with RECHECK_LATE=true")
+	var flagWebhooks = flag.String("webhooks", "", "The path to a json file of webhook subscriptions. Overrides the .env WEBHOOKS_FILE. No webhooks are sent when blank")
+	var flagOutbox = flag.String("outbox", "", "Comma separated sinks to relay decision events to from the outbox, stdout, urls or file paths. Overrides the .env OUTBOX. Off when blank")
+	var flagNotify = flag.String("notify", "", "Where to send approaching-limit notifications, stdout, a webhook url or a file path. Overrides the .env NOTIFY. Off when blank")
 	flag.Parse()
 
 	err := godotenv.Load()
@@ -52,95 +84,156 @@ func main() {
 		log.Fatalf("A port is required.")
 	}
 
-	dbConn, err := pgx.Connect(context.Background(), dsn)
-
-	if err != nil {
-		log.Fatalf("Unable to connect to database. %s", err)
+	grpcPort := "9090"
+	if len(*flagGrpcPort) >= 1 {
+		grpcPort = *flagGrpcPort
+	} else if len(os.Getenv("GRPC_PORT")) >= 1 {
+		grpcPort = os.Getenv("GRPC_PORT")
 	}
-	defer dbConn.Close(context.Background())
 
-	app := &application{
-		loads:         &postgres.LoadModel{DB: dbConn},
-		loadValidator: &validators.LoadValidator{},
+	reviewSla := 24 * time.Hour
+	if len(*flagReviewSla) >= 1 || len(os.Getenv("REVIEW_SLA")) >= 1 {
+		rawSla := *flagReviewSla
+		if len(rawSla) < 1 {
+			rawSla = os.Getenv("REVIEW_SLA")
+		}
+		reviewSla, err = time.ParseDuration(rawSla)
+		if err != nil {
+			log.Fatalf("Unable to parse the review SLA. %s", err)
+		}
 	}
 
-	err = http.ListenAndServe(":"+port, app.routes())
-	log.Fatal(err)
-}
+	//Unbounded unless set, the same as the CLI, so a load is never rejected by one and decided by the other
+	maxFutureSkew := *flagMaxFutureSkew
+	if len(maxFutureSkew) < 1 {
+		maxFutureSkew = os.Getenv("MAX_FUTURE_SKEW")
+	}
+	maxBackdate := *flagMaxBackdate
+	if len(maxBackdate) < 1 {
+		maxBackdate = os.Getenv("MAX_BACKDATE")
+	}
+	evaluateOn := *flagEvaluateOn
+	if len(evaluateOn) < 1 {
+		evaluateOn = os.Getenv("EVALUATE_ON")
+	}
+	timestamps, err := helpers.NewTimestamps(maxFutureSkew, maxBackdate, evaluateOn)
+	if err != nil {
+		log.Fatalf("Unable to parse the timestamp settings. %s", err)
+	}
 
-func (a *application) parseLoad(w http.ResponseWriter, r *http.Request) {
-	if r.URL.Path != "/" {
-		http.NotFound(w, r)
-		return
+	base := currency.Default
+	if len(*flagBaseCurrency) >= 1 {
+		base = *flagBaseCurrency
+	} else if len(os.Getenv("BASE_CURRENCY")) >= 1 {
+		base = os.Getenv("BASE_CURRENCY")
 	}
 
-	decoder := json.NewDecoder(r.Body)
+	var rates *currency.Rates
+	if len(*flagFxRates) >= 1 {
+		rates, err = currency.LoadRates(*flagFxRates, base)
+	} else if len(os.Getenv("FX_RATES_FILE")) >= 1 {
+		rates, err = currency.LoadRates(os.Getenv("FX_RATES_FILE"), base)
+	} else {
+		rates, err = currency.NewRates(base)
+	}
+	if err != nil {
+		log.Fatalf("Unable to load exchange rates. %s", err)
+	}
 
-	var inData helpers.ImportLoad
+	webhooksFile := *flagWebhooks
+	if len(webhooksFile) < 1 {
+		webhooksFile = os.Getenv("WEBHOOKS_FILE")
+	}
+	var subscriptions []webhook.Subscription
+	if len(webhooksFile) >= 1 {
+		subscriptions, err = webhook.OpenSubscriptions(webhooksFile)
+		if err != nil {
+			log.Fatalf("Unable to load the webhook subscriptions. %s", err)
+		}
+	}
 
-	err := decoder.Decode(&inData)
+	outboxTargets := *flagOutbox
+	if len(outboxTargets) < 1 {
+		outboxTargets = os.Getenv("OUTBOX")
+	}
+	sinks, err := outbox.OpenSinks(outboxTargets)
+	if err != nil {
+		log.Fatalf("Unable to open the outbox sinks. %s", err)
+	}
 
+	notifyTarget := *flagNotify
+	if len(notifyTarget) < 1 {
+		notifyTarget = os.Getenv("NOTIFY")
+	}
+	notifier, err := notify.Open(notifyTarget)
 	if err != nil {
-		http.Error(w, fmt.Sprintf("Unable to parse json"), 400)
-		return
+		log.Fatalf("Unable to open the notification sink. %s", err)
 	}
 
-	load, err := helpers.InputToLoad(inData)
+	dbPool, err := pgxpool.Connect(context.Background(), dsn)
 
 	if err != nil {
-		http.Error(w, fmt.Sprintf("Data in is incorrect. %s", err), 400)
-		return
+		log.Fatalf("Unable to connect to database. %s", err)
+	}
+	defer dbPool.Close()
+
+	loads := &postgres.LoadModel{DB: dbPool}
+	policies := &postgres.PolicyModel{DB: dbPool}
+	auditLog := &postgres.AuditModel{DB: dbPool}
+	alerts := &postgres.AlertModel{DB: dbPool}
+	links := &postgres.LinkModel{DB: dbPool}
+	balances := &postgres.BalanceModel{DB: dbPool}
+	corrections := &postgres.CorrectionModel{DB: dbPool}
+	decisions := &postgres.DecisionModel{DB: dbPool}
+	app := &application{
+		loads:       loads,
+		traces:      &postgres.TraceModel{DB: dbPool},
+		auditLog:    auditLog,
+		alerts:      alerts,
+		links:       links,
+		balances:    balances,
+		corrections: corrections,
+		decisions:   decisions,
+		engine: &engine.Engine{
+			Loads:         loads,
+			Policies:      policies,
+			Validator:     &validators.LoadValidator{},
+			Links:         links,
+			Balances:      balances,
+			Notifier:      notifier,
+			Notifications: &postgres.NotificationModel{DB: dbPool},
+		},
+		reviews:     &review.Queue{Loads: loads, Decisions: decisions, Sla: reviewSla},
+		structuring: &detect.Structuring{Loads: loads, Policies: policies, Alerts: alerts},
+		rates:       rates,
+		timestamps:  timestamps,
+		adminToken:  os.Getenv("ADMIN_TOKEN"),
 	}
 
-	_, err = a.loads.GetByTransactionId(load.CustomerId, load.TransactionId)
-	//Ignoring a second load with the same id on a customer
-	if err == nil {
-		http.Error(w, "Record already exists", 400)
-		return
-	} else if !errors.Is(err, models.ErrNoRecord) {
-		log.Printf("Error checking for duplicate record. %s", err)
-		http.Error(w, fmt.Sprintf("Error checking for duplicate record. %s", err), 500)
-		return
+	if len(app.adminToken) < 1 {
+		log.Println("ADMIN_TOKEN is not set, the admin endpoints will refuse every request")
 	}
 
-	startDate := time.Date(load.Time.Year(), load.Time.Month(), load.Time.Day(), 0, 0, 0, 0, time.UTC)
-	endDate := time.Date(load.Time.Year(), load.Time.Month(), load.Time.Day(), 23, 59, 59, 999, time.UTC)
-	loadModels, err := a.loads.GetByCustomerTransactionsByDateRange(load.CustomerId, startDate, endDate)
-	if err != nil {
-		if errors.Is(err, models.ErrNoRecord) {
-			load.Accepted = true
-		} else {
-			http.Error(w, fmt.Sprintf("Error retrieving data. %s", err), 500)
-			return
-		}
+	if *flagRecheckLate || os.Getenv("RECHECK_LATE") == "true" {
+		app.recheck = &report.Recheck{Loads: loads, Policies: policies, Links: links, Corrections: corrections}
 	}
-	load.Accepted = a.loadValidator.LessThanThreeLoadsDaily(loadModels) &&
-		a.loadValidator.LessThanFiveThousandLoadedDaily(loadModels, &load) &&
-		a.loadValidator.LessThanTwentyThousandLoadedWeekly(loadModels, &load)
 
-	_, err = a.loads.Insert(&load)
-	if err != nil {
-		log.Printf("Unable to insert into loads table. %s", err)
-		http.Error(w, fmt.Sprintf("Unable to insert into loads table. %s", err), 500)
-		return
-	}
-	outJson, err := json.Marshal(jsonOutput{
-		Id:         load.TransactionId,
-		CustomerId: load.CustomerId,
-		Accepted:   load.Accepted,
-	})
-	if err != nil {
-		log.Printf("Unable to marshall output json. %s", err)
-		http.Error(w, fmt.Sprintf("Unable to marshall output json. %s", err), 500)
-		return
+	if len(subscriptions) > 0 {
+		app.webhooks = &webhook.Dispatcher{Subscriptions: subscriptions, Deliveries: &postgres.DeliveryModel{DB: dbPool}}
+		app.reviews.Webhooks = app.webhooks
+		go app.deliverWebhooks(10 * time.Second)
 	}
 
-	w.Header().Set("Content-Type", "application/json")
-	_, _ = w.Write(outJson)
-}
+	if len(sinks) > 0 {
+		app.outbox = &postgres.OutboxModel{DB: dbPool}
+		app.relay = &outbox.Relay{Outbox: app.outbox, Sinks: sinks}
+		app.reviews.Outbox = true
+		go app.relayOutbox(time.Second)
+	}
+
+	go app.expireReviews(time.Minute)
+	go app.serveGrpc(grpcPort)
 
-type jsonOutput struct {
-	Id         int64 `json:"id"`
-	CustomerId int64 `json:"customer_id"`
-	Accepted   bool  `json:"accepted"`
+	err = http.ListenAndServe(":"+port, app.routes())
+	log.Fatal(err)
 }
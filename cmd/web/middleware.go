@@ -0,0 +1,24 @@
+package main
+
+import (
+	"crypto/subtle"
+	"net/http"
+)
+
+//requireAdmin guards the admin endpoints with the ADMIN_TOKEN bearer token. With no token configured the endpoints are
+//refused outright rather than left open.
+func (a *application) requireAdmin(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if len(a.adminToken) < 1 {
+			http.Error(w, "Admin endpoints are disabled until ADMIN_TOKEN is set", 403)
+			return
+		}
+		want := []byte("Bearer " + a.adminToken)
+		got := []byte(r.Header.Get("Authorization"))
+		if subtle.ConstantTimeCompare(want, got) != 1 {
+			http.Error(w, "Unauthorized", 401)
+			return
+		}
+		next(w, r)
+	}
+}
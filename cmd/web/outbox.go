@@ -0,0 +1,20 @@
+package main
+
+import (
+	"log"
+	"time"
+)
+
+//relayOutbox sends the outbox's unrelayed events to its sinks every interval. It never returns so should be run in its
+//own goroutine.
+func (a *application) relayOutbox(interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for range ticker.C {
+		_, err := a.relay.RelayPending()
+		if err != nil {
+			log.Printf("Unable to relay the outbox. %s", err)
+		}
+	}
+}
@@ -0,0 +1,133 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fireynis/velocity_checker/pkg/helpers"
+	"fireynis/velocity_checker/pkg/models"
+	"fireynis/velocity_checker/pkg/review"
+	"fmt"
+	"log"
+	"net/http"
+	"time"
+)
+
+//listReviews returns the loads waiting for review, oldest first
+func (a *application) listReviews(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		w.Header().Set("Allow", http.MethodGet)
+		http.Error(w, "Method not allowed", 405)
+		return
+	}
+
+	pending, err := a.reviews.Pending()
+	if err != nil {
+		http.Error(w, fmt.Sprintf("Error retrieving data. %s", err), 500)
+		return
+	}
+
+	output := make([]reviewOutput, 0, len(pending))
+	for _, load := range pending {
+		output = append(output, reviewOutput{
+			Id:         load.TransactionId,
+			CustomerId: load.CustomerId,
+			Amount:     helpers.FormatAmount(load.Amount),
+			Time:       load.Time,
+			RiskScore:  load.RiskScore,
+			Analyst:    load.Analyst,
+		})
+	}
+	a.writeJson(w, output)
+}
+
+func (a *application) claimReview(w http.ResponseWriter, r *http.Request) {
+	a.actOnReview(w, r, false, func(in reviewInput) (*models.Load, error) {
+		return a.reviews.Claim(in.CustomerId, in.Id, in.Analyst)
+	})
+}
+
+func (a *application) approveReview(w http.ResponseWriter, r *http.Request) {
+	a.actOnReview(w, r, true, func(in reviewInput) (*models.Load, error) {
+		return a.reviews.Approve(in.CustomerId, in.Id, in.Analyst, in.Note)
+	})
+}
+
+func (a *application) declineReview(w http.ResponseWriter, r *http.Request) {
+	a.actOnReview(w, r, true, func(in reviewInput) (*models.Load, error) {
+		return a.reviews.Decline(in.CustomerId, in.Id, in.Analyst, in.Note)
+	})
+}
+
+//actOnReview decodes and checks the request shared by the review actions, runs the action and writes the load
+func (a *application) actOnReview(w http.ResponseWriter, r *http.Request, needsNote bool, act func(in reviewInput) (*models.Load, error)) {
+	if r.Method != http.MethodPost {
+		w.Header().Set("Allow", http.MethodPost)
+		http.Error(w, "Method not allowed", 405)
+		return
+	}
+
+	var inData reviewInput
+	err := json.NewDecoder(r.Body).Decode(&inData)
+	if err != nil {
+		http.Error(w, "Unable to parse json", 400)
+		return
+	}
+	if len(inData.Analyst) < 1 || (needsNote && len(inData.Note) < 1) {
+		http.Error(w, "An analyst and note are required", 400)
+		return
+	}
+
+	load, err := act(inData)
+	if err != nil {
+		switch {
+		case errors.Is(err, models.ErrNoRecord):
+			http.NotFound(w, r)
+		case errors.Is(err, review.ErrNotPending), errors.Is(err, review.ErrClaimed):
+			http.Error(w, err.Error(), 409)
+		default:
+			log.Printf("Unable to update review. %s", err)
+			http.Error(w, fmt.Sprintf("Unable to update review. %s", err), 500)
+		}
+		return
+	}
+
+	a.writeJson(w, jsonOutput{
+		Id:         load.TransactionId,
+		CustomerId: load.CustomerId,
+		Accepted:   load.Accepted,
+		RiskScore:  load.RiskScore,
+		Outcome:    load.Outcome,
+	})
+}
+
+//expireReviews declines overdue reviews every interval. It never returns so should be run in its own goroutine.
+func (a *application) expireReviews(interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for range ticker.C {
+		expired, err := a.reviews.ExpireOverdue(time.Now())
+		if err != nil {
+			log.Printf("Unable to expire overdue reviews. %s", err)
+		}
+		if expired > 0 {
+			log.Printf("Declined %d reviews that passed the %s SLA", expired, a.reviews.Sla)
+		}
+	}
+}
+
+type reviewInput struct {
+	Id         int64  `json:"id"`
+	CustomerId int64  `json:"customer_id"`
+	Analyst    string `json:"analyst"`
+	Note       string `json:"note"`
+}
+
+type reviewOutput struct {
+	Id         int64     `json:"id"`
+	CustomerId int64     `json:"customer_id"`
+	Amount     string    `json:"load_amount"`
+	Time       time.Time `json:"time"`
+	RiskScore  int64     `json:"risk_score"`
+	Analyst    string    `json:"analyst,omitempty"`
+}
@@ -1,10 +1,26 @@
 package main
 
-import "net/http"
+import (
+	"expvar"
+	"net/http"
+)
 
 func (a *application) routes() http.Handler {
 	router := http.NewServeMux()
 
 	router.HandleFunc("/", a.parseLoad)
+	router.HandleFunc("/loads/batch", a.batchLoads)
+	router.HandleFunc("/trace", a.getTrace)
+	router.HandleFunc("/headroom", a.getHeadroom)
+	router.HandleFunc("/override", a.requireAdmin(a.overrideLoad))
+	router.HandleFunc("/admin/reviews", a.requireAdmin(a.listReviews))
+	router.HandleFunc("/admin/reviews/claim", a.requireAdmin(a.claimReview))
+	router.HandleFunc("/admin/reviews/approve", a.requireAdmin(a.approveReview))
+	router.HandleFunc("/admin/reviews/decline", a.requireAdmin(a.declineReview))
+	router.HandleFunc("/admin/alerts", a.requireAdmin(a.listAlerts))
+	router.HandleFunc("/admin/corrections", a.requireAdmin(a.listCorrections))
+	router.HandleFunc("/admin/deliveries", a.requireAdmin(a.listDeliveries))
+	router.HandleFunc("/admin/deliveries/redeliver", a.requireAdmin(a.redeliverWebhook))
+	router.HandleFunc("/debug/vars", a.requireAdmin(expvar.Handler().ServeHTTP))
 	return router
 }
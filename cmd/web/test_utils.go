@@ -1,14 +1,81 @@
 package main
 
 import (
+	"fireynis/velocity_checker/pkg/currency"
+	"fireynis/velocity_checker/pkg/detect"
+	"fireynis/velocity_checker/pkg/engine"
+	"fireynis/velocity_checker/pkg/helpers"
+	"fireynis/velocity_checker/pkg/models/memory"
 	"fireynis/velocity_checker/pkg/models/mock"
+	"fireynis/velocity_checker/pkg/review"
 	"fireynis/velocity_checker/pkg/validators"
+	"net/http"
+	"net/http/httptest"
 	"testing"
+	"time"
 )
 
 func newTestApplication(t *testing.T) *application {
+	loads := &mock.Load{}
+	auditLog := &mock.Audit{}
+	policies := &mock.Policy{}
+	alerts := &mock.Alert{}
+	links := &mock.Link{}
+	balances := &mock.Balance{}
+	corrections := &mock.Correction{}
+	decisions := &mock.Decision{Loads: loads, AuditLog: auditLog, Balances: balances}
+	rates, _ := currency.NewRates("USD")
+	_ = rates.Add("EUR", "2000-01-01T00:00:00Z", "1.5")
 	return &application{
-		loads:         &mock.Load{},
-		loadValidator: &validators.LoadValidator{},
+		loads:       loads,
+		traces:      &mock.Trace{},
+		auditLog:    auditLog,
+		alerts:      alerts,
+		links:       links,
+		balances:    balances,
+		corrections: corrections,
+		decisions:   decisions,
+		rates:       rates,
+		timestamps:  &helpers.Timestamps{EvaluateOn: helpers.EvaluateOnClient},
+		reviews:     &review.Queue{Loads: loads, Decisions: decisions, Sla: 24 * time.Hour},
+		engine:      &engine.Engine{Loads: loads, Policies: policies, Validator: &validators.LoadValidator{}, Links: links, Balances: balances},
+		structuring: &detect.Structuring{Loads: loads, Policies: policies, Alerts: alerts},
+		adminToken:  testAdminToken,
 	}
 }
+
+//testAdminToken is the admin token of the test application
+const testAdminToken = "secret"
+
+//adminTransport sends the test application's admin token with every request that doesn't set its own
+type adminTransport struct {
+	next http.RoundTripper
+}
+
+func (t *adminTransport) RoundTrip(r *http.Request) (*http.Response, error) {
+	if len(r.Header.Get("Authorization")) > 0 {
+		return t.next.RoundTrip(r)
+	}
+	r = r.Clone(r.Context())
+	r.Header.Set("Authorization", "Bearer "+testAdminToken)
+	return t.next.RoundTrip(r)
+}
+
+//newAdminServer starts a test server for the application whose client is signed in as an admin
+func newAdminServer(app *application) *httptest.Server {
+	ts := httptest.NewTLSServer(app.routes())
+	ts.Client().Transport = &adminTransport{next: ts.Client().Transport}
+	return ts
+}
+
+//useMemoryLoads swaps the application's mock loads for an empty in-memory store, so loads decided by a test are seen by
+//the ones after them
+func useMemoryLoads(app *application) {
+	loads := memory.NewLoad(nil)
+	app.loads = loads
+	app.decisions = &mock.Decision{Loads: loads, AuditLog: app.auditLog, Balances: app.balances}
+	app.engine.Loads = loads
+	app.structuring.Loads = loads
+	app.reviews.Loads = loads
+	app.reviews.Decisions = app.decisions
+}
@@ -0,0 +1,93 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fireynis/velocity_checker/pkg/models"
+	"fireynis/velocity_checker/pkg/webhook"
+	"fmt"
+	"log"
+	"net/http"
+	"time"
+)
+
+//listDeliveries returns the webhook deliveries in the status query parameter, failed when it isn't given
+func (a *application) listDeliveries(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		w.Header().Set("Allow", http.MethodGet)
+		http.Error(w, "Method not allowed", 405)
+		return
+	}
+	if a.webhooks == nil {
+		http.Error(w, "No webhook subscriptions are configured", 404)
+		return
+	}
+
+	status := r.URL.Query().Get("status")
+	if len(status) < 1 {
+		status = models.DeliveryFailed
+	}
+	if status != models.DeliveryPending && status != models.DeliverySending && status != models.DeliveryDelivered && status != models.DeliveryFailed {
+		http.Error(w, "status must be pending, sending, delivered or failed", 400)
+		return
+	}
+
+	deliveries, err := a.webhooks.Deliveries.GetByStatus(status)
+	if err != nil {
+		http.Error(w, fmt.Sprintf("Error retrieving data. %s", err), 500)
+		return
+	}
+	a.writeJson(w, deliveries)
+}
+
+//redeliverWebhook sends the delivery with the id in the body again and returns how it went
+func (a *application) redeliverWebhook(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		w.Header().Set("Allow", http.MethodPost)
+		http.Error(w, "Method not allowed", 405)
+		return
+	}
+	if a.webhooks == nil {
+		http.Error(w, "No webhook subscriptions are configured", 404)
+		return
+	}
+
+	var inData redeliverInput
+	err := json.NewDecoder(r.Body).Decode(&inData)
+	if err != nil {
+		http.Error(w, "Unable to parse json", 400)
+		return
+	}
+
+	delivery, err := a.webhooks.Redeliver(inData.Id)
+	if err != nil {
+		if errors.Is(err, models.ErrNoRecord) {
+			http.NotFound(w, r)
+		} else if errors.Is(err, webhook.ErrSending) {
+			http.Error(w, err.Error(), 409)
+		} else {
+			log.Printf("Unable to redeliver webhook. %s", err)
+			http.Error(w, fmt.Sprintf("Unable to redeliver webhook. %s", err), 500)
+		}
+		return
+	}
+	a.writeJson(w, delivery)
+}
+
+//deliverWebhooks sends the webhook deliveries that are due every interval. It never returns so should be run in its
+//own goroutine.
+func (a *application) deliverWebhooks(interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for range ticker.C {
+		_, err := a.webhooks.DeliverDue()
+		if err != nil {
+			log.Printf("Unable to deliver webhooks. %s", err)
+		}
+	}
+}
+
+type redeliverInput struct {
+	Id int64 `json:"id"`
+}
@@ -3,6 +3,9 @@ module fireynis/velocity_checker
 go 1.15
 
 require (
+	github.com/jackc/pgconn v1.6.4
 	github.com/jackc/pgx/v4 v4.8.1
 	github.com/joho/godotenv v1.3.0
+	google.golang.org/grpc v1.40.0
+	google.golang.org/protobuf v1.27.1
 )
@@ -0,0 +1,89 @@
+package audit
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fireynis/velocity_checker/pkg/models"
+	"fmt"
+	"strconv"
+	"time"
+)
+
+const (
+	EventDecision     = "decision"
+	EventOverride     = "override"
+	EventAdjudication = "adjudication"
+
+	//ActorEngine is recorded against decisions made automatically rather than by a person
+	ActorEngine = "engine"
+)
+
+//Break describes an entry where the chain no longer holds
+type Break struct {
+	EntryId int64
+	Reason  string
+}
+
+//NewEntry snapshots the load as it stands for the given event. The created time is truncated to microseconds as that
+//is all postgres keeps, and the hash has to be reproducible from the stored row.
+func NewEntry(event string, load *models.Load, actor string, note string) *models.AuditEntry {
+	return &models.AuditEntry{
+		Event:         event,
+		LoadId:        load.Id,
+		CustomerId:    load.CustomerId,
+		TransactionId: load.TransactionId,
+		Amount:        load.Amount,
+		Accepted:      load.Accepted,
+		Actor:         actor,
+		Note:          note,
+		CreatedAt:     time.Now().UTC().Truncate(time.Microsecond),
+	}
+}
+
+//Hash computes the hash of an entry chained to the previous entry's hash. The id is deliberately left out since it is
+//assigned by the store after the hash is computed.
+func Hash(entry *models.AuditEntry) string {
+	h := sha256.New()
+	for _, field := range []string{
+		entry.PrevHash,
+		entry.Event,
+		strconv.FormatInt(entry.LoadId, 10),
+		strconv.FormatInt(entry.CustomerId, 10),
+		strconv.FormatInt(entry.TransactionId, 10),
+		strconv.FormatInt(entry.Amount, 10),
+		strconv.FormatBool(entry.Accepted),
+		entry.Actor,
+		entry.Note,
+		entry.CreatedAt.UTC().Format(time.RFC3339Nano),
+	} {
+		//Length prefixing stops two different sets of fields from joining into the same bytes
+		_, _ = fmt.Fprintf(h, "%d:%s|", len(field), field)
+	}
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+//Verify walks the whole chain in order and reports every entry whose hash does not match its contents or whose
+//previous hash does not match the entry before it. It returns the number of entries checked.
+func Verify(store models.IAudit) (int, []Break, error) {
+	var breaks []Break
+	count := 0
+	prevHash := ""
+	err := store.Walk(func(entry *models.AuditEntry) error {
+		count++
+		if entry.PrevHash != prevHash {
+			breaks = append(breaks, Break{
+				EntryId: entry.Id,
+				Reason:  fmt.Sprintf("previous hash %s does not match the preceding entry %s", entry.PrevHash, prevHash),
+			})
+		}
+		if Hash(entry) != entry.Hash {
+			breaks = append(breaks, Break{EntryId: entry.Id, Reason: "entry contents do not match its hash"})
+		}
+		prevHash = entry.Hash
+		return nil
+	})
+	if err != nil {
+		return count, nil, err
+	}
+	return count, breaks, nil
+}
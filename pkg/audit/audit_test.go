@@ -0,0 +1,64 @@
+package audit_test
+
+import (
+	"fireynis/velocity_checker/pkg/audit"
+	"fireynis/velocity_checker/pkg/models"
+	"fireynis/velocity_checker/pkg/models/mock"
+	"testing"
+	"time"
+)
+
+func newChain(t *testing.T) *mock.Audit {
+	store := &mock.Audit{}
+	for i := int64(1); i <= 3; i++ {
+		load := &models.Load{
+			Id:            i,
+			TransactionId: i,
+			CustomerId:    1,
+			Amount:        10000 * i,
+			Time:          time.Date(2000, 1, 1, 0, 0, 0, 0, time.UTC),
+			Accepted:      true,
+		}
+		err := store.Append(audit.NewEntry(audit.EventDecision, load, audit.ActorEngine, ""))
+		if err != nil {
+			t.Fatalf("Unexpected error %v", err)
+		}
+	}
+	return store
+}
+
+func TestVerify(t *testing.T) {
+	tests := []struct {
+		name       string
+		tamper     func(store *mock.Audit)
+		wantBreaks []int64
+	}{
+		{"Intact chain", func(store *mock.Audit) {}, nil},
+		{"Edited entry", func(store *mock.Audit) { store.Entries[1].Accepted = false }, []int64{2}},
+		{"Deleted entry", func(store *mock.Audit) { store.Entries = append(store.Entries[:1], store.Entries[2:]...) }, []int64{3}},
+		{"Rehashed edit", func(store *mock.Audit) {
+			store.Entries[0].Amount = 1
+			store.Entries[0].Hash = audit.Hash(store.Entries[0])
+		}, []int64{2}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			store := newChain(t)
+			tt.tamper(store)
+
+			_, breaks, err := audit.Verify(store)
+			if err != nil {
+				t.Fatalf("Unexpected error %v", err)
+			}
+			if len(breaks) != len(tt.wantBreaks) {
+				t.Fatalf("want %d breaks; got %+v", len(tt.wantBreaks), breaks)
+			}
+			for i, brk := range breaks {
+				if brk.EntryId != tt.wantBreaks[i] {
+					t.Errorf("want break at entry %d; got %d", tt.wantBreaks[i], brk.EntryId)
+				}
+			}
+		})
+	}
+}
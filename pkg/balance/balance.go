@@ -0,0 +1,26 @@
+package balance
+
+import "fireynis/velocity_checker/pkg/models"
+
+//Delta is how much the load moves the customer's balance once accepted. Loads add their amount and debits take it away.
+func Delta(load *models.Load) int64 {
+	if load.Type == models.TypeDebit {
+		return -load.Amount
+	}
+	return load.Amount
+}
+
+//Settle moves the customer's balance when the load's acceptance changes from wasAccepted to load.Accepted, backing the
+//load out of the balance when an accepted load is declined. A load being decided for the first time was not accepted.
+func Settle(store models.IBalances, load *models.Load, wasAccepted bool) error {
+	if load.Accepted == wasAccepted {
+		return nil
+	}
+
+	delta := Delta(load)
+	if !load.Accepted {
+		delta = -delta
+	}
+	_, err := store.Adjust(load.CustomerId, delta)
+	return err
+}
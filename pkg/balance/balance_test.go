@@ -0,0 +1,37 @@
+package balance
+
+import (
+	"fireynis/velocity_checker/pkg/models"
+	"fireynis/velocity_checker/pkg/models/mock"
+	"testing"
+)
+
+func TestSettle(t *testing.T) {
+	tests := []struct {
+		name        string
+		load        *models.Load
+		wasAccepted bool
+		want        int64
+	}{
+		{"Accepted load", &models.Load{CustomerId: 1, Type: models.TypeLoad, Amount: 500, Accepted: true}, false, 1500},
+		{"Accepted debit", &models.Load{CustomerId: 1, Type: models.TypeDebit, Amount: 500, Accepted: true}, false, 500},
+		{"Declined load", &models.Load{CustomerId: 1, Type: models.TypeLoad, Amount: 500}, false, 1000},
+		{"Load overridden to declined", &models.Load{CustomerId: 1, Type: models.TypeLoad, Amount: 500}, true, 500},
+		{"Debit overridden to declined", &models.Load{CustomerId: 1, Type: models.TypeDebit, Amount: 500}, true, 1500},
+		{"Load stored before debits existed", &models.Load{CustomerId: 1, Amount: 500, Accepted: true}, false, 1500},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			store := &mock.Balance{Balances: map[int64]int64{1: 1000}}
+			err := Settle(store, tt.load, tt.wasAccepted)
+			if err != nil {
+				t.Fatalf("Settle() unexpected error %v", err)
+			}
+			got, _ := store.Get(1)
+			if got != tt.want {
+				t.Errorf("Settle() left the balance at %d, want %d", got, tt.want)
+			}
+		})
+	}
+}
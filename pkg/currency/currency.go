@@ -0,0 +1,74 @@
+package currency
+
+import (
+	"errors"
+	"fmt"
+	"math/big"
+	"strings"
+)
+
+//Default is the currency of a load that doesn't give one. Loads used to be dollars only.
+const Default = "USD"
+
+var (
+	ErrUnknownCurrency = errors.New("unknown currency")
+	//ErrInvalidAmount covers anything that isn't a plain decimal number, including NaN and Inf
+	ErrInvalidAmount   = errors.New("invalid amount")
+	ErrNegativeAmount  = errors.New("negative amount")
+	ErrTooManyDecimals = errors.New("too many decimals")
+	//ErrAmountTooLarge is an amount whose minor units don't fit in an int64
+	ErrAmountTooLarge = errors.New("amount too large")
+)
+
+//minorUnits holds the number of decimals each ISO 4217 currency is quoted with. It covers the currencies we expect to
+//see rather than the whole standard.
+var minorUnits = map[string]int{
+	"AUD": 2, "BRL": 2, "CAD": 2, "CHF": 2, "CNY": 2, "DKK": 2, "EUR": 2, "GBP": 2, "HKD": 2, "INR": 2, "MXN": 2,
+	"NOK": 2, "NZD": 2, "PLN": 2, "SEK": 2, "SGD": 2, "USD": 2, "ZAR": 2,
+	"CLP": 0, "ISK": 0, "JPY": 0, "KRW": 0, "VND": 0,
+	"BHD": 3, "JOD": 3, "KWD": 3, "OMR": 3, "TND": 3,
+}
+
+//MinorUnits returns how many decimals the currency has
+func MinorUnits(code string) (int, error) {
+	units, ok := minorUnits[code]
+	if !ok {
+		return 0, fmt.Errorf("%w %q", ErrUnknownCurrency, code)
+	}
+	return units, nil
+}
+
+//ParseAmount turns a decimal amount in the currency into its minor units. It is parsed exactly rather than through a
+//float, and an amount with more decimals than the currency has is an error rather than being rounded. Errors wrap one
+//of the Err values above.
+func ParseAmount(raw string, code string) (int64, error) {
+	units, err := MinorUnits(code)
+	if err != nil {
+		return 0, err
+	}
+
+	negative := strings.HasPrefix(raw, "-")
+	if negative {
+		raw = raw[1:]
+	}
+
+	whole, fraction := raw, ""
+	if point := strings.IndexByte(raw, '.'); point >= 0 {
+		whole, fraction = raw[:point], raw[point+1:]
+	}
+	if len(fraction) > units {
+		return 0, fmt.Errorf("%w, %s has at most %d but got %q", ErrTooManyDecimals, code, units, raw)
+	}
+
+	amount, ok := new(big.Int).SetString(whole+fraction+strings.Repeat("0", units-len(fraction)), 10)
+	if !ok || len(whole) < 1 || strings.ContainsAny(whole+fraction, "+-") {
+		return 0, fmt.Errorf("%w %q", ErrInvalidAmount, raw)
+	}
+	if negative {
+		return 0, fmt.Errorf("%w -%s", ErrNegativeAmount, raw)
+	}
+	if !amount.IsInt64() {
+		return 0, fmt.Errorf("%w, %q doesn't fit in %s minor units", ErrAmountTooLarge, raw, code)
+	}
+	return amount.Int64(), nil
+}
@@ -0,0 +1,93 @@
+package currency
+
+import (
+	"errors"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestParseAmount(t *testing.T) {
+	tests := []struct {
+		name    string
+		raw     string
+		code    string
+		want    int64
+		wantErr error
+	}{
+		{"Dollars", "250.29", "USD", 25029, nil},
+		{"Whole dollars", "250", "USD", 25000, nil},
+		{"One decimal", "0.5", "USD", 50, nil},
+		{"Yen", "1500", "JPY", 1500, nil},
+		{"Yen with decimals", "1500.5", "JPY", 0, ErrTooManyDecimals},
+		{"Dinar", "1.234", "BHD", 1234, nil},
+		{"Too many decimals", "1.005", "USD", 0, ErrTooManyDecimals},
+		{"Negative", "-5.00", "USD", 0, ErrNegativeAmount},
+		{"Double negative", "--5.00", "USD", 0, ErrInvalidAmount},
+		{"Not a number", "five", "USD", 0, ErrInvalidAmount},
+		{"NaN", "NaN", "USD", 0, ErrInvalidAmount},
+		{"Inf", "+Inf", "USD", 0, ErrInvalidAmount},
+		{"Exponent", "1e3", "USD", 0, ErrInvalidAmount},
+		{"Unknown currency", "5.00", "XYZ", 0, ErrUnknownCurrency},
+		{"Too large", "99999999999999999999", "USD", 0, ErrAmountTooLarge},
+		{"Just fits", "92233720368547758.07", "USD", 9223372036854775807, nil},
+		{"Just too large", "92233720368547758.08", "USD", 0, ErrAmountTooLarge},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := ParseAmount(tt.raw, tt.code)
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("ParseAmount() error = %v, want %v", err, tt.wantErr)
+			}
+			if got != tt.want {
+				t.Errorf("ParseAmount() = %d, want %d", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestRates_Convert(t *testing.T) {
+	rates, err := NewRates("USD")
+	if err != nil {
+		t.Fatalf("NewRates() unexpected error %v", err)
+	}
+	err = rates.Read(strings.NewReader("currency,effective_from,rate\n" +
+		"EUR,2021-01-01T00:00:00Z,1.2\n" +
+		"eur,2021-02-01T00:00:00Z,1.25\n" +
+		"JPY,2021-01-01T00:00:00Z,0.0095\n" +
+		"BHD,2021-01-01T00:00:00Z,2.65\n"))
+	if err != nil {
+		t.Fatalf("Read() unexpected error %v", err)
+	}
+
+	january := time.Date(2021, 1, 15, 0, 0, 0, 0, time.UTC)
+	tests := []struct {
+		name    string
+		amount  int64
+		code    string
+		at      time.Time
+		want    int64
+		wantErr bool
+	}{
+		{"Base currency", 10000, "USD", january, 10000, false},
+		{"Euros", 10000, "EUR", january, 12000, false},
+		{"Later rate", 10000, "EUR", january.AddDate(0, 1, 0), 12500, false},
+		{"Before any rate", 10000, "EUR", january.AddDate(-1, 0, 0), 0, true},
+		{"Yen to cents rounds", 1001, "JPY", january, 951, false},
+		{"Dinar", 1000, "BHD", january, 265, false},
+		{"No rate", 10000, "GBP", january, 0, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := rates.Convert(tt.amount, tt.code, tt.at)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("Convert() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if got != tt.want {
+				t.Errorf("Convert() = %d, want %d", got, tt.want)
+			}
+		})
+	}
+}
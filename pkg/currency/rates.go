@@ -0,0 +1,167 @@
+package currency
+
+import (
+	"encoding/csv"
+	"errors"
+	"fireynis/velocity_checker/pkg/models"
+	"fmt"
+	"io"
+	"math/big"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+//Rates converts amounts into the base currency limits are evaluated in
+type Rates struct {
+	Base  string
+	rates map[string][]rate
+}
+
+//rate is how many units of the base currency one unit of a currency buys from effectiveFrom on
+type rate struct {
+	effectiveFrom time.Time
+	value         *big.Rat
+}
+
+//NewRates creates an empty table. Only loads already in the base currency can be converted until rates are added.
+func NewRates(base string) (*Rates, error) {
+	_, err := MinorUnits(base)
+	if err != nil {
+		return nil, err
+	}
+	return &Rates{Base: base, rates: make(map[string][]rate)}, nil
+}
+
+//LoadRates reads a rates file into a table for the base currency. The file is csv with a header and a row per
+//currency and effective time, e.g.
+//
+//	currency,effective_from,rate
+//	EUR,2021-01-01T00:00:00Z,1.2134
+//
+//where rate is how many units of the base currency one unit of the currency buys.
+func LoadRates(filePath string, base string) (*Rates, error) {
+	rates, err := NewRates(base)
+	if err != nil {
+		return nil, err
+	}
+
+	cleanPath, err := filepath.Abs(filePath)
+	if err != nil {
+		return nil, errors.New(fmt.Sprintf("unable to clean file path. %s", err))
+	}
+	file, err := os.Open(cleanPath)
+	if err != nil {
+		return nil, errors.New(fmt.Sprintf("unable to open rates file. %s", err))
+	}
+	defer file.Close()
+
+	err = rates.Read(file)
+	if err != nil {
+		return nil, err
+	}
+	return rates, nil
+}
+
+//Read adds the rates in csv form to the table
+func (r *Rates) Read(in io.Reader) error {
+	records, err := csv.NewReader(in).ReadAll()
+	if err != nil {
+		return errors.New(fmt.Sprintf("unable to parse rates. %s", err))
+	}
+
+	for i, record := range records {
+		if i == 0 {
+			continue
+		}
+		if len(record) != 3 {
+			return errors.New(fmt.Sprintf("rates line %d: want currency, effective_from and rate", i+1))
+		}
+		err = r.Add(strings.ToUpper(strings.TrimSpace(record[0])), strings.TrimSpace(record[1]), strings.TrimSpace(record[2]))
+		if err != nil {
+			return errors.New(fmt.Sprintf("rates line %d: %s", i+1, err))
+		}
+	}
+	return nil
+}
+
+//Add sets the rate for a currency from the RFC 3339 effective time on
+func (r *Rates) Add(code string, effectiveFrom string, value string) error {
+	_, err := MinorUnits(code)
+	if err != nil {
+		return err
+	}
+	from, err := time.Parse(time.RFC3339, effectiveFrom)
+	if err != nil {
+		return errors.New(fmt.Sprintf("invalid effective_from. %s", err))
+	}
+	parsed, ok := new(big.Rat).SetString(value)
+	if !ok || parsed.Sign() <= 0 {
+		return errors.New(fmt.Sprintf("invalid rate %q", value))
+	}
+
+	r.rates[code] = append(r.rates[code], rate{effectiveFrom: from, value: parsed})
+	sort.SliceStable(r.rates[code], func(i, j int) bool {
+		return r.rates[code][i].effectiveFrom.Before(r.rates[code][j].effectiveFrom)
+	})
+	return nil
+}
+
+//Convert turns an amount in the currency's minor units into the base currency's minor units at the rate effective at
+//the given time, rounding half away from zero
+func (r *Rates) Convert(amount int64, code string, at time.Time) (int64, error) {
+	if code == r.Base {
+		return amount, nil
+	}
+
+	var effective *big.Rat
+	for _, candidate := range r.rates[code] {
+		if candidate.effectiveFrom.After(at) {
+			break
+		}
+		effective = candidate.value
+	}
+	if effective == nil {
+		return 0, errors.New(fmt.Sprintf("no %s rate effective at %s", code, at.UTC().Format(time.RFC3339)))
+	}
+
+	fromUnits, err := MinorUnits(code)
+	if err != nil {
+		return 0, err
+	}
+	baseUnits, _ := MinorUnits(r.Base)
+
+	converted := new(big.Rat).SetInt64(amount)
+	converted.Mul(converted, effective)
+	converted.Mul(converted, new(big.Rat).SetFrac(pow10(baseUnits), pow10(fromUnits)))
+
+	//Round half away from zero by adding a half before truncating the quotient
+	num, denom := converted.Num(), converted.Denom()
+	doubled := new(big.Int).Mul(num, big.NewInt(2))
+	if doubled.Sign() >= 0 {
+		doubled.Add(doubled, denom)
+	} else {
+		doubled.Sub(doubled, denom)
+	}
+	rounded := new(big.Int).Quo(doubled, new(big.Int).Mul(denom, big.NewInt(2)))
+	if !rounded.IsInt64() {
+		return 0, errors.New("converted amount is too large")
+	}
+	return rounded.Int64(), nil
+}
+
+//Apply converts the load's original amount into the base currency, setting Amount to the converted value
+func (r *Rates) Apply(load *models.Load) error {
+	converted, err := r.Convert(load.OriginalAmount, load.Currency, load.Time)
+	if err != nil {
+		return err
+	}
+	load.Amount = converted
+	return nil
+}
+
+func pow10(n int) *big.Int {
+	return new(big.Int).Exp(big.NewInt(10), big.NewInt(int64(n)), nil)
+}
@@ -0,0 +1,145 @@
+package detect
+
+import (
+	"fireynis/velocity_checker/pkg/models"
+	"math"
+	"sort"
+)
+
+const (
+	//minutesPerDay is the circumference of the time of day circle
+	minutesPerDay = 24 * 60
+	//madScale makes the median absolute deviation comparable to a standard deviation
+	madScale = 0.6745
+	//minAmountSpread and minMinuteSpread floor the median absolute deviation so a customer who always loads the same
+	//amount at the same time isn't flagged for a few cents or minutes
+	minAmountSpread = 0.05
+	minMinuteSpread = 30
+)
+
+//AnomalyResult is how a load compares to the customer's history. The scores are modified z-scores, the distance from
+//the median in median absolute deviations.
+type AnomalyResult struct {
+	Tier          string
+	History       int
+	AmountScore   float64
+	HourScore     float64
+	AmountOutlier bool
+	HourOutlier   bool
+}
+
+//Outlier reports whether either the amount or the time of day is an outlier
+func (r AnomalyResult) Outlier() bool {
+	return r.AmountOutlier || r.HourOutlier
+}
+
+//Severity is the larger of the scores as a fraction of the tier's threshold, so 1 is just at the threshold
+func (r AnomalyResult) Severity(tier models.AnomalyTier) float64 {
+	severity := float64(0)
+	if tier.AmountScore > 0 {
+		severity = math.Max(severity, r.AmountScore/tier.AmountScore)
+	}
+	if tier.HourScore > 0 {
+		severity = math.Max(severity, r.HourScore/tier.HourScore)
+	}
+	return severity
+}
+
+//Anomaly compares the load's amount and time of day to the customer's accepted loads in history. When the history
+//doesn't reach any tier the load is not scored.
+func Anomaly(config models.AnomalyConfig, history []*models.Load, load *models.Load) (AnomalyResult, models.AnomalyTier) {
+	var amounts, minutes []float64
+	for _, past := range history {
+		if !past.Accepted || past.TransactionId == load.TransactionId {
+			continue
+		}
+		amounts = append(amounts, float64(past.Amount))
+		minutes = append(minutes, minuteOfDay(past))
+	}
+
+	result := AnomalyResult{History: len(amounts)}
+	tier, ok := tierFor(config, len(amounts))
+	if !ok {
+		return result, tier
+	}
+	result.Tier = tier.Name
+
+	if tier.AmountScore > 0 {
+		median := medianOf(amounts)
+		deviations := make([]float64, len(amounts))
+		for i, amount := range amounts {
+			deviations[i] = math.Abs(amount - median)
+		}
+		spread := math.Max(medianOf(deviations), median*minAmountSpread)
+		if spread > 0 {
+			result.AmountScore = madScale * math.Abs(float64(load.Amount)-median) / spread
+		}
+		result.AmountOutlier = result.AmountScore > tier.AmountScore
+	}
+
+	if tier.HourScore > 0 {
+		median := circularMedian(minutes)
+		deviations := make([]float64, len(minutes))
+		for i, minute := range minutes {
+			deviations[i] = circularDistance(minute, median)
+		}
+		spread := math.Max(medianOf(deviations), minMinuteSpread)
+		result.HourScore = madScale * circularDistance(minuteOfDay(load), median) / spread
+		result.HourOutlier = result.HourScore > tier.HourScore
+	}
+	return result, tier
+}
+
+//tierFor picks the tier with the highest MinHistory the history reaches
+func tierFor(config models.AnomalyConfig, history int) (models.AnomalyTier, bool) {
+	var best models.AnomalyTier
+	found := false
+	for _, tier := range config.Tiers {
+		if history >= tier.MinHistory && (!found || tier.MinHistory > best.MinHistory) {
+			best = tier
+			found = true
+		}
+	}
+	return best, found
+}
+
+func minuteOfDay(load *models.Load) float64 {
+	t := load.Time.UTC()
+	return float64(t.Hour()*60 + t.Minute())
+}
+
+func medianOf(values []float64) float64 {
+	if len(values) == 0 {
+		return 0
+	}
+	sorted := append([]float64(nil), values...)
+	sort.Float64s(sorted)
+	middle := len(sorted) / 2
+	if len(sorted)%2 == 0 {
+		return (sorted[middle-1] + sorted[middle]) / 2
+	}
+	return sorted[middle]
+}
+
+//circularDistance is the distance between two times of day going whichever way round midnight is shorter
+func circularDistance(a float64, b float64) float64 {
+	distance := math.Abs(a - b)
+	return math.Min(distance, minutesPerDay-distance)
+}
+
+//circularMedian is the time of day closest to all the others, since 23:30 and 00:30 are an hour apart rather than 23
+func circularMedian(minutes []float64) float64 {
+	best := float64(0)
+	bestTotal := math.Inf(1)
+	for _, candidate := range minutes {
+		total := float64(0)
+		for _, minute := range minutes {
+			total += circularDistance(candidate, minute)
+		}
+		if total < bestTotal {
+			best = candidate
+			bestTotal = total
+		}
+	}
+	return best
+}
@@ -0,0 +1,76 @@
+package detect
+
+import (
+	"fireynis/velocity_checker/pkg/models"
+	"fireynis/velocity_checker/pkg/policy"
+	"testing"
+	"time"
+)
+
+//history builds a load a day for the given number of days before 2000-02-01, cycling through the amounts and times
+func history(days int, amounts []int64, times []time.Duration) []*models.Load {
+	start := time.Date(2000, 2, 1, 0, 0, 0, 0, time.UTC).AddDate(0, 0, -days)
+	var loads []*models.Load
+	for i := 0; i < days; i++ {
+		loads = append(loads, &models.Load{
+			Id:            int64(i + 1),
+			TransactionId: int64(i + 1),
+			CustomerId:    1,
+			Amount:        amounts[i%len(amounts)],
+			Time:          start.AddDate(0, 0, i).Add(times[i%len(times)]),
+			Accepted:      true,
+		})
+	}
+	return loads
+}
+
+func TestAnomaly(t *testing.T) {
+	day := time.Date(2000, 2, 1, 0, 0, 0, 0, time.UTC)
+	afternoons := history(12, []int64{18000, 20000, 22000, 19000, 21000}, []time.Duration{13 * time.Hour, 14 * time.Hour, 15 * time.Hour})
+	midnights := history(12, []int64{20000}, []time.Duration{-30 * time.Minute, 0, 30 * time.Minute})
+
+	tests := []struct {
+		name       string
+		history    []*models.Load
+		load       *models.Load
+		wantTier   string
+		wantAmount bool
+		wantHour   bool
+	}{
+		{"Usual amount and time", afternoons, &models.Load{TransactionId: 99, Amount: 21000, Time: day.Add(14 * time.Hour)}, "established", false, false},
+		{"Large amount", afternoons, &models.Load{TransactionId: 99, Amount: 100000, Time: day.Add(14 * time.Hour)}, "established", true, false},
+		{"Night time", afternoons, &models.Load{TransactionId: 99, Amount: 21000, Time: day.Add(3 * time.Hour)}, "established", false, true},
+		{"Across midnight", midnights, &models.Load{TransactionId: 99, Amount: 20000, Time: day.Add(10 * time.Minute)}, "established", false, false},
+		{"Too little history", afternoons[:5], &models.Load{TransactionId: 99, Amount: 100000, Time: day.Add(3 * time.Hour)}, "", false, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, _ := Anomaly(policy.DefaultAnomaly, tt.history, tt.load)
+			if got.Tier != tt.wantTier || got.AmountOutlier != tt.wantAmount || got.HourOutlier != tt.wantHour {
+				t.Errorf("Anomaly() got %+v; want tier %q, amount outlier %v, hour outlier %v", got, tt.wantTier, tt.wantAmount, tt.wantHour)
+			}
+		})
+	}
+}
+
+func TestAnomalyTiers(t *testing.T) {
+	config := models.AnomalyConfig{
+		Window: "2160h",
+		Tiers: []models.AnomalyTier{
+			{Name: "new", MinHistory: 3, AmountScore: 20},
+			{Name: "established", MinHistory: 10, AmountScore: 3.5},
+		},
+	}
+	load := &models.Load{TransactionId: 99, Amount: 40000, Time: time.Date(2000, 2, 1, 14, 0, 0, 0, time.UTC)}
+
+	got, _ := Anomaly(config, history(5, []int64{20000}, []time.Duration{14 * time.Hour}), load)
+	if got.Tier != "new" || got.Outlier() {
+		t.Errorf("Anomaly() with a short history got %+v; want the new tier and no outlier", got)
+	}
+
+	got, _ = Anomaly(config, history(12, []int64{20000}, []time.Duration{14 * time.Hour}), load)
+	if got.Tier != "established" || !got.AmountOutlier {
+		t.Errorf("Anomaly() with a long history got %+v; want the established tier and an amount outlier", got)
+	}
+}
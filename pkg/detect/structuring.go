@@ -0,0 +1,147 @@
+package detect
+
+import (
+	"errors"
+	"fireynis/velocity_checker/pkg/helpers"
+	"fireynis/velocity_checker/pkg/models"
+	"fireynis/velocity_checker/pkg/policy"
+	"fmt"
+	"strings"
+	"time"
+)
+
+const (
+	//PatternNearLimitLoads is a load just under the per load maximum on each of several consecutive days
+	PatternNearLimitLoads = "near_limit_loads"
+	//PatternNearLimitDailyTotals is a daily total just under the daily maximum on each of several consecutive days
+	PatternNearLimitDailyTotals = "near_limit_daily_totals"
+)
+
+//DefaultStructuring is used when the policy doesn't configure structuring detection
+var DefaultStructuring = models.StructuringConfig{NearPercent: 10, Days: 3}
+
+//Structuring looks for customers splitting a large amount into loads that each stay just under a limit. A load can't
+//be larger than the daily amount limit, so that doubles as the per load maximum.
+type Structuring struct {
+	Loads    models.ILoads
+	Policies models.IPolicies
+	Alerts   models.IAlerts
+}
+
+//Check looks back over the days ending with the load's day for either pattern and stores an alert for each one found.
+//It should be called once the load's decision is stored. Alerts are keyed on the customer, pattern and last day so
+//checking the same day again doesn't raise duplicates.
+func (s *Structuring) Check(load *models.Load) ([]*models.Alert, error) {
+	activePolicy, err := policy.EffectiveAt(s.Policies, load.Time)
+	if err != nil {
+		return nil, err
+	}
+	config := DefaultStructuring
+	if activePolicy.Structuring != nil {
+		config = *activePolicy.Structuring
+	}
+
+	dailyMax := dailyAmountLimit(activePolicy)
+	if dailyMax <= 0 || config.Days < 1 {
+		return nil, nil
+	}
+	floor := int64(float64(dailyMax) * (1 - config.NearPercent/100))
+
+	firstDay, lastDay := policy.DayWindow(load.Time)
+	firstDay = firstDay.AddDate(0, 0, -(config.Days - 1))
+	history, err := s.Loads.GetByCustomerTransactionsByDateRange(load.CustomerId, firstDay, lastDay)
+	if err != nil && !errors.Is(err, models.ErrNoRecord) {
+		return nil, err
+	}
+
+	days := make([]day, config.Days)
+	for _, stored := range history {
+		if !stored.Accepted || stored.Type == models.TypeDebit {
+			continue
+		}
+		index := int(stored.Time.UTC().Sub(firstDay) / (24 * time.Hour))
+		if index < 0 || index >= config.Days {
+			continue
+		}
+		days[index].total += stored.Amount
+		days[index].transactionIds = append(days[index].transactionIds, stored.TransactionId)
+		if stored.Amount >= floor && stored.Amount <= dailyMax {
+			days[index].nearLoads = append(days[index].nearLoads, stored)
+		}
+	}
+
+	var alerts []*models.Alert
+	nearLoadsEveryDay := true
+	nearTotalsEveryDay := true
+	for _, d := range days {
+		nearLoadsEveryDay = nearLoadsEveryDay && len(d.nearLoads) > 0
+		nearTotalsEveryDay = nearTotalsEveryDay && d.total >= floor && d.total <= dailyMax
+	}
+
+	if nearLoadsEveryDay {
+		var ids []int64
+		var amounts []string
+		for _, d := range days {
+			for _, near := range d.nearLoads {
+				ids = append(ids, near.TransactionId)
+				amounts = append(amounts, helpers.FormatAmount(near.Amount))
+			}
+		}
+		alerts = append(alerts, &models.Alert{
+			CustomerId:     load.CustomerId,
+			Pattern:        PatternNearLimitLoads,
+			WindowStart:    firstDay,
+			WindowEnd:      lastDay,
+			TransactionIds: ids,
+			Detail: fmt.Sprintf("loads of %s within %g%% of the %s maximum on %d consecutive days",
+				strings.Join(amounts, ", "), config.NearPercent, helpers.FormatAmount(dailyMax), config.Days),
+		})
+	}
+
+	if nearTotalsEveryDay {
+		var ids []int64
+		var totals []string
+		for _, d := range days {
+			ids = append(ids, d.transactionIds...)
+			totals = append(totals, helpers.FormatAmount(d.total))
+		}
+		alerts = append(alerts, &models.Alert{
+			CustomerId:     load.CustomerId,
+			Pattern:        PatternNearLimitDailyTotals,
+			WindowStart:    firstDay,
+			WindowEnd:      lastDay,
+			TransactionIds: ids,
+			Detail: fmt.Sprintf("daily totals of %s within %g%% of the %s daily maximum on %d consecutive days",
+				strings.Join(totals, ", "), config.NearPercent, helpers.FormatAmount(dailyMax), config.Days),
+		})
+	}
+
+	for _, alert := range alerts {
+		err = s.Alerts.Insert(alert)
+		if err != nil {
+			return nil, err
+		}
+	}
+	return alerts, nil
+}
+
+type day struct {
+	total          int64
+	transactionIds []int64
+	nearLoads      []*models.Load
+}
+
+//dailyAmountLimit finds the tightest live daily amount limit on all of the customer's loads in the policy
+func dailyAmountLimit(activePolicy *models.Policy) int64 {
+	dailyMax := int64(0)
+	for _, limit := range activePolicy.Limits {
+		if limit.Shadow || limit.Window != policy.WindowDay || limit.Metric != policy.MetricSum ||
+			limit.Scope == policy.ScopeLinked || len(limit.GroupBy) > 0 || len(limit.Where) > 0 {
+			continue
+		}
+		if dailyMax == 0 || limit.Max < dailyMax {
+			dailyMax = limit.Max
+		}
+	}
+	return dailyMax
+}
@@ -0,0 +1,94 @@
+package detect
+
+import (
+	"fireynis/velocity_checker/pkg/models"
+	"fireynis/velocity_checker/pkg/models/memory"
+	"fireynis/velocity_checker/pkg/models/mock"
+	"fireynis/velocity_checker/pkg/policy"
+	"testing"
+	"time"
+)
+
+func TestStructuring_Check(t *testing.T) {
+	day := time.Date(2000, 1, 3, 9, 0, 0, 0, time.UTC)
+	loadsOn := func(amounts ...[]int64) []*models.Load {
+		var loads []*models.Load
+		for i, daily := range amounts {
+			for j, amount := range daily {
+				id := int64(len(loads) + 1)
+				loads = append(loads, &models.Load{Id: id, TransactionId: id, CustomerId: 1, Amount: amount, Accepted: true, Time: day.AddDate(0, 0, i).Add(time.Duration(j) * time.Hour)})
+			}
+		}
+		return loads
+	}
+
+	tests := []struct {
+		name         string
+		history      []*models.Load
+		wantPatterns []string
+	}{
+		{"Large loads just under the limit", loadsOn([]int64{480000}, []int64{490000}, []int64{460000}), []string{PatternNearLimitLoads, PatternNearLimitDailyTotals}},
+		{"Small loads adding up to just under the limit", loadsOn([]int64{160000, 160000, 160000}, []int64{240000, 240000}, []int64{470000}), []string{PatternNearLimitDailyTotals}},
+		{"A day well under the limit", loadsOn([]int64{480000}, []int64{100000}, []int64{480000}), nil},
+		{"Only two days", loadsOn([]int64{480000}, []int64{480000}), nil},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			alerts := &mock.Alert{}
+			s := &Structuring{
+				Loads:    memory.NewLoad(tt.history),
+				Policies: &memory.Policy{Policy: &policy.Default},
+				Alerts:   alerts,
+			}
+
+			got, err := s.Check(tt.history[len(tt.history)-1])
+			if err != nil {
+				t.Fatalf("Check() unexpected error %v", err)
+			}
+			if len(got) != len(tt.wantPatterns) {
+				t.Fatalf("Check() got %d alerts, want %d", len(got), len(tt.wantPatterns))
+			}
+			for i, alert := range got {
+				if alert.Pattern != tt.wantPatterns[i] {
+					t.Errorf("Check() alert %d got pattern %s, want %s", i, alert.Pattern, tt.wantPatterns[i])
+				}
+			}
+
+			_, err = s.Check(tt.history[len(tt.history)-1])
+			if err != nil {
+				t.Fatalf("Check() unexpected error %v", err)
+			}
+			if len(alerts.Alerts) != len(tt.wantPatterns) {
+				t.Errorf("Check() a second time stored %d alerts, want %d", len(alerts.Alerts), len(tt.wantPatterns))
+			}
+		})
+	}
+}
+
+func TestStructuring_CheckConfigured(t *testing.T) {
+	day := time.Date(2000, 1, 3, 9, 0, 0, 0, time.UTC)
+	history := []*models.Load{
+		{Id: 1, TransactionId: 1, CustomerId: 1, Amount: 400000, Accepted: true, Time: day},
+		{Id: 2, TransactionId: 2, CustomerId: 1, Amount: 400000, Accepted: true, Time: day.AddDate(0, 0, 1)},
+	}
+	configured := policy.Default
+	configured.Structuring = &models.StructuringConfig{NearPercent: 25, Days: 2}
+
+	s := &Structuring{
+		Loads:    memory.NewLoad(history),
+		Policies: &memory.Policy{Policy: &configured},
+		Alerts:   &mock.Alert{},
+	}
+	got, err := s.Check(history[1])
+	if err != nil {
+		t.Fatalf("Check() unexpected error %v", err)
+	}
+	if len(got) != 2 {
+		t.Fatalf("Check() got %d alerts, want 2", len(got))
+	}
+	want := "loads of $4000.00, $4000.00 within 25% of the $5000.00 maximum on 2 consecutive days"
+	if got[0].Detail != want {
+		t.Errorf("Check() got detail %q, want %q", got[0].Detail, want)
+	}
+}
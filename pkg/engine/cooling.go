@@ -0,0 +1,179 @@
+package engine
+
+import (
+	"fireynis/velocity_checker/pkg/models"
+	"fireynis/velocity_checker/pkg/policy"
+	"fireynis/velocity_checker/pkg/validators"
+	"sort"
+	"time"
+)
+
+//RuleCoolingOff is the name of the trace entry for the cooling-off lockout
+const RuleCoolingOff = "cooling_off"
+
+//CoolingOff is a customer's cooling-off state at a point in time, derived from their stored decisions. Rejections are
+//the declined loads counted towards the lockout, Until is zero when the customer isn't locked out.
+type CoolingOff struct {
+	Rejections []*models.Load
+	Until      time.Time
+}
+
+//Locked reports whether the customer is locked out at t
+func (c CoolingOff) Locked(t time.Time) bool {
+	return t.Before(c.Until)
+}
+
+//CoolingOffAt works out whether the customer is in a cooling-off lockout at t. Every run of the configured number of
+//rejections within the window locks the customer out for the lockout from the last rejection of the run. Loads
+//declined because of a lockout don't count, so retrying while locked out doesn't extend it. The load with the given
+//transaction id is left out so that a stored load can be evaluated again.
+func (e *Engine) CoolingOffAt(config *models.CoolingOffConfig, customerId int64, transactionId int64, t time.Time) (CoolingOff, error) {
+	window, _ := time.ParseDuration(config.Window)
+	lockout, _ := time.ParseDuration(config.Lockout)
+
+	loads, err := e.loadsInWindow(customerId, t.Add(-window-lockout), t)
+	if err != nil {
+		return CoolingOff{}, err
+	}
+
+	var rejections []*models.Load
+	for _, load := range loads {
+		if load.TransactionId == transactionId || load.Type == models.TypeDebit {
+			continue
+		}
+		if load.Outcome == models.OutcomeDecline && load.Reason != models.ReasonCoolingOff {
+			rejections = append(rejections, load)
+		}
+	}
+	sort.SliceStable(rejections, func(i, j int) bool {
+		return rejections[i].Time.Before(rejections[j].Time)
+	})
+
+	state := CoolingOff{Rejections: []*models.Load{}}
+	for i, rejection := range rejections {
+		if !rejection.Time.Before(t.Add(-window)) {
+			state.Rejections = append(state.Rejections, rejection)
+		}
+		if i+1 < config.Rejections {
+			continue
+		}
+		first := rejections[i+1-config.Rejections]
+		if rejection.Time.Sub(first.Time) <= window {
+			until := rejection.Time.Add(lockout)
+			if until.After(state.Until) {
+				state.Until = until
+			}
+		}
+	}
+	return state, nil
+}
+
+//evaluateCoolingOff declines the load without checking the limits when the customer is locked out. The trace's total
+//is the rejections in the window and its threshold the rejections that trigger a lockout.
+func (e *Engine) evaluateCoolingOff(activePolicy *models.Policy, load *models.Load) (models.RuleTrace, error) {
+	config := activePolicy.CoolingOff
+	state, err := e.CoolingOffAt(config, load.CustomerId, load.TransactionId, load.Time)
+	if err != nil {
+		return models.RuleTrace{}, err
+	}
+
+	window, _ := time.ParseDuration(config.Window)
+	rule := newRuleTrace(models.Limit{Name: RuleCoolingOff}, state.Rejections, !state.Locked(load.Time))
+	rule.WindowStart = load.Time.Add(-window)
+	rule.WindowEnd = load.Time
+	rule.Total = int64(len(state.Rejections))
+	rule.Threshold = int64(config.Rejections)
+	if !rule.Passed {
+		rule.Values = map[string]int64{"until": state.Until.Unix()}
+	}
+	return rule, nil
+}
+
+//LimitHeadroom is how much of a limit a customer has used and has left
+type LimitHeadroom struct {
+	Name      string `json:"name"`
+	Metric    string `json:"metric"`
+	Window    string `json:"window,omitempty"`
+	Used      int64  `json:"used"`
+	Max       int64  `json:"max"`
+	Remaining int64  `json:"remaining"`
+}
+
+//Headroom is what a customer can still load at a point in time
+type Headroom struct {
+	CustomerId      int64           `json:"customer_id"`
+	At              time.Time       `json:"at"`
+	PolicyVersion   int64           `json:"policy_version,omitempty"`
+	Limits          []LimitHeadroom `json:"limits"`
+	CoolingOffUntil *time.Time      `json:"cooling_off_until,omitempty"`
+}
+
+//Headroom works out how much of each count, sum and balance limit the customer has left at t, and whether they are
+//locked out. Limits that depend on the load itself, expressions and those narrowed with where or group_by, and shadow
+//limits are left out.
+func (e *Engine) Headroom(customerId int64, t time.Time) (*Headroom, error) {
+	activePolicy, err := e.PolicyAt(t)
+	if err != nil {
+		return nil, err
+	}
+
+	load := &models.Load{CustomerId: customerId, Time: t}
+	source := &windowSource{engine: e, load: load, windows: make(map[string][]*models.Load)}
+	var linked *windowSource
+	headroom := &Headroom{CustomerId: customerId, At: t, PolicyVersion: activePolicy.Version, Limits: []LimitHeadroom{}}
+	for _, limit := range activePolicy.Limits {
+		if limit.Shadow || len(limit.Expression) > 0 || len(limit.Where) > 0 || len(limit.GroupBy) > 0 {
+			continue
+		}
+
+		limitSource := source
+		if limit.Scope == policy.ScopeLinked {
+			if linked == nil {
+				linked, err = e.linkedSource(load)
+				if err != nil {
+					return nil, err
+				}
+			}
+			limitSource = linked
+		}
+
+		entry := LimitHeadroom{Name: limit.Name, Metric: limit.Metric, Window: limit.Window, Max: limit.Max}
+		switch limit.Metric {
+		case policy.MetricCount, policy.MetricSum:
+			loads, err := limitSource.LoadsInWindow(limit.Window)
+			if err != nil {
+				return nil, err
+			}
+			entry.Used = int64(len(loads))
+			if limit.Metric == policy.MetricSum {
+				entry.Used = validators.SumAmounts(loads, &models.Load{})
+			}
+		case policy.MetricBalance:
+			if e.Balances == nil {
+				continue
+			}
+			entry.Used, err = e.Balances.Get(customerId)
+			if err != nil {
+				return nil, err
+			}
+		default:
+			continue
+		}
+		entry.Remaining = entry.Max - entry.Used
+		if entry.Remaining < 0 {
+			entry.Remaining = 0
+		}
+		headroom.Limits = append(headroom.Limits, entry)
+	}
+
+	if activePolicy.CoolingOff != nil {
+		state, err := e.CoolingOffAt(activePolicy.CoolingOff, customerId, 0, t)
+		if err != nil {
+			return nil, err
+		}
+		if state.Locked(t) {
+			headroom.CoolingOffUntil = &state.Until
+		}
+	}
+	return headroom, nil
+}
@@ -0,0 +1,476 @@
+package engine
+
+import (
+	"errors"
+	"fireynis/velocity_checker/pkg/detect"
+	"fireynis/velocity_checker/pkg/linkage"
+	"fireynis/velocity_checker/pkg/models"
+	"fireynis/velocity_checker/pkg/notify"
+	"fireynis/velocity_checker/pkg/policy"
+	"fireynis/velocity_checker/pkg/validators"
+	"fmt"
+	"math"
+	"sync"
+	"time"
+)
+
+//RuleSufficientBalance is the name of the trace entry for a debit, which only needs the customer's balance to cover it
+const RuleSufficientBalance = "sufficient_balance"
+
+//Engine holds the decision logic shared by the cli and web binaries.
+type Engine struct {
+	Loads     models.ILoads
+	Policies  models.IPolicies
+	Validator validators.ILoadValidator
+	//Links groups linked customers for linked limits. Without it a customer is only linked to themselves.
+	Links models.ILinks
+	//Balances checks debits and balance limits. Without it both are passed, as a backtest has no balances to replay.
+	Balances models.IBalances
+	//Notifier is told when an accepted load nears a daily or weekly allowance, Notifications stops it being told twice
+	Notifier      notify.INotifier
+	Notifications models.INotifications
+
+	//expressions caches compiled limit expressions by their source
+	expressions sync.Map
+}
+
+//Evaluate checks the load against every limit in the policy effective at the load's time and, when the policy has a
+//risk config, scores it. It sets the load's Accepted, Outcome, Status, RiskScore and PolicyVersion and returns the
+//trace of each limit evaluated. The trace is always built so that it can be stored and retrieved later even when the
+//caller did not ask for it.
+//
+//A debit isn't checked against the limits, it is accepted when the customer's balance covers it. A load from a
+//customer in a cooling-off lockout is declined with ReasonCoolingOff without being checked against the limits either.
+//
+//The balance a debit or balance limit was checked against is read outside the transaction the load is stored in, so the
+//load's BalanceMin and BalanceMax are set to the bounds it was checked against for the store to hold the balance to.
+func (e *Engine) Evaluate(load *models.Load) ([]models.RuleTrace, error) {
+	activePolicy, err := e.PolicyAt(load.Time)
+	if err != nil {
+		return nil, err
+	}
+	load.BalanceMin = nil
+	load.BalanceMax = nil
+	if load.Type == models.TypeDebit {
+		return e.evaluateDebit(load, activePolicy)
+	}
+
+	trace := make([]models.RuleTrace, 0, len(activePolicy.Limits)+1)
+	load.Reason = ""
+	if activePolicy.CoolingOff != nil {
+		rule, err := e.evaluateCoolingOff(activePolicy, load)
+		if err != nil {
+			return nil, err
+		}
+		trace = append(trace, rule)
+		if !rule.Passed {
+			load.Accepted = false
+			load.PolicyVersion = activePolicy.Version
+			load.RiskScore = 0
+			load.Outcome = models.OutcomeDecline
+			load.Status = models.StatusDecided
+			load.Reason = models.ReasonCoolingOff
+			return trace, nil
+		}
+	}
+
+	source := &windowSource{engine: e, load: load, windows: make(map[string][]*models.Load)}
+	var linked *windowSource
+	for _, limit := range activePolicy.Limits {
+		limitSource := source
+		if limit.Scope == policy.ScopeLinked {
+			if linked == nil {
+				linked, err = e.linkedSource(load)
+				if err != nil {
+					return nil, err
+				}
+			}
+			limitSource = linked
+		}
+
+		var rule models.RuleTrace
+		switch {
+		case len(limit.Expression) > 0:
+			rule, err = e.evaluateExpression(limit, limitSource)
+		case limit.Metric == policy.MetricAnomaly:
+			rule, err = e.evaluateAnomaly(limit, policy.AnomalyFor(activePolicy), limitSource)
+		case limit.Metric == policy.MetricBalance:
+			rule, err = e.evaluateBalance(limit, load)
+		case limit.Metric == policy.MetricAmount:
+			rule = evaluateAmount(limit, load)
+		default:
+			rule, err = e.evaluateLimit(limit, limitSource)
+		}
+		if err != nil {
+			return nil, err
+		}
+		rule.CustomerIds = limitSource.customerIds
+		trace = append(trace, rule)
+	}
+
+	load.Accepted = true
+	for _, rule := range trace {
+		if !rule.Shadow {
+			load.Accepted = load.Accepted && rule.Passed
+			if len(rule.Error) > 0 && len(load.Reason) < 1 {
+				load.Reason = fmt.Sprintf("%s: %s: %s", models.ReasonExpressionError, rule.Rule, rule.Error)
+			}
+		}
+	}
+	load.PolicyVersion = activePolicy.Version
+	load.RiskScore = 0
+	load.Outcome = models.OutcomeDecline
+	if load.Accepted {
+		load.Outcome = models.OutcomeApprove
+	}
+
+	if activePolicy.Risk != nil {
+		rule, err := e.score(activePolicy, source, trace)
+		if err != nil {
+			return nil, err
+		}
+		trace = append(trace, rule)
+	}
+
+	load.Status = models.StatusDecided
+	if load.Outcome == models.OutcomeReview {
+		load.Status = models.StatusPendingReview
+	}
+	return trace, nil
+}
+
+//PolicyAt returns the policy version effective at t, falling back to the default policy when none has been stored
+func (e *Engine) PolicyAt(t time.Time) (*models.Policy, error) {
+	return policy.EffectiveAt(e.Policies, t)
+}
+
+//evaluateLimit checks a window, metric and max limit
+func (e *Engine) evaluateLimit(limit models.Limit, source *windowSource) (models.RuleTrace, error) {
+	start, end, err := policy.WindowBounds(limit.Window, source.load.Time)
+	if err != nil {
+		return models.RuleTrace{}, err
+	}
+	loads, err := source.LoadsInWindow(limit.Window)
+	if err != nil {
+		return models.RuleTrace{}, err
+	}
+
+	loads, applies := narrow(limit, loads, source.load)
+
+	//A load the limit doesn't cover can't take it over, so it passes and the totals leave it out
+	var total int64
+	passed := true
+	switch limit.Metric {
+	case policy.MetricCount:
+		total = int64(len(loads))
+		if applies {
+			total++
+			passed = e.Validator.LessThanMaxLoads(loads, limit.Max)
+		}
+	case policy.MetricSum:
+		if applies {
+			total = validators.SumAmounts(loads, source.load)
+			passed = e.Validator.SumLessThanMax(loads, source.load, limit.Max)
+		} else {
+			total = validators.SumAmounts(loads, &models.Load{})
+		}
+	case policy.MetricDistinct:
+		values := make(map[string]bool)
+		for _, load := range loads {
+			values[policy.FieldValue(load, limit.Field)] = true
+		}
+		if applies {
+			values[policy.FieldValue(source.load, limit.Field)] = true
+		}
+		delete(values, "")
+		total = int64(len(values))
+		passed = !applies || total <= limit.Max
+	default:
+		return models.RuleTrace{}, errors.New("engine: unknown metric " + limit.Metric)
+	}
+
+	rule := newRuleTrace(limit, loads, passed)
+	rule.WindowStart = start
+	rule.WindowEnd = end
+	rule.Total = total
+	rule.Threshold = limit.Max
+	return rule, nil
+}
+
+//evaluateBalance checks the customer's balance after the load against the limit's cap
+func (e *Engine) evaluateBalance(limit models.Limit, load *models.Load) (models.RuleTrace, error) {
+	rule := newRuleTrace(limit, nil, true)
+	rule.WindowEnd = load.Time
+	rule.Threshold = limit.Max
+	if e.Balances == nil {
+		return rule, nil
+	}
+
+	balance, err := e.Balances.Get(load.CustomerId)
+	if err != nil {
+		return models.RuleTrace{}, err
+	}
+	rule.Total = balance + load.Amount
+	rule.Passed = rule.Total <= limit.Max
+	if !limit.Shadow && (load.BalanceMax == nil || limit.Max < *load.BalanceMax) {
+		max := limit.Max
+		load.BalanceMax = &max
+	}
+	return rule, nil
+}
+
+//evaluateAmount checks the load's own amount is within the limit's bounds. A min is kept in the trace's values as the
+//threshold is the max.
+func evaluateAmount(limit models.Limit, load *models.Load) models.RuleTrace {
+	passed := load.Amount >= limit.Min && (limit.Max == 0 || load.Amount <= limit.Max)
+	rule := newRuleTrace(limit, nil, passed)
+	rule.WindowEnd = load.Time
+	rule.Total = load.Amount
+	rule.Threshold = limit.Max
+	if limit.Min > 0 {
+		rule.Values = map[string]int64{"min": limit.Min}
+	}
+	return rule
+}
+
+//evaluateDebit accepts the debit when the customer's balance covers it. The trace's total is the debit and its
+//threshold the balance.
+func (e *Engine) evaluateDebit(load *models.Load, activePolicy *models.Policy) ([]models.RuleTrace, error) {
+	rule := models.RuleTrace{Rule: RuleSufficientBalance, WindowEnd: load.Time, Total: load.Amount, TransactionIds: []int64{}, Passed: true}
+	if e.Balances != nil {
+		balance, err := e.Balances.Get(load.CustomerId)
+		if err != nil {
+			return nil, err
+		}
+		rule.Threshold = balance
+		rule.Passed = load.Amount <= balance
+		//The debit can't take the balance below 0
+		var min int64
+		load.BalanceMin = &min
+	}
+
+	load.Accepted = rule.Passed
+	load.PolicyVersion = activePolicy.Version
+	load.RiskScore = 0
+	load.Outcome = models.OutcomeDecline
+	if load.Accepted {
+		load.Outcome = models.OutcomeApprove
+	}
+	load.Status = models.StatusDecided
+	return []models.RuleTrace{rule}, nil
+}
+
+//DeclineBalanceChanged declines a load the engine accepted after the store refused it with models.ErrBalanceChanged,
+//as the customer's balance moved after it was checked. The load is left ready to be stored again.
+func DeclineBalanceChanged(load *models.Load) {
+	load.Accepted = false
+	load.Outcome = models.OutcomeDecline
+	load.Status = models.StatusDecided
+	load.Reason = models.ReasonBalanceChanged
+	load.BalanceMin = nil
+	load.BalanceMax = nil
+}
+
+//evaluateExpression checks an expression limit. The trace covers every load any of its aggregates read, from the start
+//of the earliest window to the end of the latest. An expression that fails while being evaluated, such as dividing by
+//the count of a customer with no history, fails the limit with the error on its trace rather than failing the decision.
+func (e *Engine) evaluateExpression(limit models.Limit, source *windowSource) (models.RuleTrace, error) {
+	expression, err := e.compile(limit.Expression)
+	if err != nil {
+		return models.RuleTrace{}, err
+	}
+
+	passed, values, err := expression.Eval(source)
+	if errors.Is(err, policy.ErrEval) {
+		rule := newRuleTrace(limit, nil, false)
+		rule.Expression = limit.Expression
+		rule.Error = err.Error()
+		return rule, nil
+	}
+	if err != nil {
+		return models.RuleTrace{}, errors.New("engine: limit " + limit.Name + ": " + err.Error())
+	}
+
+	var loads []*models.Load
+	var windowStart, windowEnd time.Time
+	seen := make(map[int64]bool)
+	for _, window := range expression.Windows() {
+		start, end, err := policy.WindowBounds(window, source.load.Time)
+		if err != nil {
+			return models.RuleTrace{}, err
+		}
+		if windowStart.IsZero() || start.Before(windowStart) {
+			windowStart = start
+		}
+		if end.After(windowEnd) {
+			windowEnd = end
+		}
+
+		windowLoads, err := source.LoadsInWindow(window)
+		if err != nil {
+			return models.RuleTrace{}, err
+		}
+		for _, load := range windowLoads {
+			if !seen[load.Id] {
+				seen[load.Id] = true
+				loads = append(loads, load)
+			}
+		}
+	}
+
+	rule := newRuleTrace(limit, loads, passed)
+	rule.WindowStart = windowStart
+	rule.WindowEnd = windowEnd
+	rule.Expression = limit.Expression
+	rule.Values = values
+	return rule, nil
+}
+
+//narrow keeps the loads a limit with where or group_by covers and reports whether the load being evaluated is one of
+//them. Loads without a value for the group_by field are grouped together.
+func narrow(limit models.Limit, loads []*models.Load, load *models.Load) ([]*models.Load, bool) {
+	if len(limit.Where) == 0 && len(limit.GroupBy) == 0 {
+		return loads, true
+	}
+
+	covers := func(candidate *models.Load) bool {
+		for field, want := range limit.Where {
+			if policy.FieldValue(candidate, field) != want {
+				return false
+			}
+		}
+		return len(limit.GroupBy) == 0 || policy.FieldValue(candidate, limit.GroupBy) == policy.FieldValue(load, limit.GroupBy)
+	}
+
+	narrowed := make([]*models.Load, 0, len(loads))
+	for _, candidate := range loads {
+		if covers(candidate) {
+			narrowed = append(narrowed, candidate)
+		}
+	}
+	return narrowed, covers(load)
+}
+
+//evaluateAnomaly checks the load's amount and time of day against the customer's history in the limit's window. The
+//trace holds the scores scaled by 100 alongside the tier's thresholds.
+func (e *Engine) evaluateAnomaly(limit models.Limit, config models.AnomalyConfig, source *windowSource) (models.RuleTrace, error) {
+	start, end, err := policy.WindowBounds(limit.Window, source.load.Time)
+	if err != nil {
+		return models.RuleTrace{}, err
+	}
+	loads, err := source.LoadsInWindow(limit.Window)
+	if err != nil {
+		return models.RuleTrace{}, err
+	}
+
+	result, tier := detect.Anomaly(config, loads, source.load)
+	rule := newRuleTrace(limit, loads, !result.Outlier())
+	rule.WindowStart = start
+	rule.WindowEnd = end
+	rule.Tier = result.Tier
+	rule.Values = map[string]int64{
+		"history":          int64(result.History),
+		"amount_score":     int64(math.Round(result.AmountScore * 100)),
+		"amount_threshold": int64(math.Round(tier.AmountScore * 100)),
+		"hour_score":       int64(math.Round(result.HourScore * 100)),
+		"hour_threshold":   int64(math.Round(tier.HourScore * 100)),
+	}
+	return rule, nil
+}
+
+//compile returns the compiled expression, compiling it the first time it is seen. Policies are validated when they
+//are loaded so an error here means a stored policy was edited by hand.
+func (e *Engine) compile(source string) (*policy.Expression, error) {
+	cached, ok := e.expressions.Load(source)
+	if ok {
+		return cached.(*policy.Expression), nil
+	}
+
+	expression, err := policy.Compile(source)
+	if err != nil {
+		return nil, err
+	}
+	e.expressions.Store(source, expression)
+	return expression, nil
+}
+
+//linkedSource gives limits the loads of every customer linked to the load's customer
+func (e *Engine) linkedSource(load *models.Load) (*windowSource, error) {
+	customerIds := []int64{load.CustomerId}
+	if e.Links != nil {
+		var err error
+		customerIds, err = linkage.Group(e.Links, load)
+		if err != nil {
+			return nil, err
+		}
+	}
+	return &windowSource{engine: e, load: load, windows: make(map[string][]*models.Load), customerIds: customerIds}, nil
+}
+
+//loadsInWindow treats a customer with no loads in the window the same as an empty window
+func (e *Engine) loadsInWindow(customerId int64, startDate time.Time, endDate time.Time) ([]*models.Load, error) {
+	loads, err := e.Loads.GetByCustomerTransactionsByDateRange(customerId, startDate, endDate)
+	if err != nil {
+		if errors.Is(err, models.ErrNoRecord) {
+			return []*models.Load{}, nil
+		}
+		return nil, err
+	}
+	return loads, nil
+}
+
+//windowSource gives limits the customer's loads by window, fetching each window only once per evaluation as limits
+//commonly share them. When customerIds is set the loads of all of those customers are given instead. Debits are left
+//out as limits only cover money coming in.
+type windowSource struct {
+	engine      *Engine
+	load        *models.Load
+	windows     map[string][]*models.Load
+	customerIds []int64
+}
+
+func (s *windowSource) Load() *models.Load {
+	return s.load
+}
+
+func (s *windowSource) LoadsInWindow(window string) ([]*models.Load, error) {
+	loads, ok := s.windows[window]
+	if ok {
+		return loads, nil
+	}
+
+	start, end, err := policy.WindowBounds(window, s.load.Time)
+	if err != nil {
+		return nil, err
+	}
+	if s.customerIds != nil {
+		loads, err = s.engine.Loads.GetByCustomersByDateRange(s.customerIds, start, end)
+	} else {
+		loads, err = s.engine.loadsInWindow(s.load.CustomerId, start, end)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	credits := make([]*models.Load, 0, len(loads))
+	for _, load := range loads {
+		if load.Type != models.TypeDebit {
+			credits = append(credits, load)
+		}
+	}
+	s.windows[window] = credits
+	return credits, nil
+}
+
+func newRuleTrace(limit models.Limit, loads []*models.Load, passed bool) models.RuleTrace {
+	ids := make([]int64, 0, len(loads))
+	for _, load := range loads {
+		ids = append(ids, load.TransactionId)
+	}
+	return models.RuleTrace{
+		Rule:           limit.Name,
+		TransactionIds: ids,
+		Passed:         passed,
+		Shadow:         limit.Shadow,
+	}
+}
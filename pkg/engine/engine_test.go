@@ -0,0 +1,563 @@
+package engine
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fireynis/velocity_checker/pkg/models"
+	"fireynis/velocity_checker/pkg/models/memory"
+	"fireynis/velocity_checker/pkg/models/mock"
+	"fireynis/velocity_checker/pkg/notify"
+	"fireynis/velocity_checker/pkg/validators"
+	"reflect"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestEngine_EvaluateRisk(t *testing.T) {
+	day := time.Date(2000, 1, 4, 0, 0, 0, 0, time.UTC)
+	store := memory.NewLoad([]*models.Load{
+		{Id: 1, TransactionId: 1, CustomerId: 1, Amount: 100000, Time: day.Add(-48 * time.Hour), Accepted: true},
+	})
+	e := &Engine{
+		Loads: store,
+		Policies: &memory.Policy{Policy: &models.Policy{
+			Version: 7,
+			Limits: []models.Limit{
+				{Name: "daily_load_amount", Window: "day", Metric: "sum", Max: 500000},
+			},
+			Risk: &models.RiskConfig{
+				Weights:   map[string]float64{"new_customer": 1, "unusual_hour": 1},
+				ReviewAt:  40,
+				DeclineAt: 80,
+			},
+		}},
+		Validator: &validators.LoadValidator{},
+	}
+
+	tests := []struct {
+		name         string
+		load         *models.Load
+		wantScore    int64
+		wantOutcome  string
+		wantAccepted bool
+	}{
+		{"New customer at night", &models.Load{TransactionId: 1, CustomerId: 2, Amount: 1000, Time: day.Add(3 * time.Hour)}, 100, models.OutcomeDecline, false},
+		{"New customer by day", &models.Load{TransactionId: 1, CustomerId: 2, Amount: 1000, Time: day.Add(12 * time.Hour)}, 50, models.OutcomeReview, false},
+		{"Known customer by day", &models.Load{TransactionId: 2, CustomerId: 1, Amount: 1000, Time: day.Add(12 * time.Hour)}, 0, models.OutcomeApprove, true},
+		{"Over a limit with a low score", &models.Load{TransactionId: 2, CustomerId: 1, Amount: 600000, Time: day.Add(12 * time.Hour)}, 0, models.OutcomeDecline, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			trace, err := e.Evaluate(tt.load)
+			if err != nil {
+				t.Fatalf("Evaluate() unexpected error %v", err)
+			}
+			if tt.load.RiskScore != tt.wantScore || tt.load.Outcome != tt.wantOutcome || tt.load.Accepted != tt.wantAccepted {
+				t.Errorf("Evaluate() got score %d, outcome %s, accepted %v; want %d, %s, %v", tt.load.RiskScore,
+					tt.load.Outcome, tt.load.Accepted, tt.wantScore, tt.wantOutcome, tt.wantAccepted)
+			}
+			if tt.load.PolicyVersion != 7 {
+				t.Errorf("Evaluate() policy version %d, want 7", tt.load.PolicyVersion)
+			}
+			if trace[len(trace)-1].Rule != RuleRiskScore {
+				t.Errorf("Evaluate() want the risk score last in the trace; got %+v", trace)
+			}
+		})
+	}
+}
+
+func TestEngine_EvaluateAnomaly(t *testing.T) {
+	day := time.Date(2000, 2, 1, 0, 0, 0, 0, time.UTC)
+	var history []*models.Load
+	for i := 1; i <= 12; i++ {
+		history = append(history, &models.Load{Id: int64(i), TransactionId: int64(i), CustomerId: 1, Amount: 20000, Time: day.AddDate(0, 0, -i).Add(14 * time.Hour), Accepted: true})
+	}
+	e := &Engine{
+		Loads: memory.NewLoad(history),
+		Policies: &memory.Policy{Policy: &models.Policy{
+			Limits: []models.Limit{
+				{Name: "daily_load_amount", Window: "day", Metric: "sum", Max: 500000},
+				{Name: "unusual_for_customer", Window: "2160h", Metric: "anomaly"},
+			},
+			Risk: &models.RiskConfig{
+				Weights:   map[string]float64{"anomaly": 1},
+				ReviewAt:  50,
+				DeclineAt: 101,
+			},
+		}},
+		Validator: &validators.LoadValidator{},
+	}
+
+	usual := &models.Load{TransactionId: 20, CustomerId: 1, Amount: 20500, Time: day.Add(14 * time.Hour)}
+	trace, err := e.Evaluate(usual)
+	if err != nil {
+		t.Fatalf("Evaluate() unexpected error %v", err)
+	}
+	if !usual.Accepted || usual.Outcome != models.OutcomeApprove || !trace[1].Passed || trace[1].Tier != "established" {
+		t.Errorf("Evaluate() of a usual load got %+v with trace %+v", usual, trace)
+	}
+
+	unusual := &models.Load{TransactionId: 21, CustomerId: 1, Amount: 200000, Time: day.Add(14 * time.Hour)}
+	trace, err = e.Evaluate(unusual)
+	if err != nil {
+		t.Fatalf("Evaluate() unexpected error %v", err)
+	}
+	if unusual.Accepted || trace[1].Passed || trace[1].Values["history"] != 12 {
+		t.Errorf("Evaluate() of an unusual load got %+v with trace %+v", unusual, trace)
+	}
+	if unusual.RiskScore != 100 {
+		t.Errorf("Evaluate() of an unusual load got risk score %d, want 100", unusual.RiskScore)
+	}
+}
+
+func TestEngine_EvaluateLinked(t *testing.T) {
+	day := time.Date(2000, 1, 4, 0, 0, 0, 0, time.UTC)
+	links := &mock.Link{Links: []*models.Link{
+		{Kind: "device", Value: "phone-a", CustomerId: 1, FirstSeen: day},
+		{Kind: "device", Value: "phone-a", CustomerId: 2, FirstSeen: day},
+	}}
+	e := &Engine{
+		Loads: memory.NewLoad([]*models.Load{
+			{Id: 1, TransactionId: 1, CustomerId: 1, Amount: 300000, Time: day.Add(time.Hour), Accepted: true},
+			{Id: 2, TransactionId: 1, CustomerId: 2, Amount: 300000, Time: day.Add(2 * time.Hour), Accepted: true},
+		}),
+		Policies: &memory.Policy{Policy: &models.Policy{
+			Limits: []models.Limit{
+				{Name: "daily_load_amount", Window: "day", Metric: "sum", Max: 500000},
+				{Name: "linked_daily_load_amount", Window: "day", Metric: "sum", Max: 1000000, Scope: "linked"},
+			},
+		}},
+		Validator: &validators.LoadValidator{},
+		Links:     links,
+	}
+
+	tests := []struct {
+		name         string
+		load         *models.Load
+		wantAccepted bool
+		wantGroup    []int64
+	}{
+		{"Within the group limit", &models.Load{TransactionId: 2, CustomerId: 1, Amount: 150000, Time: day.Add(3 * time.Hour), DeviceId: "phone-a"}, true, []int64{1, 2}},
+		{"New customer taking the group over", &models.Load{TransactionId: 1, CustomerId: 3, Amount: 450000, Time: day.Add(3 * time.Hour), DeviceId: "phone-a"}, false, []int64{1, 2, 3}},
+		{"Unlinked customer", &models.Load{TransactionId: 1, CustomerId: 4, Amount: 450000, Time: day.Add(3 * time.Hour), DeviceId: "phone-b"}, true, []int64{4}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			trace, err := e.Evaluate(tt.load)
+			if err != nil {
+				t.Fatalf("Evaluate() unexpected error %v", err)
+			}
+			if tt.load.Accepted != tt.wantAccepted {
+				t.Errorf("Evaluate() accepted %v, want %v; trace %+v", tt.load.Accepted, tt.wantAccepted, trace)
+			}
+			if !reflect.DeepEqual(trace[1].CustomerIds, tt.wantGroup) {
+				t.Errorf("Evaluate() linked limit covered %v, want %v", trace[1].CustomerIds, tt.wantGroup)
+			}
+			if trace[0].CustomerIds != nil {
+				t.Errorf("Evaluate() customer limit covered %v, want nothing", trace[0].CustomerIds)
+			}
+		})
+	}
+}
+
+func TestEngine_EvaluateDimensions(t *testing.T) {
+	day := time.Date(2000, 1, 4, 0, 0, 0, 0, time.UTC)
+	e := &Engine{
+		Loads: memory.NewLoad([]*models.Load{
+			{Id: 1, TransactionId: 1, CustomerId: 1, Amount: 800000, Time: day.Add(time.Hour), Channel: "branch", FundingType: "card", FundingSource: "card-a"},
+			{Id: 2, TransactionId: 2, CustomerId: 1, Amount: 100000, Time: day.Add(2 * time.Hour), Channel: "web", FundingType: "card", FundingSource: "card-b"},
+			{Id: 3, TransactionId: 3, CustomerId: 1, Amount: 100000, Time: day.Add(3 * time.Hour), Channel: "web", FundingType: "bank_account", FundingSource: "acct-1"},
+		}),
+		Policies: &memory.Policy{Policy: &models.Policy{
+			Limits: []models.Limit{
+				{Name: "branch_daily_amount", Window: "day", Metric: "sum", Max: 1000000, Where: map[string]string{"channel": "branch"}},
+				{Name: "per_channel_daily_count", Window: "day", Metric: "count", Max: 3, GroupBy: "channel"},
+				{Name: "weekly_cards", Window: "week", Metric: "distinct", Field: "funding_source", Max: 2, Where: map[string]string{"funding_type": "card"}},
+			},
+		}},
+		Validator: &validators.LoadValidator{},
+	}
+
+	tests := []struct {
+		name       string
+		load       *models.Load
+		wantPassed []bool
+		wantTotals []int64
+	}{
+		{"Branch load over the branch limit", &models.Load{TransactionId: 4, CustomerId: 1, Amount: 300000, Time: day.Add(4 * time.Hour), Channel: "branch", FundingType: "card", FundingSource: "card-a"}, []bool{false, true, true}, []int64{1100000, 2, 2}},
+		{"Web load leaves the branch limit alone", &models.Load{TransactionId: 4, CustomerId: 1, Amount: 300000, Time: day.Add(4 * time.Hour), Channel: "web", FundingType: "bank_account", FundingSource: "acct-1"}, []bool{true, true, true}, []int64{800000, 3, 2}},
+		{"Third card", &models.Load{TransactionId: 4, CustomerId: 1, Amount: 1000, Time: day.Add(4 * time.Hour), Channel: "mobile", FundingType: "card", FundingSource: "card-c"}, []bool{true, true, false}, []int64{800000, 1, 3}},
+		{"Third web load", &models.Load{TransactionId: 4, CustomerId: 1, Amount: 1000, Time: day.Add(4 * time.Hour), Channel: "web", FundingType: "card", FundingSource: "card-b"}, []bool{true, true, true}, []int64{800000, 3, 2}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			trace, err := e.Evaluate(tt.load)
+			if err != nil {
+				t.Fatalf("Evaluate() unexpected error %v", err)
+			}
+			for i, rule := range trace {
+				if rule.Passed != tt.wantPassed[i] || rule.Total != tt.wantTotals[i] {
+					t.Errorf("Evaluate() %s got passed %v total %d, want %v %d", rule.Rule, rule.Passed, rule.Total, tt.wantPassed[i], tt.wantTotals[i])
+				}
+			}
+		})
+	}
+}
+
+func TestEngine_EvaluateBalance(t *testing.T) {
+	day := time.Date(2000, 1, 4, 0, 0, 0, 0, time.UTC)
+	e := &Engine{
+		Loads: memory.NewLoad([]*models.Load{
+			{Id: 1, TransactionId: 1, CustomerId: 1, Type: "load", Amount: 400000, Time: day.Add(time.Hour), Accepted: true},
+			{Id: 2, TransactionId: 2, CustomerId: 1, Type: "debit", Amount: 300000, Time: day.Add(2 * time.Hour), Accepted: true},
+		}),
+		Policies: &memory.Policy{Policy: &models.Policy{
+			Limits: []models.Limit{
+				{Name: "daily_load_amount", Window: "day", Metric: "sum", Max: 500000},
+				{Name: "max_balance", Metric: "balance", Max: 250000},
+			},
+		}},
+		Validator: &validators.LoadValidator{},
+		Balances:  &mock.Balance{Balances: map[int64]int64{1: 100000}},
+	}
+
+	tests := []struct {
+		name         string
+		load         *models.Load
+		wantAccepted bool
+		wantTotal    int64
+		wantRule     string
+	}{
+		{"Load within the cap", &models.Load{TransactionId: 3, CustomerId: 1, Type: "load", Amount: 100000, Time: day.Add(3 * time.Hour)}, true, 200000, "max_balance"},
+		{"Load over the cap", &models.Load{TransactionId: 3, CustomerId: 1, Type: "load", Amount: 200000, Time: day.Add(3 * time.Hour)}, false, 300000, "max_balance"},
+		{"Customer without a balance", &models.Load{TransactionId: 3, CustomerId: 2, Type: "load", Amount: 100000, Time: day.Add(3 * time.Hour)}, true, 100000, "max_balance"},
+		{"Covered debit", &models.Load{TransactionId: 3, CustomerId: 1, Type: "debit", Amount: 100000, Time: day.Add(3 * time.Hour)}, true, 100000, RuleSufficientBalance},
+		{"Overdrawing debit", &models.Load{TransactionId: 3, CustomerId: 1, Type: "debit", Amount: 100001, Time: day.Add(3 * time.Hour)}, false, 100001, RuleSufficientBalance},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			trace, err := e.Evaluate(tt.load)
+			if err != nil {
+				t.Fatalf("Evaluate() unexpected error %v", err)
+			}
+			if tt.load.Accepted != tt.wantAccepted {
+				t.Errorf("Evaluate() accepted %v, want %v; trace %+v", tt.load.Accepted, tt.wantAccepted, trace)
+			}
+			rule := trace[len(trace)-1]
+			if rule.Rule != tt.wantRule || rule.Total != tt.wantTotal {
+				t.Errorf("Evaluate() last rule %s total %d, want %s %d", rule.Rule, rule.Total, tt.wantRule, tt.wantTotal)
+			}
+		})
+	}
+
+	//The store is told the bounds the balance was checked against
+	load := &models.Load{TransactionId: 3, CustomerId: 1, Type: "load", Amount: 100000, Time: day.Add(3 * time.Hour)}
+	_, _ = e.Evaluate(load)
+	if load.BalanceMin != nil || load.BalanceMax == nil || *load.BalanceMax != 250000 {
+		t.Errorf("Evaluate() balance bounds %v %v, want no min and a max of 250000", load.BalanceMin, load.BalanceMax)
+	}
+	debit := &models.Load{TransactionId: 3, CustomerId: 1, Type: "debit", Amount: 100000, Time: day.Add(3 * time.Hour)}
+	_, _ = e.Evaluate(debit)
+	if debit.BalanceMin == nil || *debit.BalanceMin != 0 || debit.BalanceMax != nil {
+		t.Errorf("Evaluate() debit balance bounds %v %v, want a min of 0 and no max", debit.BalanceMin, debit.BalanceMax)
+	}
+
+	//The debit would take the day's total to 700000 if it counted
+	trace, _ := e.Evaluate(&models.Load{TransactionId: 3, CustomerId: 1, Type: "load", Amount: 100000, Time: day.Add(3 * time.Hour)})
+	if trace[0].Total != 500000 || !trace[0].Passed {
+		t.Errorf("Evaluate() daily total %d, want 500000 without the debit", trace[0].Total)
+	}
+}
+
+func TestEngine_EvaluateAmount(t *testing.T) {
+	day := time.Date(2000, 1, 4, 0, 0, 0, 0, time.UTC)
+	e := &Engine{
+		Loads: memory.NewLoad(nil),
+		Policies: &memory.Policy{Policy: &models.Policy{
+			Limits: []models.Limit{
+				{Name: "per_load_amount", Metric: "amount", Min: 100, Max: 300000},
+			},
+		}},
+		Validator: &validators.LoadValidator{},
+	}
+
+	tests := []struct {
+		name         string
+		amount       int64
+		wantAccepted bool
+	}{
+		{"Zero", 0, false},
+		{"Below the min", 99, false},
+		{"At the min", 100, true},
+		{"At the max", 300000, true},
+		{"Above the max", 300001, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			load := &models.Load{TransactionId: 1, CustomerId: 1, Amount: tt.amount, Time: day}
+			trace, err := e.Evaluate(load)
+			if err != nil {
+				t.Fatalf("Evaluate() unexpected error %v", err)
+			}
+			if load.Accepted != tt.wantAccepted {
+				t.Errorf("Evaluate() accepted %v, want %v; trace %+v", load.Accepted, tt.wantAccepted, trace)
+			}
+			if trace[0].Total != tt.amount || trace[0].Values["min"] != 100 {
+				t.Errorf("Evaluate() trace %+v, want the amount and min", trace[0])
+			}
+		})
+	}
+}
+
+func TestEngine_EvaluateExpressionError(t *testing.T) {
+	day := time.Date(2000, 1, 4, 0, 0, 0, 0, time.UTC)
+	e := &Engine{
+		Loads: memory.NewLoad([]*models.Load{
+			{Id: 1, TransactionId: 1, CustomerId: 1, Amount: 100000, Time: day.Add(-24 * time.Hour), Accepted: true},
+		}),
+		Policies: &memory.Policy{Policy: &models.Policy{
+			Limits: []models.Limit{
+				{Name: "above_average", Expression: `load.amount <= 3 * (sum(amount, window="week") / count(window="week"))`},
+			},
+		}},
+		Validator: &validators.LoadValidator{},
+	}
+
+	//The customer's history gives an average to compare against
+	load := &models.Load{TransactionId: 2, CustomerId: 1, Amount: 1000, Time: day}
+	trace, err := e.Evaluate(load)
+	if err != nil {
+		t.Fatalf("Evaluate() unexpected error %v", err)
+	}
+	if !load.Accepted || len(trace[0].Error) > 0 || len(load.Reason) > 0 {
+		t.Errorf("Evaluate() want the load accepted; got %+v, trace %+v", load, trace)
+	}
+
+	//A customer with no history divides by a count of zero, which declines the load rather than failing
+	load = &models.Load{TransactionId: 1, CustomerId: 2, Amount: 1000, Time: day}
+	trace, err = e.Evaluate(load)
+	if err != nil {
+		t.Fatalf("Evaluate() unexpected error %v", err)
+	}
+	if load.Accepted || load.Outcome != models.OutcomeDecline || !strings.Contains(load.Reason, "division by zero") {
+		t.Errorf("Evaluate() want the load declined for the division by zero; got %+v", load)
+	}
+	if trace[0].Passed || !strings.Contains(trace[0].Error, "division by zero") {
+		t.Errorf("Evaluate() want the error on the failed rule; got %+v", trace[0])
+	}
+}
+
+func TestEngine_EvaluateCoolingOff(t *testing.T) {
+	day := time.Date(2000, 1, 4, 12, 0, 0, 0, time.UTC)
+	declined := func(id int64, minutes int, reason string) *models.Load {
+		return &models.Load{TransactionId: id, CustomerId: 1, Amount: 100, Time: day.Add(time.Duration(minutes) * time.Minute), Outcome: models.OutcomeDecline, Reason: reason}
+	}
+	newEngine := func(seed []*models.Load) *Engine {
+		return &Engine{
+			Loads: memory.NewLoad(seed),
+			Policies: &memory.Policy{Policy: &models.Policy{
+				Limits:     []models.Limit{{Name: "daily_count", Window: "day", Metric: "count", Max: 10}},
+				CoolingOff: &models.CoolingOffConfig{Rejections: 3, Window: "1h", Lockout: "2h"},
+			}},
+			Validator: &validators.LoadValidator{},
+		}
+	}
+
+	tests := []struct {
+		name         string
+		seed         []*models.Load
+		minutes      int
+		wantAccepted bool
+	}{
+		{"Under the rejections", []*models.Load{declined(1, 0, ""), declined(2, 10, "")}, 20, true},
+		{"Locked out", []*models.Load{declined(1, 0, ""), declined(2, 10, ""), declined(3, 20, "")}, 30, false},
+		{"Rejections spread over more than the window", []*models.Load{declined(1, 0, ""), declined(2, 40, ""), declined(3, 70, "")}, 80, true},
+		{"Lockout expired", []*models.Load{declined(1, 0, ""), declined(2, 10, ""), declined(3, 20, "")}, 140, true},
+		{"Retries while locked out don't extend it", []*models.Load{declined(1, 0, ""), declined(2, 10, ""), declined(3, 20, ""), declined(4, 130, models.ReasonCoolingOff)}, 141, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			e := newEngine(tt.seed)
+			load := &models.Load{TransactionId: 10, CustomerId: 1, Amount: 100, Time: day.Add(time.Duration(tt.minutes) * time.Minute)}
+			trace, err := e.Evaluate(load)
+			if err != nil {
+				t.Fatalf("Evaluate() unexpected error %v", err)
+			}
+			if load.Accepted != tt.wantAccepted {
+				t.Errorf("Evaluate() accepted %v, want %v; trace %+v", load.Accepted, tt.wantAccepted, trace)
+			}
+			if trace[0].Rule != RuleCoolingOff || trace[0].Passed != tt.wantAccepted {
+				t.Errorf("Evaluate() trace %+v, want the cooling_off rule first", trace[0])
+			}
+			if !tt.wantAccepted && (load.Reason != models.ReasonCoolingOff || len(trace) != 1) {
+				t.Errorf("Evaluate() reason %q with %d rules, want %q on its own", load.Reason, len(trace), models.ReasonCoolingOff)
+			}
+
+			headroom, err := e.Headroom(1, load.Time)
+			if err != nil {
+				t.Fatalf("Headroom() unexpected error %v", err)
+			}
+			if (headroom.CoolingOffUntil != nil) == tt.wantAccepted {
+				t.Errorf("Headroom() cooling off until %v, want locked out %v", headroom.CoolingOffUntil, !tt.wantAccepted)
+			}
+		})
+	}
+}
+
+func TestEngine_Notify(t *testing.T) {
+	monday := time.Date(2000, 1, 3, 9, 0, 0, 0, time.UTC)
+	loads := memory.NewLoad(nil)
+	out := &bytes.Buffer{}
+	e := &Engine{
+		Loads: loads,
+		Policies: &memory.Policy{Policy: &models.Policy{
+			Limits: []models.Limit{
+				{Name: "daily_load_amount", Window: "day", Metric: "sum", Max: 1000},
+				{Name: "weekly_load_amount", Window: "week", Metric: "sum", Max: 2000},
+			},
+		}},
+		Validator:     &validators.LoadValidator{},
+		Notifier:      &notify.Writer{Out: out},
+		Notifications: &mock.Notification{},
+	}
+
+	tests := []struct {
+		name   string
+		amount int64
+		day    int
+		want   []string
+	}{
+		{"Under every threshold", 500, 0, nil},
+		{"Crosses 80% of the day", 300, 0, []string{"daily_load_amount 80"}},
+		{"Between thresholds", 100, 0, nil},
+		{"Reaches the day's max", 100, 0, []string{"daily_load_amount 100"}},
+		{"Over the max is declined", 100, 0, nil},
+		{"New day and 80% of the week", 800, 1, []string{"daily_load_amount 80", "weekly_load_amount 80"}},
+	}
+
+	for i, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			out.Reset()
+			load := &models.Load{TransactionId: int64(i + 1), CustomerId: 1, Amount: tt.amount, Time: monday.AddDate(0, 0, tt.day).Add(time.Duration(i) * time.Minute)}
+			trace, err := e.Evaluate(load)
+			if err != nil {
+				t.Fatalf("Evaluate() unexpected error %v", err)
+			}
+			_, _ = loads.Insert(load)
+
+			//Notifying twice for the same load shouldn't send anything new
+			for j := 0; j < 2; j++ {
+				err = e.Notify(load, trace)
+				if err != nil {
+					t.Fatalf("Notify() unexpected error %v", err)
+				}
+			}
+
+			var got []string
+			decoder := json.NewDecoder(out)
+			for decoder.More() {
+				var notification models.Notification
+				err = decoder.Decode(&notification)
+				if err != nil {
+					t.Fatalf("Unexpected error %v", err)
+				}
+				got = append(got, notification.Limit+" "+strconv.FormatInt(notification.Threshold, 10))
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("Notify() sent %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+//failingNotifier fails every send while fail is set
+type failingNotifier struct {
+	fail bool
+	sent int
+}
+
+func (n *failingNotifier) Notify(notification *models.Notification) error {
+	if n.fail {
+		return errors.New("connection refused")
+	}
+	n.sent++
+	return nil
+}
+
+func TestEngine_NotifyFailedSend(t *testing.T) {
+	monday := time.Date(2000, 1, 3, 9, 0, 0, 0, time.UTC)
+	notifier := &failingNotifier{fail: true}
+	notifications := &mock.Notification{}
+	e := &Engine{
+		Loads: memory.NewLoad(nil),
+		Policies: &memory.Policy{Policy: &models.Policy{
+			Limits: []models.Limit{{Name: "daily_load_amount", Window: "day", Metric: "sum", Max: 1000}},
+		}},
+		Validator:     &validators.LoadValidator{},
+		Notifier:      notifier,
+		Notifications: notifications,
+	}
+
+	load := &models.Load{TransactionId: 1, CustomerId: 1, Amount: 900, Time: monday}
+	trace, err := e.Evaluate(load)
+	if err != nil {
+		t.Fatalf("Evaluate() unexpected error %v", err)
+	}
+	err = e.Notify(load, trace)
+	if err == nil {
+		t.Fatalf("Notify() want the failed send's error")
+	}
+	if len(notifications.Notifications) != 0 {
+		t.Errorf("Notify() kept %d notifications that weren't sent, want 0", len(notifications.Notifications))
+	}
+
+	//The notification wasn't sent, so it can still be
+	notifier.fail = false
+	err = e.Notify(load, trace)
+	if err != nil || notifier.sent != 1 || len(notifications.Notifications) != 1 {
+		t.Errorf("Notify() = %v with %d sent and %d stored, want it sent and stored once", err, notifier.sent, len(notifications.Notifications))
+	}
+}
+
+func TestRecordShadowOutcomes(t *testing.T) {
+	e := &Engine{
+		Loads: memory.NewLoad(nil),
+		Policies: &memory.Policy{Policy: &models.Policy{
+			Limits: []models.Limit{{Name: "shadow_metrics_cap", Metric: "amount", Max: 100, Shadow: true}},
+		}},
+		Validator: &validators.LoadValidator{},
+	}
+	evaluated := func() string {
+		if count := shadowOutcomes.Get("shadow_metrics_cap.evaluated"); count != nil {
+			return count.String()
+		}
+		return "0"
+	}
+
+	//Evaluate is shared with backtests and simulations, so only a stored decision is counted
+	load := &models.Load{TransactionId: 1, CustomerId: 1, Amount: 500, Time: time.Date(2000, 1, 3, 9, 0, 0, 0, time.UTC)}
+	trace, err := e.Evaluate(load)
+	if err != nil {
+		t.Fatalf("Evaluate() unexpected error %v", err)
+	}
+	if got := evaluated(); got != "0" {
+		t.Errorf("Evaluate() counted %s shadow evaluations, want 0", got)
+	}
+
+	RecordShadowOutcomes(trace, load.Accepted)
+	if got := evaluated(); got != "1" {
+		t.Errorf("RecordShadowOutcomes() counted %s shadow evaluations, want 1", got)
+	}
+	if got := shadowOutcomes.Get("shadow_metrics_cap.disagreed"); got == nil || got.String() != "1" {
+		t.Errorf("RecordShadowOutcomes() counted %v disagreements, want 1", got)
+	}
+}
@@ -0,0 +1,27 @@
+package engine
+
+import (
+	"expvar"
+	"fireynis/velocity_checker/pkg/models"
+)
+
+//shadowOutcomes counts, per shadow rule, how often it was evaluated, how often it failed and how often it failed on a
+//load the live rules accepted. Published through expvar so it shows up on /debug/vars.
+var shadowOutcomes = expvar.NewMap("shadow_rules")
+
+//RecordShadowOutcomes counts the shadow rules in a stored decision's trace. It is left to the callers that store
+//decisions rather than called from Evaluate, so backtests, rechecks and simulations don't count towards live traffic.
+func RecordShadowOutcomes(trace []models.RuleTrace, accepted bool) {
+	for _, rule := range trace {
+		if !rule.Shadow {
+			continue
+		}
+		shadowOutcomes.Add(rule.Rule+".evaluated", 1)
+		if !rule.Passed {
+			shadowOutcomes.Add(rule.Rule+".failed", 1)
+			if accepted {
+				shadowOutcomes.Add(rule.Rule+".disagreed", 1)
+			}
+		}
+	}
+}
@@ -0,0 +1,87 @@
+package engine
+
+import (
+	"errors"
+	"fireynis/velocity_checker/pkg/models"
+	"fireynis/velocity_checker/pkg/notify"
+	"fireynis/velocity_checker/pkg/policy"
+	"fmt"
+)
+
+//Notify tells the customer when an accepted load takes them across one of notify.Thresholds on a daily or weekly sum
+//limit. It works off the totals already in the load's trace, the same ones the limits were checked against. With
+//Notifications set each threshold is only sent once per customer, limit and window. Does nothing without a Notifier.
+//
+//The notification is stored before it is sent so that two loads can't both send it, and deleted again when the send
+//fails so that it isn't recorded as sent and notifying the load again sends it.
+func (e *Engine) Notify(load *models.Load, trace []models.RuleTrace) error {
+	if e.Notifier == nil || !load.Accepted || load.Type == models.TypeDebit {
+		return nil
+	}
+
+	activePolicy, err := e.PolicyAt(load.Time)
+	if err != nil {
+		return err
+	}
+	rules := make(map[string]models.RuleTrace)
+	for _, rule := range trace {
+		rules[rule.Rule] = rule
+	}
+
+	for _, limit := range activePolicy.Limits {
+		if !notifiable(limit) {
+			continue
+		}
+		rule, ok := rules[limit.Name]
+		if !ok {
+			continue
+		}
+
+		before := rule.Total - load.Amount
+		for _, threshold := range notify.Thresholds {
+			if before*100 >= limit.Max*threshold || rule.Total*100 < limit.Max*threshold {
+				continue
+			}
+
+			notification := &models.Notification{
+				CustomerId:    load.CustomerId,
+				TransactionId: load.TransactionId,
+				Limit:         limit.Name,
+				Window:        limit.Window,
+				WindowStart:   rule.WindowStart,
+				WindowEnd:     rule.WindowEnd,
+				Threshold:     threshold,
+				Used:          rule.Total,
+				Max:           limit.Max,
+			}
+			if e.Notifications != nil {
+				err = e.Notifications.Insert(notification)
+				if err != nil {
+					return err
+				}
+				//Already sent for this window
+				if notification.Id == 0 {
+					continue
+				}
+			}
+
+			err = e.Notifier.Notify(notification)
+			if err != nil {
+				if e.Notifications != nil {
+					deleteErr := e.Notifications.Delete(notification.Id)
+					if deleteErr != nil {
+						return errors.New(fmt.Sprintf("%s. Unable to delete the unsent notification. %s", err, deleteErr))
+					}
+				}
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+//notifiable limits are the customer's own daily and weekly allowances
+func notifiable(limit models.Limit) bool {
+	return limit.Metric == policy.MetricSum && (limit.Window == policy.WindowDay || limit.Window == policy.WindowWeek) &&
+		limit.Max > 0 && !limit.Shadow && limit.Scope != policy.ScopeLinked && len(limit.Where) == 0 && len(limit.GroupBy) == 0
+}
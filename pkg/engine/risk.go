@@ -0,0 +1,102 @@
+package engine
+
+import (
+	"fireynis/velocity_checker/pkg/detect"
+	"fireynis/velocity_checker/pkg/models"
+	"fireynis/velocity_checker/pkg/policy"
+	"math"
+	"sort"
+)
+
+//RuleRiskScore is the name of the trace entry explaining the risk score
+const RuleRiskScore = "risk_score"
+
+//score weighs each signal into the load's risk score and picks the outcome. A load that already failed a limit is
+//declined whatever its score. The returned trace entry holds each signal scaled to 0-100 so the score can be explained.
+func (e *Engine) score(activePolicy *models.Policy, source *windowSource, trace []models.RuleTrace) (models.RuleTrace, error) {
+	risk := activePolicy.Risk
+	signals := make(map[string]int64)
+	weighted := float64(0)
+	totalWeight := float64(0)
+
+	names := make([]string, 0, len(risk.Weights))
+	for name := range risk.Weights {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	for _, name := range names {
+		value, err := e.signal(name, activePolicy, source, trace)
+		if err != nil {
+			return models.RuleTrace{}, err
+		}
+		signals[name] = int64(math.Round(value * 100))
+		weighted += risk.Weights[name] * value
+		totalWeight += risk.Weights[name]
+	}
+
+	score := int64(math.Round(weighted / totalWeight * 100))
+	load := source.load
+	load.RiskScore = score
+	switch {
+	case !load.Accepted || score >= risk.DeclineAt:
+		load.Outcome = models.OutcomeDecline
+	case score >= risk.ReviewAt:
+		load.Outcome = models.OutcomeReview
+	default:
+		load.Outcome = models.OutcomeApprove
+	}
+	load.Accepted = load.Outcome == models.OutcomeApprove
+
+	return models.RuleTrace{
+		Rule:      RuleRiskScore,
+		Total:     score,
+		Threshold: risk.DeclineAt,
+		Passed:    load.Outcome != models.OutcomeDecline,
+		Values:    signals,
+	}, nil
+}
+
+//signal scores a single risk signal between 0 and 1
+func (e *Engine) signal(name string, activePolicy *models.Policy, source *windowSource, trace []models.RuleTrace) (float64, error) {
+	switch name {
+	case policy.SignalLimitProximity:
+		proximity := float64(0)
+		for _, rule := range trace {
+			if rule.Shadow || len(rule.Expression) > 0 || rule.Threshold <= 0 {
+				continue
+			}
+			proximity = math.Max(proximity, float64(rule.Total)/float64(rule.Threshold))
+		}
+		return math.Min(proximity, 1), nil
+	case policy.SignalAnomaly:
+		config := policy.AnomalyFor(activePolicy)
+		loads, err := source.LoadsInWindow(config.Window)
+		if err != nil {
+			return 0, err
+		}
+		result, tier := detect.Anomaly(config, loads, source.load)
+		return math.Min(result.Severity(tier), 1), nil
+	case policy.SignalVelocitySpike:
+		loads, err := source.LoadsInWindow("1h")
+		if err != nil {
+			return 0, err
+		}
+		return math.Min(float64(len(loads))/3, 1), nil
+	case policy.SignalNewCustomer:
+		loads, err := source.LoadsInWindow("2160h")
+		if err != nil {
+			return 0, err
+		}
+		if len(loads) == 0 {
+			return 1, nil
+		}
+		return 0, nil
+	case policy.SignalUnusualHour:
+		if source.load.Time.UTC().Hour() < 6 {
+			return 1, nil
+		}
+		return 0, nil
+	}
+	return 0, nil
+}
@@ -0,0 +1,25 @@
+package helpers
+
+import (
+	"errors"
+	"fireynis/velocity_checker/pkg/policy"
+	"fmt"
+	"time"
+)
+
+//ParseDateRange turns the from and to days into the start of the first and the end of the last
+func ParseDateRange(from string, to string) (time.Time, time.Time, error) {
+	startDate, err := time.Parse("2006-01-02", from)
+	if err != nil {
+		return time.Time{}, time.Time{}, errors.New(fmt.Sprintf("a from date of the form YYYY-MM-DD is required. %s", err))
+	}
+	endDate, err := time.Parse("2006-01-02", to)
+	if err != nil {
+		return time.Time{}, time.Time{}, errors.New(fmt.Sprintf("a to date of the form YYYY-MM-DD is required. %s", err))
+	}
+	if endDate.Before(startDate) {
+		return time.Time{}, time.Time{}, errors.New("the to date can't be before the from date")
+	}
+	_, endDate = policy.DayWindow(endDate)
+	return startDate, endDate, nil
+}
@@ -0,0 +1,55 @@
+package helpers
+
+import (
+	"errors"
+	"fireynis/velocity_checker/pkg/currency"
+	"fmt"
+)
+
+//The codes an InputError can carry. They are stable so callers can match on them, unlike the messages.
+const (
+	CodeInvalidId          = "invalid_id"
+	CodeInvalidCustomerId  = "invalid_customer_id"
+	CodeUnknownType        = "unknown_type"
+	CodeUnknownCurrency    = "unknown_currency"
+	CodeInvalidAmount      = "invalid_amount"
+	CodeNegativeAmount     = "negative_amount"
+	CodeTooManyDecimals    = "too_many_decimals"
+	CodeAmountOutOfRange   = "amount_out_of_range"
+	CodeUnknownFundingType = "unknown_funding_type"
+	CodeUnknownChannel     = "unknown_channel"
+	CodeInvalidIpAddress   = "invalid_ip_address"
+	CodeMissingTime        = "missing_time"
+	CodeTimeInFuture       = "time_in_future"
+	CodeTimeTooOld         = "time_too_old"
+)
+
+//InputError rejects a load as bad input before it reaches the engine
+type InputError struct {
+	Code    string
+	Message string
+}
+
+func (e *InputError) Error() string {
+	return e.Code + ": " + e.Message
+}
+
+func inputError(code string, format string, args ...interface{}) *InputError {
+	return &InputError{Code: code, Message: fmt.Sprintf(format, args...)}
+}
+
+//amountError picks the code for an error from currency.ParseAmount
+func amountError(err error) *InputError {
+	code := CodeInvalidAmount
+	switch {
+	case errors.Is(err, currency.ErrUnknownCurrency):
+		code = CodeUnknownCurrency
+	case errors.Is(err, currency.ErrNegativeAmount):
+		code = CodeNegativeAmount
+	case errors.Is(err, currency.ErrTooManyDecimals):
+		code = CodeTooManyDecimals
+	case errors.Is(err, currency.ErrAmountTooLarge):
+		code = CodeAmountOutOfRange
+	}
+	return &InputError{Code: code, Message: err.Error()}
+}
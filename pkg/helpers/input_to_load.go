@@ -1,31 +1,76 @@
 package helpers
 
 import (
-	"errors"
+	"fireynis/velocity_checker/pkg/currency"
 	"fireynis/velocity_checker/pkg/models"
 	"fmt"
+	"net"
 	"strconv"
 	"strings"
 	"time"
 )
 
+//InputToLoad checks the input and turns it into a load. Every error it returns is an *InputError.
 func InputToLoad(input ImportLoad) (load models.Load, err error) {
 	load.TransactionId, err = strconv.ParseInt(input.TransactionId, 10, 64)
 	if err != nil {
-		return models.Load{}, errors.New(fmt.Sprintf("unable to parse json. %s", err))
+		return models.Load{}, inputError(CodeInvalidId, "id %q isn't a whole number", input.TransactionId)
 	}
 
 	load.CustomerId, err = strconv.ParseInt(input.CustomerId, 10, 64)
 	if err != nil {
-		return models.Load{}, errors.New(fmt.Sprintf("unable to parse json. %s", err))
+		return models.Load{}, inputError(CodeInvalidCustomerId, "customer_id %q isn't a whole number", input.CustomerId)
 	}
 
-	cleanedAmount, err := strconv.ParseFloat(strings.ReplaceAll(input.Amount, "$", ""), 64)
+	load.Type = strings.ToLower(strings.TrimSpace(input.Type))
+	switch load.Type {
+	case "":
+		load.Type = models.TypeLoad
+	case models.TypeLoad, models.TypeDebit:
+	default:
+		return models.Load{}, inputError(CodeUnknownType, "unknown type %q", input.Type)
+	}
+
+	load.Currency = strings.ToUpper(strings.TrimSpace(input.Currency))
+	if len(load.Currency) < 1 {
+		load.Currency = currency.Default
+	}
+	load.OriginalAmount, err = currency.ParseAmount(strings.TrimSpace(strings.ReplaceAll(input.Amount, "$", "")), load.Currency)
 	if err != nil {
-		return models.Load{}, errors.New(fmt.Sprintf("Unable to parse json.  %s", err))
+		return models.Load{}, amountError(err)
+	}
+	if load.Type == models.TypeDebit && load.OriginalAmount == 0 {
+		return models.Load{}, inputError(CodeInvalidAmount, "a debit needs a positive load_amount")
+	}
+	//Until the load is converted to the base currency its amount is the original
+	load.Amount = load.OriginalAmount
+	if input.Time.IsZero() {
+		return models.Load{}, inputError(CodeMissingTime, "a time is required")
 	}
-	load.Amount = int64(cleanedAmount * 100)
 	load.Time = input.Time
+	load.DeviceId = input.DeviceId
+	load.Address = input.Address
+	load.FundingSource = input.FundingSource
+
+	load.FundingType = strings.ToLower(input.FundingType)
+	if len(load.FundingType) > 0 && load.FundingType != models.FundingCard && load.FundingType != models.FundingBankAccount {
+		return models.Load{}, inputError(CodeUnknownFundingType, "unknown funding_type %q", input.FundingType)
+	}
+	load.Channel = strings.ToLower(input.Channel)
+	switch load.Channel {
+	case "", models.ChannelMobile, models.ChannelWeb, models.ChannelBranch, models.ChannelApi:
+	default:
+		return models.Load{}, inputError(CodeUnknownChannel, "unknown channel %q", input.Channel)
+	}
+	if len(input.IpAddress) > 0 {
+		ip := net.ParseIP(input.IpAddress)
+		if ip == nil {
+			return models.Load{}, inputError(CodeInvalidIpAddress, "invalid ip_address %q", input.IpAddress)
+		}
+		load.IpAddress = ip.String()
+	}
+	load.MerchantId = input.MerchantId
+	load.Metadata = input.Metadata
 	return load, nil
 }
 
@@ -34,4 +79,27 @@ type ImportLoad struct {
 	CustomerId    string    `json:"customer_id"`
 	Amount        string    `json:"load_amount"`
 	Time          time.Time `json:"time"`
+	//Type is "load", the default, or "debit" to take the amount out of the customer's balance
+	Type string `json:"type,omitempty"`
+	//Currency is the ISO 4217 code of the amount, dollars when it is left out
+	Currency string `json:"currency,omitempty"`
+	//The rest are optional. The device, address and funding source link customers that share them.
+	DeviceId      string            `json:"device_id,omitempty"`
+	Address       string            `json:"address,omitempty"`
+	FundingSource string            `json:"funding_source,omitempty"`
+	FundingType   string            `json:"funding_type,omitempty"`
+	Channel       string            `json:"channel,omitempty"`
+	MerchantId    string            `json:"merchant_id,omitempty"`
+	IpAddress     string            `json:"ip_address,omitempty"`
+	Metadata      map[string]string `json:"metadata,omitempty"`
+}
+
+//FormatAmount turns an amount in cents back into the dollar form loads come in with
+func FormatAmount(cents int64) string {
+	sign := ""
+	if cents < 0 {
+		sign = "-"
+		cents = -cents
+	}
+	return fmt.Sprintf("%s$%d.%02d", sign, cents/100, cents%100)
 }
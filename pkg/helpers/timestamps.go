@@ -0,0 +1,76 @@
+package helpers
+
+import (
+	"errors"
+	"fireynis/velocity_checker/pkg/models"
+	"fmt"
+	"time"
+)
+
+//The clocks a load can be evaluated on
+const (
+	//EvaluateOnClient uses the time the load arrives with
+	EvaluateOnClient = "client"
+	//EvaluateOnReceived uses the time the server received the load, so a client's clock can't move it between windows
+	EvaluateOnReceived = "received"
+)
+
+//Timestamps checks the time a load arrives with against the server's clock and picks the time it is evaluated on.
+//A zero MaxFutureSkew or MaxBackdate leaves that side unbounded.
+type Timestamps struct {
+	//MaxFutureSkew is how far ahead of the server a load's time can be, MaxBackdate how far behind
+	MaxFutureSkew time.Duration
+	MaxBackdate   time.Duration
+	EvaluateOn    string
+	//Now is the server's clock, time.Now when nil
+	Now func() time.Time
+}
+
+//NewTimestamps parses the bounds as durations. Blank settings leave the bound off and evaluate on the client's time.
+func NewTimestamps(maxFutureSkew string, maxBackdate string, evaluateOn string) (*Timestamps, error) {
+	timestamps := &Timestamps{EvaluateOn: EvaluateOnClient}
+	var err error
+	if len(maxFutureSkew) > 0 {
+		timestamps.MaxFutureSkew, err = time.ParseDuration(maxFutureSkew)
+		if err != nil || timestamps.MaxFutureSkew < 0 {
+			return nil, errors.New(fmt.Sprintf("invalid max future skew %q", maxFutureSkew))
+		}
+	}
+	if len(maxBackdate) > 0 {
+		timestamps.MaxBackdate, err = time.ParseDuration(maxBackdate)
+		if err != nil || timestamps.MaxBackdate < 0 {
+			return nil, errors.New(fmt.Sprintf("invalid max backdate %q", maxBackdate))
+		}
+	}
+	switch evaluateOn {
+	case "":
+	case EvaluateOnClient, EvaluateOnReceived:
+		timestamps.EvaluateOn = evaluateOn
+	default:
+		return nil, errors.New(fmt.Sprintf("unknown clock %q to evaluate on, want %s or %s", evaluateOn, EvaluateOnClient, EvaluateOnReceived))
+	}
+	return timestamps, nil
+}
+
+//Apply stamps the load with the time it was received, keeps the time it arrived with as its ClientTime and rejects it
+//with an *InputError when that is outside the bounds. The load's Time becomes the time it is evaluated on.
+func (t *Timestamps) Apply(load *models.Load) error {
+	now := time.Now()
+	if t.Now != nil {
+		now = t.Now()
+	}
+	load.ReceivedAt = now.UTC()
+	load.ClientTime = load.Time
+
+	if t.MaxFutureSkew > 0 && load.ClientTime.After(now.Add(t.MaxFutureSkew)) {
+		return inputError(CodeTimeInFuture, "time %s is more than %s ahead of the server", load.ClientTime.Format(time.RFC3339), t.MaxFutureSkew)
+	}
+	if t.MaxBackdate > 0 && load.ClientTime.Before(now.Add(-t.MaxBackdate)) {
+		return inputError(CodeTimeTooOld, "time %s is more than %s behind the server", load.ClientTime.Format(time.RFC3339), t.MaxBackdate)
+	}
+
+	if t.EvaluateOn == EvaluateOnReceived {
+		load.Time = load.ReceivedAt
+	}
+	return nil
+}
@@ -0,0 +1,75 @@
+package helpers
+
+import (
+	"errors"
+	"fireynis/velocity_checker/pkg/models"
+	"testing"
+	"time"
+)
+
+func TestTimestamps_Apply(t *testing.T) {
+	now := time.Date(2021, 3, 1, 12, 0, 0, 0, time.UTC)
+	clock := func() time.Time { return now }
+
+	tests := []struct {
+		name       string
+		timestamps *Timestamps
+		time       time.Time
+		wantCode   string
+		wantTime   time.Time
+	}{
+		{"Within the bounds", &Timestamps{MaxFutureSkew: 5 * time.Minute, MaxBackdate: 72 * time.Hour, EvaluateOn: EvaluateOnClient, Now: clock}, now.Add(-time.Hour), "", now.Add(-time.Hour)},
+		{"Slightly ahead", &Timestamps{MaxFutureSkew: 5 * time.Minute, EvaluateOn: EvaluateOnClient, Now: clock}, now.Add(4 * time.Minute), "", now.Add(4 * time.Minute)},
+		{"Next year", &Timestamps{MaxFutureSkew: 5 * time.Minute, EvaluateOn: EvaluateOnClient, Now: clock}, now.AddDate(1, 0, 0), CodeTimeInFuture, time.Time{}},
+		{"1970", &Timestamps{MaxBackdate: 72 * time.Hour, EvaluateOn: EvaluateOnClient, Now: clock}, time.Unix(0, 0).UTC(), CodeTimeTooOld, time.Time{}},
+		{"Unbounded", &Timestamps{EvaluateOn: EvaluateOnClient, Now: clock}, time.Unix(0, 0).UTC(), "", time.Unix(0, 0).UTC()},
+		{"Evaluated on the server's time", &Timestamps{MaxBackdate: 72 * time.Hour, EvaluateOn: EvaluateOnReceived, Now: clock}, now.Add(-time.Hour), "", now},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			load := &models.Load{Time: tt.time}
+			err := tt.timestamps.Apply(load)
+
+			var inputErr *InputError
+			if len(tt.wantCode) > 0 {
+				if !errors.As(err, &inputErr) || inputErr.Code != tt.wantCode {
+					t.Fatalf("Apply() error = %v, want code %s", err, tt.wantCode)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("Apply() unexpected error %v", err)
+			}
+			if !load.Time.Equal(tt.wantTime) || !load.ClientTime.Equal(tt.time) || !load.ReceivedAt.Equal(now) {
+				t.Errorf("Apply() left time %s client %s received %s", load.Time, load.ClientTime, load.ReceivedAt)
+			}
+		})
+	}
+}
+
+func TestNewTimestamps(t *testing.T) {
+	tests := []struct {
+		name          string
+		maxFutureSkew string
+		maxBackdate   string
+		evaluateOn    string
+		wantErr       bool
+	}{
+		{"Blank", "", "", "", false},
+		{"Bounded", "5m", "72h", "received", false},
+		{"Off", "0", "0", "client", false},
+		{"Bad duration", "five minutes", "", "", true},
+		{"Negative", "", "-1h", "", true},
+		{"Unknown clock", "", "", "sundial", true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := NewTimestamps(tt.maxFutureSkew, tt.maxBackdate, tt.evaluateOn)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("NewTimestamps() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
@@ -0,0 +1,105 @@
+package linkage
+
+import (
+	"fireynis/velocity_checker/pkg/models"
+	"sort"
+	"strings"
+)
+
+const (
+	KindDevice        = "device"
+	KindAddress       = "address"
+	KindFundingSource = "funding_source"
+
+	//MaxGroupSize stops a widely shared value, such as a shop's address, pulling most customers into one group
+	MaxGroupSize = 50
+)
+
+//Keys returns the links the load carries. Values are normalised so the same address typed twice still links.
+func Keys(load *models.Load) []*models.Link {
+	values := []struct {
+		kind  string
+		value string
+	}{
+		{KindDevice, load.DeviceId},
+		{KindAddress, load.Address},
+		{KindFundingSource, load.FundingSource},
+	}
+
+	var links []*models.Link
+	for _, v := range values {
+		normalised := strings.ToLower(strings.Join(strings.Fields(v.value), " "))
+		if len(normalised) < 1 {
+			continue
+		}
+		links = append(links, &models.Link{Kind: v.kind, Value: normalised, CustomerId: load.CustomerId, FirstSeen: load.Time})
+	}
+	return links
+}
+
+//Record stores the load's links so later loads by other customers sharing them are grouped with this customer
+func Record(store models.ILinks, load *models.Load) error {
+	for _, link := range Keys(load) {
+		err := store.Insert(link)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+//Group finds the customers linked to the load's customer, directly or through other customers, by anything the load
+//carries or the customer has used before. The customer is always in the group, which is sorted and holds at most
+//MaxGroupSize customers.
+func Group(store models.ILinks, load *models.Load) ([]int64, error) {
+	group := map[int64]bool{load.CustomerId: true}
+	seen := make(map[string]bool)
+	pending := Keys(load)
+	queue := []int64{load.CustomerId}
+
+	for len(queue) > 0 || len(pending) > 0 {
+		if len(pending) == 0 {
+			customerId := queue[0]
+			queue = queue[1:]
+			links, err := store.GetByCustomerId(customerId)
+			if err != nil {
+				return nil, err
+			}
+			pending = append(pending, links...)
+			continue
+		}
+
+		link := pending[0]
+		pending = pending[1:]
+		key := link.Kind + ":" + link.Value
+		if seen[key] {
+			continue
+		}
+		seen[key] = true
+
+		customerIds, err := store.GetCustomerIds(link.Kind, link.Value)
+		if err != nil {
+			return nil, err
+		}
+		for _, customerId := range customerIds {
+			if group[customerId] {
+				continue
+			}
+			if len(group) >= MaxGroupSize {
+				return sorted(group), nil
+			}
+			group[customerId] = true
+			queue = append(queue, customerId)
+		}
+	}
+	return sorted(group), nil
+}
+
+func sorted(group map[int64]bool) []int64 {
+	customerIds := make([]int64, 0, len(group))
+	for customerId := range group {
+		customerIds = append(customerIds, customerId)
+	}
+	sort.Slice(customerIds, func(i, j int) bool { return customerIds[i] < customerIds[j] })
+	return customerIds
+}
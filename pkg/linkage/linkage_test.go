@@ -0,0 +1,49 @@
+package linkage
+
+import (
+	"fireynis/velocity_checker/pkg/models"
+	"fireynis/velocity_checker/pkg/models/mock"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestGroup(t *testing.T) {
+	day := time.Date(2000, 1, 1, 0, 0, 0, 0, time.UTC)
+	store := &mock.Link{}
+	seen := []*models.Load{
+		{CustomerId: 1, Time: day, DeviceId: "phone-a", Address: "1 Main St"},
+		{CustomerId: 2, Time: day, DeviceId: "phone-a"},
+		{CustomerId: 3, Time: day, Address: "  1 MAIN  st ", FundingSource: "card-9"},
+		{CustomerId: 4, Time: day, FundingSource: "card-9"},
+		{CustomerId: 5, Time: day, DeviceId: "phone-b"},
+	}
+	for _, load := range seen {
+		err := Record(store, load)
+		if err != nil {
+			t.Fatalf("Record() unexpected error %v", err)
+		}
+	}
+
+	tests := []struct {
+		name string
+		load *models.Load
+		want []int64
+	}{
+		{"Linked through other customers", &models.Load{CustomerId: 2}, []int64{1, 2, 3, 4}},
+		{"Linked by the load itself", &models.Load{CustomerId: 6, DeviceId: "phone-b"}, []int64{5, 6}},
+		{"Nothing shared", &models.Load{CustomerId: 7, DeviceId: "phone-c"}, []int64{7}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := Group(store, tt.load)
+			if err != nil {
+				t.Fatalf("Group() unexpected error %v", err)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("Group() got %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
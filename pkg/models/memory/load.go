@@ -0,0 +1,153 @@
+package memory
+
+import (
+	"fireynis/velocity_checker/pkg/models"
+	"sort"
+	"sync"
+	"time"
+)
+
+//Load is an ILoads kept entirely in memory. It is used to replay history without touching the database.
+type Load struct {
+	mu     sync.Mutex
+	loads  []*models.Load
+	nextId int64
+}
+
+//NewLoad creates a store holding copies of the seed loads
+func NewLoad(seed []*models.Load) *Load {
+	m := &Load{}
+	for _, load := range seed {
+		copied := *load
+		m.loads = append(m.loads, &copied)
+		if copied.Id > m.nextId {
+			m.nextId = copied.Id
+		}
+	}
+	return m
+}
+
+//Get and GetByTransactionId hand out copies, as a database would, so changing a load doesn't change the stored one
+//until it is updated
+func (m *Load) Get(id int64) (*models.Load, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	for _, load := range m.loads {
+		if load.Id == id {
+			copied := *load
+			return &copied, nil
+		}
+	}
+	return nil, models.ErrNoRecord
+}
+
+func (m *Load) GetByTransactionId(customerId int64, transactionId int64) (*models.Load, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	for _, load := range m.loads {
+		if load.CustomerId == customerId && load.TransactionId == transactionId {
+			copied := *load
+			return &copied, nil
+		}
+	}
+	return nil, models.ErrNoRecord
+}
+
+func (m *Load) GetByCustomerTransactionsByDateRange(customerId int64, startDate time.Time, endDate time.Time) ([]*models.Load, error) {
+	return m.filter(func(load *models.Load) bool {
+		return load.CustomerId == customerId && !load.Time.Before(startDate) && !load.Time.After(endDate)
+	}), nil
+}
+
+func (m *Load) GetByCustomersByDateRange(customerIds []int64, startDate time.Time, endDate time.Time) ([]*models.Load, error) {
+	wanted := make(map[int64]bool)
+	for _, customerId := range customerIds {
+		wanted[customerId] = true
+	}
+	return m.filter(func(load *models.Load) bool {
+		return wanted[load.CustomerId] && !load.Time.Before(startDate) && !load.Time.After(endDate)
+	}), nil
+}
+
+func (m *Load) GetByDateRange(startDate time.Time, endDate time.Time) ([]*models.Load, error) {
+	return m.filter(func(load *models.Load) bool {
+		return !load.Time.Before(startDate) && !load.Time.After(endDate)
+	}), nil
+}
+
+func (m *Load) GetByStatus(status string) ([]*models.Load, error) {
+	return m.filter(func(load *models.Load) bool {
+		return load.Status == status
+	}), nil
+}
+
+//Insert stores the load, giving it the next id if it doesn't already have one. A transaction id the customer has
+//already used is refused with models.ErrDuplicate, the same as the table's unique index.
+func (m *Load) Insert(load *models.Load) (int64, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	for _, existing := range m.loads {
+		if existing.CustomerId == load.CustomerId && existing.TransactionId == load.TransactionId {
+			return 0, models.ErrDuplicate
+		}
+	}
+	if load.Id == 0 {
+		m.nextId++
+		load.Id = m.nextId
+	} else if load.Id > m.nextId {
+		m.nextId = load.Id
+	}
+	m.loads = append(m.loads, load)
+	return load.Id, nil
+}
+
+func (m *Load) Update(model *models.Load) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	for i, load := range m.loads {
+		if load.Id == model.Id {
+			copied := *model
+			m.loads[i] = &copied
+			return nil
+		}
+	}
+	return models.ErrNoRecord
+}
+
+//Claim assigns a load pending review to the analyst, the same as the postgres model
+func (m *Load) Claim(id int64, analyst string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	for _, load := range m.loads {
+		if load.Id == id {
+			if load.Status != models.StatusPendingReview || (len(load.Analyst) > 0 && load.Analyst != analyst) {
+				return models.ErrChanged
+			}
+			load.Analyst = analyst
+			return nil
+		}
+	}
+	return models.ErrNoRecord
+}
+
+//filter returns the matching loads oldest first
+func (m *Load) filter(match func(load *models.Load) bool) []*models.Load {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	matched := make([]*models.Load, 0)
+	for _, load := range m.loads {
+		if match(load) {
+			matched = append(matched, load)
+		}
+	}
+	sort.SliceStable(matched, func(i, j int) bool {
+		return matched[i].Time.Before(matched[j].Time)
+	})
+	return matched
+}
@@ -0,0 +1,28 @@
+package memory
+
+import (
+	"fireynis/velocity_checker/pkg/models"
+	"time"
+)
+
+//Policy is an IPolicies holding a single policy that is effective at all times. It is used to evaluate a candidate
+//policy that hasn't been published.
+type Policy struct {
+	Policy *models.Policy
+}
+
+func (m *Policy) Get(version int64) (*models.Policy, error) {
+	if m.Policy.Version != version {
+		return nil, models.ErrNoRecord
+	}
+	return m.Policy, nil
+}
+
+func (m *Policy) GetEffective(at time.Time) (*models.Policy, error) {
+	return m.Policy, nil
+}
+
+func (m *Policy) Insert(policy *models.Policy) (int64, error) {
+	m.Policy = policy
+	return policy.Version, nil
+}
@@ -0,0 +1,33 @@
+package mock
+
+import (
+	"fireynis/velocity_checker/pkg/models"
+	"time"
+)
+
+//Alert keeps alerts in memory, ignoring repeats the same way the table's unique constraint does
+type Alert struct {
+	Alerts []*models.Alert
+}
+
+func (m *Alert) GetByDateRange(startDate time.Time, endDate time.Time) ([]*models.Alert, error) {
+	alerts := make([]*models.Alert, 0)
+	for _, alert := range m.Alerts {
+		if !alert.WindowEnd.Before(startDate) && !alert.WindowEnd.After(endDate) {
+			alerts = append(alerts, alert)
+		}
+	}
+	return alerts, nil
+}
+
+func (m *Alert) Insert(alert *models.Alert) error {
+	for _, existing := range m.Alerts {
+		if existing.CustomerId == alert.CustomerId && existing.Pattern == alert.Pattern && existing.WindowEnd.Equal(alert.WindowEnd) {
+			return nil
+		}
+	}
+	alert.Id = int64(len(m.Alerts) + 1)
+	alert.CreatedAt = time.Now()
+	m.Alerts = append(m.Alerts, alert)
+	return nil
+}
@@ -0,0 +1,31 @@
+package mock
+
+import (
+	"fireynis/velocity_checker/pkg/audit"
+	"fireynis/velocity_checker/pkg/models"
+)
+
+//Audit keeps the chain in memory so tests can append to it and tamper with it
+type Audit struct {
+	Entries []*models.AuditEntry
+}
+
+func (m *Audit) Append(entry *models.AuditEntry) error {
+	if len(m.Entries) > 0 {
+		entry.PrevHash = m.Entries[len(m.Entries)-1].Hash
+	}
+	entry.Hash = audit.Hash(entry)
+	entry.Id = int64(len(m.Entries) + 1)
+	m.Entries = append(m.Entries, entry)
+	return nil
+}
+
+func (m *Audit) Walk(fn func(entry *models.AuditEntry) error) error {
+	for _, entry := range m.Entries {
+		err := fn(entry)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
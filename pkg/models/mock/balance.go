@@ -0,0 +1,18 @@
+package mock
+
+//Balance keeps balances in memory by customer id
+type Balance struct {
+	Balances map[int64]int64
+}
+
+func (m *Balance) Get(customerId int64) (int64, error) {
+	return m.Balances[customerId], nil
+}
+
+func (m *Balance) Adjust(customerId int64, delta int64) (int64, error) {
+	if m.Balances == nil {
+		m.Balances = make(map[int64]int64)
+	}
+	m.Balances[customerId] += delta
+	return m.Balances[customerId], nil
+}
@@ -0,0 +1,34 @@
+package mock
+
+import (
+	"fireynis/velocity_checker/pkg/models"
+	"time"
+)
+
+//Correction keeps corrections in memory, ignoring repeats the same way the table's unique constraint does
+type Correction struct {
+	Corrections []*models.Correction
+}
+
+func (m *Correction) GetByDateRange(startDate time.Time, endDate time.Time) ([]*models.Correction, error) {
+	corrections := make([]*models.Correction, 0)
+	for _, correction := range m.Corrections {
+		if !correction.CreatedAt.Before(startDate) && !correction.CreatedAt.After(endDate) {
+			corrections = append(corrections, correction)
+		}
+	}
+	return corrections, nil
+}
+
+func (m *Correction) Insert(correction *models.Correction) error {
+	for _, existing := range m.Corrections {
+		if existing.CustomerId == correction.CustomerId && existing.TransactionId == correction.TransactionId &&
+			existing.LateCustomerId == correction.LateCustomerId && existing.LateTransactionId == correction.LateTransactionId {
+			return nil
+		}
+	}
+	correction.Id = int64(len(m.Corrections) + 1)
+	correction.CreatedAt = time.Now()
+	m.Corrections = append(m.Corrections, correction)
+	return nil
+}
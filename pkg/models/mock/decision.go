@@ -0,0 +1,135 @@
+package mock
+
+import (
+	"fireynis/velocity_checker/pkg/models"
+	"sync"
+)
+
+//Decision inserts loads into Loads, entries into AuditLog, events into Outbox and deliveries into Deliveries, and
+//settles accepted loads on Balances, one after another. Set Fail to have Insert and Update fail before anything is
+//stored, as a rolled back transaction would.
+type Decision struct {
+	Loads    models.ILoads
+	AuditLog models.IAudit
+	Balances models.IBalances
+	Outbox   *Outbox
+	//Deliveries can be left nil when no test looks at them
+	Deliveries models.IDeliveries
+	Fail       error
+	mu         sync.Mutex
+}
+
+func (m *Decision) Insert(load *models.Load, entry *models.AuditEntry, event *models.OutboxEvent, deliveries []*models.Delivery) (int64, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if m.Fail != nil {
+		return 0, m.Fail
+	}
+	err := m.checkBounds(load)
+	if err != nil {
+		return 0, err
+	}
+	id, err := m.Loads.Insert(load)
+	if err != nil {
+		return 0, err
+	}
+	err = m.settle(load, false)
+	if err != nil {
+		return 0, err
+	}
+	if event != nil && m.Outbox != nil {
+		m.Outbox.Insert(event)
+	}
+	err = m.insertDeliveries(deliveries)
+	if err != nil {
+		return 0, err
+	}
+	entry.LoadId = id
+	return id, m.AuditLog.Append(entry)
+}
+
+func (m *Decision) Update(previous *models.Load, load *models.Load, entry *models.AuditEntry, event *models.OutboxEvent, deliveries []*models.Delivery) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if m.Fail != nil {
+		return m.Fail
+	}
+	current, err := m.Loads.GetByTransactionId(previous.CustomerId, previous.TransactionId)
+	if err != nil {
+		return err
+	}
+	if current.Accepted != previous.Accepted || current.Status != previous.Status || current.Analyst != previous.Analyst {
+		return models.ErrChanged
+	}
+
+	err = m.Loads.Update(load)
+	if err != nil {
+		return err
+	}
+	err = m.settle(load, previous.Accepted)
+	if err != nil {
+		return err
+	}
+	if event != nil && m.Outbox != nil {
+		m.Outbox.Insert(event)
+	}
+	err = m.insertDeliveries(deliveries)
+	if err != nil {
+		return err
+	}
+	entry.LoadId = load.Id
+	return m.AuditLog.Append(entry)
+}
+
+//settle is the same move as balance.Settle, which can't be imported here as its tests use this package
+func (m *Decision) settle(load *models.Load, wasAccepted bool) error {
+	if m.Balances == nil || load.Accepted == wasAccepted {
+		return nil
+	}
+	move := delta(load)
+	if !load.Accepted {
+		move = -move
+	}
+	_, err := m.Balances.Adjust(load.CustomerId, move)
+	return err
+}
+
+//checkBounds fails with models.ErrBalanceChanged when the accepted load would leave the balance outside its
+//BalanceMin or BalanceMax
+func (m *Decision) checkBounds(load *models.Load) error {
+	if m.Balances == nil || !load.Accepted {
+		return nil
+	}
+	current, err := m.Balances.Get(load.CustomerId)
+	if err != nil {
+		return err
+	}
+	after := current + delta(load)
+	if (load.BalanceMin != nil && after < *load.BalanceMin) || (load.BalanceMax != nil && after > *load.BalanceMax) {
+		return models.ErrBalanceChanged
+	}
+	return nil
+}
+
+//delta is balance.Delta
+func delta(load *models.Load) int64 {
+	if load.Type == models.TypeDebit {
+		return -load.Amount
+	}
+	return load.Amount
+}
+
+func (m *Decision) insertDeliveries(deliveries []*models.Delivery) error {
+	if m.Deliveries == nil {
+		return nil
+	}
+	for _, delivery := range deliveries {
+		err := m.Deliveries.Insert(delivery)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
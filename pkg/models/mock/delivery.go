@@ -0,0 +1,107 @@
+package mock
+
+import (
+	"fireynis/velocity_checker/pkg/models"
+	"sync"
+	"time"
+)
+
+//Delivery keeps webhook deliveries in memory
+type Delivery struct {
+	Deliveries []*models.Delivery
+	mu         sync.Mutex
+}
+
+func (m *Delivery) Get(id int64) (*models.Delivery, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	for _, delivery := range m.Deliveries {
+		if delivery.Id == id {
+			found := *delivery
+			return &found, nil
+		}
+	}
+	return nil, models.ErrNoRecord
+}
+
+func (m *Delivery) GetByStatus(status string) ([]*models.Delivery, error) {
+	return m.filter(func(delivery *models.Delivery) bool {
+		return delivery.Status == status
+	}), nil
+}
+
+func (m *Delivery) GetDue(now time.Time) ([]*models.Delivery, error) {
+	return m.filter(func(delivery *models.Delivery) bool {
+		return (delivery.Status == models.DeliveryPending || delivery.Status == models.DeliverySending) && !delivery.NextAttempt.After(now)
+	}), nil
+}
+
+//Claim marks the delivery as sending until until, the same as the postgres model
+func (m *Delivery) Claim(id int64, now time.Time, until time.Time, due bool) (*models.Delivery, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	for _, delivery := range m.Deliveries {
+		if delivery.Id != id {
+			continue
+		}
+
+		lapsed := !delivery.NextAttempt.After(now)
+		claimable := !due || (delivery.Status == models.DeliveryPending && lapsed)
+		if delivery.Status == models.DeliverySending {
+			claimable = lapsed
+		}
+		if !claimable {
+			return nil, models.ErrNoRecord
+		}
+
+		delivery.Status = models.DeliverySending
+		delivery.NextAttempt = until
+		delivery.UpdatedAt = time.Now()
+		found := *delivery
+		return &found, nil
+	}
+	return nil, models.ErrNoRecord
+}
+
+func (m *Delivery) Insert(delivery *models.Delivery) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	delivery.Id = int64(len(m.Deliveries) + 1)
+	delivery.CreatedAt = time.Now()
+	delivery.UpdatedAt = delivery.CreatedAt
+	stored := *delivery
+	m.Deliveries = append(m.Deliveries, &stored)
+	return nil
+}
+
+func (m *Delivery) Update(delivery *models.Delivery) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	for i, existing := range m.Deliveries {
+		if existing.Id == delivery.Id {
+			delivery.UpdatedAt = time.Now()
+			stored := *delivery
+			m.Deliveries[i] = &stored
+			return nil
+		}
+	}
+	return models.ErrNoRecord
+}
+
+func (m *Delivery) filter(match func(delivery *models.Delivery) bool) []*models.Delivery {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	matched := make([]*models.Delivery, 0)
+	for _, delivery := range m.Deliveries {
+		if match(delivery) {
+			found := *delivery
+			matched = append(matched, &found)
+		}
+	}
+	return matched
+}
@@ -0,0 +1,42 @@
+package mock
+
+import (
+	"fireynis/velocity_checker/pkg/models"
+	"sort"
+)
+
+//Link keeps links in memory, ignoring repeats the same way the table's primary key does
+type Link struct {
+	Links []*models.Link
+}
+
+func (m *Link) GetCustomerIds(kind string, value string) ([]int64, error) {
+	customerIds := make([]int64, 0)
+	for _, link := range m.Links {
+		if link.Kind == kind && link.Value == value {
+			customerIds = append(customerIds, link.CustomerId)
+		}
+	}
+	sort.Slice(customerIds, func(i, j int) bool { return customerIds[i] < customerIds[j] })
+	return customerIds, nil
+}
+
+func (m *Link) GetByCustomerId(customerId int64) ([]*models.Link, error) {
+	links := make([]*models.Link, 0)
+	for _, link := range m.Links {
+		if link.CustomerId == customerId {
+			links = append(links, link)
+		}
+	}
+	return links, nil
+}
+
+func (m *Link) Insert(link *models.Link) error {
+	for _, existing := range m.Links {
+		if existing.Kind == link.Kind && existing.Value == link.Value && existing.CustomerId == link.CustomerId {
+			return nil
+		}
+	}
+	m.Links = append(m.Links, link)
+	return nil
+}
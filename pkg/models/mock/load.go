@@ -54,18 +54,32 @@ var loads = []*models.Load{
 		Time:          time.Date(2000, 1, 1, 0, 0, 0, 0, time.UTC),
 		Accepted:      true,
 	},
+	{
+		Id:            7,
+		TransactionId: 1,
+		CustomerId:    5,
+		Amount:        100000,
+		Time:          time.Date(2000, 1, 1, 0, 0, 0, 0, time.UTC),
+		Accepted:      false,
+		RiskScore:     60,
+		Outcome:       models.OutcomeReview,
+		Status:        models.StatusPendingReview,
+	},
 }
 
 type Load struct{}
 
+//Get and GetByTransactionId hand out copies so a handler changing a load can't leak into other tests
 func (m *Load) Get(id int64) (*models.Load, error) {
-	return loads[id-1], nil
+	load := *loads[id-1]
+	return &load, nil
 }
 
 func (m *Load) GetByTransactionId(customerId int64, transactionId int64) (*models.Load, error) {
 	for _, load := range loads {
 		if load.CustomerId == customerId && load.TransactionId == transactionId {
-			return load, nil
+			copied := *load
+			return &copied, nil
 		}
 	}
 	return nil, models.ErrNoRecord
@@ -85,6 +99,38 @@ func (m *Load) GetByCustomerTransactionsByDateRange(customerId int64, startDate
 	return []*models.Load{}, models.ErrNoRecord
 }
 
+func (m *Load) GetByCustomersByDateRange(customerIds []int64, startDate time.Time, endDate time.Time) ([]*models.Load, error) {
+	matched := make([]*models.Load, 0)
+	for _, load := range loads {
+		for _, customerId := range customerIds {
+			if load.CustomerId == customerId && !load.Time.Before(startDate) && !load.Time.After(endDate) {
+				matched = append(matched, load)
+			}
+		}
+	}
+	return matched, nil
+}
+
+func (m *Load) GetByDateRange(startDate time.Time, endDate time.Time) ([]*models.Load, error) {
+	inRange := make([]*models.Load, 0)
+	for _, load := range loads {
+		if !load.Time.Before(startDate) && !load.Time.After(endDate) {
+			inRange = append(inRange, load)
+		}
+	}
+	return inRange, nil
+}
+
+func (m *Load) GetByStatus(status string) ([]*models.Load, error) {
+	matched := make([]*models.Load, 0)
+	for _, load := range loads {
+		if load.Status == status {
+			matched = append(matched, load)
+		}
+	}
+	return matched, nil
+}
+
 func (m *Load) Insert(load *models.Load) (int64, error) {
 	return 5, nil
 }
@@ -92,3 +138,11 @@ func (m *Load) Insert(load *models.Load) (int64, error) {
 func (m *Load) Update(model *models.Load) error {
 	return nil
 }
+
+func (m *Load) Claim(id int64, analyst string) error {
+	load := loads[id-1]
+	if load.Status != models.StatusPendingReview || (len(load.Analyst) > 0 && load.Analyst != analyst) {
+		return models.ErrChanged
+	}
+	return nil
+}
@@ -0,0 +1,33 @@
+package mock
+
+import (
+	"fireynis/velocity_checker/pkg/models"
+	"time"
+)
+
+//Notification keeps notifications in memory, ignoring repeats the same way the table's unique constraint does
+type Notification struct {
+	Notifications []*models.Notification
+}
+
+func (m *Notification) Insert(notification *models.Notification) error {
+	for _, existing := range m.Notifications {
+		if existing.CustomerId == notification.CustomerId && existing.Limit == notification.Limit && existing.WindowStart.Equal(notification.WindowStart) && existing.Threshold == notification.Threshold {
+			return nil
+		}
+	}
+	notification.Id = int64(len(m.Notifications) + 1)
+	notification.CreatedAt = time.Now()
+	m.Notifications = append(m.Notifications, notification)
+	return nil
+}
+
+func (m *Notification) Delete(id int64) error {
+	for i, existing := range m.Notifications {
+		if existing.Id == id {
+			m.Notifications = append(m.Notifications[:i], m.Notifications[i+1:]...)
+			return nil
+		}
+	}
+	return nil
+}
@@ -0,0 +1,54 @@
+package mock
+
+import (
+	"fireynis/velocity_checker/pkg/models"
+	"sync"
+	"time"
+)
+
+//Outbox keeps the events in memory, ignoring repeated keys the same way the table's unique constraint does
+type Outbox struct {
+	Events []*models.OutboxEvent
+	mu     sync.Mutex
+}
+
+//Insert adds the event the way a Decision stores it
+func (m *Outbox) Insert(event *models.OutboxEvent) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	for _, existing := range m.Events {
+		if existing.Key == event.Key {
+			return
+		}
+	}
+	event.Id = int64(len(m.Events) + 1)
+	event.CreatedAt = time.Now()
+	m.Events = append(m.Events, event)
+}
+
+func (m *Outbox) GetUnrelayed(limit int) ([]*models.OutboxEvent, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	events := make([]*models.OutboxEvent, 0)
+	for _, event := range m.Events {
+		if event.RelayedAt.IsZero() && len(events) < limit {
+			events = append(events, event)
+		}
+	}
+	return events, nil
+}
+
+func (m *Outbox) MarkRelayed(id int64) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	for _, event := range m.Events {
+		if event.Id == id {
+			event.RelayedAt = time.Now()
+			return nil
+		}
+	}
+	return models.ErrNoRecord
+}
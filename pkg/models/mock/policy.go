@@ -0,0 +1,72 @@
+package mock
+
+import (
+	"fireynis/velocity_checker/pkg/models"
+	"time"
+)
+
+var policies = []*models.Policy{
+	{
+		Version:       1,
+		EffectiveFrom: time.Date(2000, 1, 1, 0, 0, 0, 0, time.UTC),
+		Limits: []models.Limit{
+			{Name: "daily_load_count", Window: "day", Metric: "count", Max: 3},
+			{Name: "daily_load_amount", Window: "day", Metric: "sum", Max: 500000},
+			{Name: "weekly_load_amount", Window: "week", Metric: "sum", Max: 2000000},
+		},
+	},
+	{
+		Version:       2,
+		EffectiveFrom: time.Date(2001, 1, 1, 0, 0, 0, 0, time.UTC),
+		Limits: []models.Limit{
+			{Name: "daily_load_count", Window: "day", Metric: "count", Max: 3},
+			{Name: "daily_load_amount", Window: "day", Metric: "sum", Max: 300000},
+			{Name: "weekly_load_amount", Window: "week", Metric: "sum", Max: 2000000},
+		},
+	},
+	{
+		Version:       3,
+		EffectiveFrom: time.Date(2002, 1, 1, 0, 0, 0, 0, time.UTC),
+		Limits: []models.Limit{
+			{Name: "daily_load_count", Window: "day", Metric: "count", Max: 3},
+			{Name: "daily_load_amount", Window: "day", Metric: "sum", Max: 500000},
+			{Name: "weekly_load_amount", Window: "week", Metric: "sum", Max: 2000000},
+			{Name: "daily_load_amount_tightened", Window: "day", Metric: "sum", Max: 300000, Shadow: true},
+		},
+	},
+	{
+		Version:       4,
+		EffectiveFrom: time.Date(2003, 1, 1, 0, 0, 0, 0, time.UTC),
+		Limits: []models.Limit{
+			{Name: "daily_load_amount", Expression: `sum(amount, window="day") + load.amount <= 400000 && count(window="1h") < 2`},
+		},
+	},
+}
+
+type Policy struct{}
+
+func (m *Policy) Get(version int64) (*models.Policy, error) {
+	for _, policy := range policies {
+		if policy.Version == version {
+			return policy, nil
+		}
+	}
+	return nil, models.ErrNoRecord
+}
+
+func (m *Policy) GetEffective(at time.Time) (*models.Policy, error) {
+	var effective *models.Policy
+	for _, policy := range policies {
+		if !policy.EffectiveFrom.After(at) {
+			effective = policy
+		}
+	}
+	if effective == nil {
+		return nil, models.ErrNoRecord
+	}
+	return effective, nil
+}
+
+func (m *Policy) Insert(policy *models.Policy) (int64, error) {
+	return int64(len(policies) + 1), nil
+}
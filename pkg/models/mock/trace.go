@@ -0,0 +1,55 @@
+package mock
+
+import (
+	"fireynis/velocity_checker/pkg/models"
+	"time"
+)
+
+var traces = map[int64][]models.RuleTrace{
+	6: {
+		{
+			Rule:           "daily_load_count",
+			WindowStart:    time.Date(2000, 1, 1, 0, 0, 0, 0, time.UTC),
+			WindowEnd:      time.Date(2000, 1, 1, 23, 59, 59, 999999999, time.UTC),
+			TransactionIds: []int64{1},
+			Total:          1,
+			Threshold:      3,
+			Passed:         true,
+		},
+		{
+			Rule:           "daily_load_amount_tightened",
+			WindowStart:    time.Date(2000, 1, 1, 0, 0, 0, 0, time.UTC),
+			WindowEnd:      time.Date(2000, 1, 1, 23, 59, 59, 999999999, time.UTC),
+			TransactionIds: []int64{},
+			Total:          250000,
+			Threshold:      200000,
+			Passed:         false,
+			Shadow:         true,
+		},
+	},
+}
+
+type Trace struct{}
+
+func (m *Trace) GetByLoadId(loadId int64) ([]models.RuleTrace, error) {
+	trace, ok := traces[loadId]
+	if !ok {
+		return nil, models.ErrNoRecord
+	}
+	return trace, nil
+}
+
+func (m *Trace) GetByDateRange(startDate time.Time, endDate time.Time) ([]*models.TracedLoad, error) {
+	traced := make([]*models.TracedLoad, 0)
+	for _, load := range loads {
+		trace, ok := traces[load.Id]
+		if ok && !load.Time.Before(startDate) && !load.Time.After(endDate) {
+			traced = append(traced, &models.TracedLoad{Load: load, Trace: trace})
+		}
+	}
+	return traced, nil
+}
+
+func (m *Trace) Insert(loadId int64, trace []models.RuleTrace) error {
+	return nil
+}
@@ -1,26 +1,400 @@
 package models
 
 import (
+	"encoding/json"
 	"errors"
 	"time"
 )
 
 var ErrNoRecord = errors.New("models: no matching record found")
 
+//ErrDuplicate is returned when a load is inserted with a transaction id the customer has already used
+var ErrDuplicate = errors.New("models: duplicate record")
+
+//ErrChanged is returned when a load is updated but someone else changed it after it was read
+var ErrChanged = errors.New("models: record changed since it was read")
+
+//ErrBalanceChanged is returned when an accepted load is inserted but the customer's balance has moved since the engine
+//checked it, so the load would take it past its BalanceMin or BalanceMax
+var ErrBalanceChanged = errors.New("models: balance changed since it was checked")
+
+//The outcomes of a decision. Accepted is only true for an approved load.
+const (
+	OutcomeApprove = "approve"
+	OutcomeReview  = "review"
+	OutcomeDecline = "decline"
+)
+
+//The states a load can be in. Loads the engine sends for review wait in StatusPendingReview until an analyst, or the
+//review SLA, decides them.
+const (
+	StatusDecided       = "decided"
+	StatusPendingReview = "pending_review"
+)
+
+//The channels a load can arrive through
+const (
+	ChannelMobile = "mobile"
+	ChannelWeb    = "web"
+	ChannelBranch = "branch"
+	ChannelApi    = "api"
+)
+
+//The kinds of funding source. A card is identified by its fingerprint rather than its number.
+const (
+	FundingCard        = "card"
+	FundingBankAccount = "bank_account"
+)
+
+//ReasonCoolingOff is the reason given for a load declined because the customer is in a cooling-off lockout
+const ReasonCoolingOff = "COOLING_OFF"
+
+//ReasonBalanceChanged is the reason given for a load the engine accepted but which was declined when it was stored,
+//because the customer's balance had moved since it was checked
+const ReasonBalanceChanged = "BALANCE_CHANGED"
+
+//ReasonExpressionError starts the reason given for a load declined because an expression limit couldn't be evaluated,
+//the rule and its error follow it
+const ReasonExpressionError = "EXPRESSION_ERROR"
+
+//The kinds of transaction. A load adds to the customer's balance and is checked against the policy's limits, a debit
+//takes away from it and only needs the balance to cover it.
+const (
+	TypeLoad  = "load"
+	TypeDebit = "debit"
+)
+
 //Storing money as in int (value * 100) means you don't lose precision. Effectively working in pennies.
 type Load struct {
 	Id            int64
 	TransactionId int64
 	CustomerId    int64
-	Amount        int64
+	//Type is TypeLoad or TypeDebit. Loads stored before debits existed have TypeLoad.
+	Type string
+	//Amount is in the minor units of the base currency limits are evaluated in. OriginalAmount is what was loaded, in
+	//the minor units of Currency.
+	Amount         int64
+	Currency       string
+	OriginalAmount int64
+	//Time is what the load is evaluated and windowed on, either the ClientTime it arrived with or the time the server
+	//ReceivedAt
 	Time          time.Time
+	ClientTime    time.Time
+	ReceivedAt    time.Time
+	Accepted      bool
+	PolicyVersion int64
+	RiskScore     int64
+	Outcome       string
+	Status        string
+	//Reason is set when a load is declined without being checked against the limits, e.g. ReasonCoolingOff
+	Reason string
+	//Analyst is who has claimed or reviewed a load sent for review, ReviewNote is their reasoning
+	Analyst    string
+	ReviewNote string
+	//DeviceId, Address and FundingSource are optional and identify where the load came from. Customers sharing any of
+	//them are linked.
+	DeviceId      string
+	Address       string
+	FundingSource string
+	//The rest are optional details of the load that limit expressions can read
+	FundingType string
+	Channel     string
+	MerchantId  string
+	IpAddress   string
+	Metadata    map[string]string
+	//BalanceMin and BalanceMax are the bounds the engine checked the customer's balance after the load against, nil
+	//for no bound. An accepted load is only stored while its move leaves the balance within them. They aren't stored.
+	BalanceMin *int64
+	BalanceMax *int64
+}
+
+//Limit is a single velocity limit. Window is "day", "week" or a duration such as "24h" for a rolling window ending at
+//the load's time. Metric is what is totalled over the window, "count", "sum" or "distinct". Instead of a window, metric
+//and max a limit can be written as an Expression, see policy.Compile. A shadow limit is evaluated and recorded but
+//never changes whether a load is accepted. Scope is "customer", the default, or "linked" to total the loads of every
+//linked customer.
+//
+//Where narrows a limit to loads with the given field values, and GroupBy to loads sharing the evaluated load's value of
+//a field, e.g. its channel. The "distinct" metric counts the different values of Field.
+//
+//A "balance" limit has no window and caps the customer's balance after the load at Max. An "amount" limit has no
+//window either and holds each load's own amount between Min and Max, leaving either out for no bound on that side.
+type Limit struct {
+	Name       string            `json:"name"`
+	Window     string            `json:"window,omitempty"`
+	Metric     string            `json:"metric,omitempty"`
+	Min        int64             `json:"min,omitempty"`
+	Max        int64             `json:"max,omitempty"`
+	Expression string            `json:"expression,omitempty"`
+	Shadow     bool              `json:"shadow,omitempty"`
+	Scope      string            `json:"scope,omitempty"`
+	GroupBy    string            `json:"group_by,omitempty"`
+	Where      map[string]string `json:"where,omitempty"`
+	Field      string            `json:"field,omitempty"`
+}
+
+//Policy is a versioned set of limits. A policy applies to loads timed at or after EffectiveFrom until the next
+//version takes effect.
+type Policy struct {
+	Version       int64              `json:"version"`
+	EffectiveFrom time.Time          `json:"effective_from"`
+	Limits        []Limit            `json:"limits"`
+	Risk          *RiskConfig        `json:"risk,omitempty"`
+	Structuring   *StructuringConfig `json:"structuring,omitempty"`
+	Anomaly       *AnomalyConfig     `json:"anomaly,omitempty"`
+	CoolingOff    *CoolingOffConfig  `json:"cooling_off,omitempty"`
+}
+
+//RiskConfig weighs risk signals, each scored between 0 and 1, into a score between 0 and 100. A load that passes every
+//limit is sent for review at ReviewAt and declined at DeclineAt.
+type RiskConfig struct {
+	Weights   map[string]float64 `json:"weights"`
+	ReviewAt  int64              `json:"review_at"`
+	DeclineAt int64              `json:"decline_at"`
+}
+
+//RuleTrace records the figures a single limit was evaluated against so a decision can be explained after the fact.
+//Total includes the load being evaluated, so the rule passes when Total <= Threshold.
+type RuleTrace struct {
+	Rule           string    `json:"rule"`
+	WindowStart    time.Time `json:"window_start"`
+	WindowEnd      time.Time `json:"window_end"`
+	TransactionIds []int64   `json:"transaction_ids"`
+	Total          int64     `json:"total"`
+	Threshold      int64     `json:"threshold"`
+	Passed         bool      `json:"passed"`
+	Shadow         bool      `json:"shadow,omitempty"`
+	//Expression limits have no single total or threshold, the value of each aggregate in the expression is kept instead
+	Expression string           `json:"expression,omitempty"`
+	Values     map[string]int64 `json:"values,omitempty"`
+	//Tier is the anomaly tier an anomaly limit was checked against
+	Tier string `json:"tier,omitempty"`
+	//CustomerIds are the linked customers whose loads a linked limit covered
+	CustomerIds []int64 `json:"customer_ids,omitempty"`
+	//Error is why an expression limit couldn't be evaluated, the limit fails when it is set
+	Error string `json:"error,omitempty"`
+}
+
+//Link records that a customer has used a device, address or funding source
+type Link struct {
+	Kind       string
+	Value      string
+	CustomerId int64
+	FirstSeen  time.Time
+}
+
+//AnomalyConfig sets how far a load can stray from the customer's own history in Window before it is an outlier. The
+//tier with the highest MinHistory the customer's loads reach applies, so thin histories can be held to looser scores.
+type AnomalyConfig struct {
+	Window string        `json:"window"`
+	Tiers  []AnomalyTier `json:"tiers"`
+}
+
+//AnomalyTier holds the modified z-scores above which a load's amount or time of day is an outlier. A score of 0 turns
+//that check off.
+type AnomalyTier struct {
+	Name        string  `json:"name"`
+	MinHistory  int     `json:"min_history"`
+	AmountScore float64 `json:"amount_score"`
+	HourScore   float64 `json:"hour_score"`
+}
+
+//CoolingOffConfig locks a customer out for Lockout once Rejections of their loads have been declined within Window.
+//Window and Lockout are durations such as "1h".
+type CoolingOffConfig struct {
+	Rejections int    `json:"rejections"`
+	Window     string `json:"window"`
+	Lockout    string `json:"lockout"`
+}
+
+//StructuringConfig tunes structuring detection. A figure counts as just under a limit when it is within NearPercent
+//below it, and a pattern has to hold on Days consecutive days to raise an alert.
+type StructuringConfig struct {
+	NearPercent float64 `json:"near_percent"`
+	Days        int     `json:"days"`
+}
+
+//Alert is a suspicious pattern found across a customer's loads
+type Alert struct {
+	Id             int64     `json:"-"`
+	CustomerId     int64     `json:"customer_id"`
+	Pattern        string    `json:"pattern"`
+	WindowStart    time.Time `json:"window_start"`
+	WindowEnd      time.Time `json:"window_end"`
+	TransactionIds []int64   `json:"transaction_ids"`
+	Detail         string    `json:"detail"`
+	CreatedAt      time.Time `json:"created_at"`
+}
+
+//Notification tells a customer their loads have reached Threshold percent of a limit's Max in the window. Used is
+//the window's total once the load with TransactionId was accepted.
+type Notification struct {
+	Id            int64     `json:"-"`
+	CustomerId    int64     `json:"customer_id"`
+	TransactionId int64     `json:"id"`
+	Limit         string    `json:"limit"`
+	Window        string    `json:"window"`
+	WindowStart   time.Time `json:"window_start"`
+	WindowEnd     time.Time `json:"window_end"`
+	Threshold     int64     `json:"threshold"`
+	Used          int64     `json:"used"`
+	Max           int64     `json:"max"`
+	CreatedAt     time.Time `json:"created_at"`
+}
+
+//The states of a webhook delivery. A pending delivery is retried at NextAttempt until it is delivered or has run out of
+//attempts and failed. A delivery is claimed as sending while it is sent, NextAttempt is then when the claim lapses so
+//a delivery left behind by a sender that died is picked up again.
+const (
+	DeliveryPending   = "pending"
+	DeliverySending   = "sending"
+	DeliveryDelivered = "delivered"
+	DeliveryFailed    = "failed"
+)
+
+//Delivery is a webhook payload for an event and the state of getting it to one subscriber. Subscription is the id of
+//the subscription it is for, blank on deliveries stored before subscriptions had ids.
+type Delivery struct {
+	Id           int64           `json:"id"`
+	Subscription string          `json:"subscription"`
+	Url          string          `json:"url"`
+	Event        string          `json:"event"`
+	Payload      json.RawMessage `json:"payload"`
+	Status       string          `json:"status"`
+	Attempts     int             `json:"attempts"`
+	NextAttempt  time.Time       `json:"next_attempt"`
+	ResponseCode int             `json:"response_code,omitempty"`
+	LastError    string          `json:"last_error,omitempty"`
+	CreatedAt    time.Time       `json:"created_at"`
+	UpdatedAt    time.Time       `json:"updated_at"`
+}
+
+//OutboxEvent is an event written in the same transaction as the change it describes, so it can't be lost between the
+//change being stored and the event being published. Key is unique to the change and is sent with the event so that
+//sinks can drop the repeats at-least-once delivery allows. RelayedAt is zero until every sink has taken it.
+type OutboxEvent struct {
+	Id        int64           `json:"-"`
+	Key       string          `json:"key"`
+	Event     string          `json:"event"`
+	Payload   json.RawMessage `json:"payload"`
+	CreatedAt time.Time       `json:"created_at"`
+	RelayedAt time.Time       `json:"-"`
+}
+
+//Correction is a stored decision that would have gone the other way had a late load arrived in time. The decision
+//itself is left alone for an analyst to override.
+type Correction struct {
+	Id            int64    `json:"-"`
+	CustomerId    int64    `json:"customer_id"`
+	TransactionId int64    `json:"id"`
+	WasOutcome    string   `json:"was_outcome"`
+	Outcome       string   `json:"outcome"`
+	FailedRules   []string `json:"failed_rules"`
+	//LateCustomerId and LateTransactionId identify the late load
+	LateCustomerId    int64     `json:"late_customer_id"`
+	LateTransactionId int64     `json:"late_id"`
+	CreatedAt         time.Time `json:"created_at"`
+}
+
+//TracedLoad pairs a stored load with the trace of the decision made on it
+type TracedLoad struct {
+	Load  *Load
+	Trace []RuleTrace
+}
+
+//AuditEntry is a single append-only record of something that decided or changed a load's outcome. Each entry carries
+//the hash of the entry before it so that any edit or deletion breaks the chain.
+type AuditEntry struct {
+	Id            int64
+	Event         string
+	LoadId        int64
+	CustomerId    int64
+	TransactionId int64
+	Amount        int64
 	Accepted      bool
+	Actor         string
+	Note          string
+	CreatedAt     time.Time
+	PrevHash      string
+	Hash          string
 }
 
 type ILoads interface {
 	Get(id int64) (*Load, error)
 	GetByTransactionId(customerId int64, transactionId int64) (*Load, error)
 	GetByCustomerTransactionsByDateRange(customerId int64, startDate time.Time, endDate time.Time) ([]*Load, error)
+	GetByCustomersByDateRange(customerIds []int64, startDate time.Time, endDate time.Time) ([]*Load, error)
+	GetByDateRange(startDate time.Time, endDate time.Time) ([]*Load, error)
+	GetByStatus(status string) ([]*Load, error)
 	Insert(load *Load) (int64, error)
 	Update(model *Load) error
+	Claim(id int64, analyst string) error
+}
+
+type ITraces interface {
+	GetByLoadId(loadId int64) ([]RuleTrace, error)
+	GetByDateRange(startDate time.Time, endDate time.Time) ([]*TracedLoad, error)
+	Insert(loadId int64, trace []RuleTrace) error
+}
+
+type IAudit interface {
+	Append(entry *AuditEntry) error
+	Walk(fn func(entry *AuditEntry) error) error
+}
+
+type IPolicies interface {
+	Get(version int64) (*Policy, error)
+	GetEffective(at time.Time) (*Policy, error)
+	Insert(policy *Policy) (int64, error)
+}
+
+type ILinks interface {
+	GetCustomerIds(kind string, value string) ([]int64, error)
+	GetByCustomerId(customerId int64) ([]*Link, error)
+	Insert(link *Link) error
+}
+
+type IBalances interface {
+	Get(customerId int64) (int64, error)
+	Adjust(customerId int64, delta int64) (int64, error)
+}
+
+type ICorrections interface {
+	GetByDateRange(startDate time.Time, endDate time.Time) ([]*Correction, error)
+	Insert(correction *Correction) error
+}
+
+type IAlerts interface {
+	GetByDateRange(startDate time.Time, endDate time.Time) ([]*Alert, error)
+	Insert(alert *Alert) error
+}
+
+type INotifications interface {
+	Insert(notification *Notification) error
+	Delete(id int64) error
+}
+
+//IDecisions stores a newly decided load together with its audit entry, the move in the customer's balance, its
+//webhook deliveries, and its outbox event when there is one, so either all of them are stored or none are. Update does
+//the same for a stored load whose decision changes, as previous was read, and fails with ErrChanged if it has changed
+//since. Insert fails with
+//ErrBalanceChanged, storing nothing, when an accepted load would leave the balance outside its BalanceMin or
+//BalanceMax.
+type IDecisions interface {
+	Insert(load *Load, entry *AuditEntry, event *OutboxEvent, deliveries []*Delivery) (int64, error)
+	Update(previous *Load, load *Load, entry *AuditEntry, event *OutboxEvent, deliveries []*Delivery) error
+}
+
+type IOutbox interface {
+	GetUnrelayed(limit int) ([]*OutboxEvent, error)
+	MarkRelayed(id int64) error
+}
+
+type IDeliveries interface {
+	Get(id int64) (*Delivery, error)
+	GetByStatus(status string) ([]*Delivery, error)
+	GetDue(now time.Time) ([]*Delivery, error)
+	Claim(id int64, now time.Time, until time.Time, due bool) (*Delivery, error)
+	Insert(delivery *Delivery) error
+	Update(delivery *Delivery) error
 }
@@ -0,0 +1,46 @@
+package postgres
+
+import (
+	"context"
+	"errors"
+	"fireynis/velocity_checker/pkg/models"
+	"github.com/jackc/pgx/v4"
+	"github.com/jackc/pgx/v4/pgxpool"
+	"time"
+)
+
+type AlertModel struct {
+	DB *pgxpool.Pool
+}
+
+//GetByDateRange retrieves every alert whose window ends within the range, oldest first
+func (m *AlertModel) GetByDateRange(startDate time.Time, endDate time.Time) ([]*models.Alert, error) {
+	stmt := "SELECT id, customer_id, pattern, window_start, window_end, transaction_ids, detail, created_at FROM alerts WHERE window_end >= $1 and window_end <= $2 ORDER BY window_end, id"
+
+	rows, err := m.DB.Query(context.Background(), stmt, startDate, endDate)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	alerts := make([]*models.Alert, 0)
+	for rows.Next() {
+		alert := &models.Alert{}
+		err := rows.Scan(&alert.Id, &alert.CustomerId, &alert.Pattern, &alert.WindowStart, &alert.WindowEnd, &alert.TransactionIds, &alert.Detail, &alert.CreatedAt)
+		if err != nil {
+			return nil, err
+		}
+		alerts = append(alerts, alert)
+	}
+	return alerts, rows.Err()
+}
+
+//Insert stores the alert unless the same pattern has already been alerted on for the customer and window
+func (m *AlertModel) Insert(alert *models.Alert) error {
+	stmt := "INSERT INTO alerts (customer_id, pattern, window_start, window_end, transaction_ids, detail) VALUES ($1, $2, $3, $4, $5, $6) ON CONFLICT (customer_id, pattern, window_end) DO NOTHING RETURNING id, created_at"
+	err := m.DB.QueryRow(context.Background(), stmt, alert.CustomerId, alert.Pattern, alert.WindowStart, alert.WindowEnd, alert.TransactionIds, alert.Detail).Scan(&alert.Id, &alert.CreatedAt)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return nil
+	}
+	return err
+}
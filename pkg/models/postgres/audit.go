@@ -0,0 +1,75 @@
+package postgres
+
+import (
+	"context"
+	"errors"
+	"fireynis/velocity_checker/pkg/audit"
+	"fireynis/velocity_checker/pkg/models"
+	"github.com/jackc/pgx/v4"
+	"github.com/jackc/pgx/v4/pgxpool"
+)
+
+type AuditModel struct {
+	DB *pgxpool.Pool
+}
+
+//Append chains the entry onto the last one written. The table is locked for the transaction so two writers can't
+//both chain onto the same previous entry.
+func (m *AuditModel) Append(entry *models.AuditEntry) error {
+	ctx := context.Background()
+	tx, err := m.DB.Begin(ctx)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback(ctx)
+
+	err = appendEntry(ctx, tx, entry)
+	if err != nil {
+		return err
+	}
+	return tx.Commit(ctx)
+}
+
+//appendEntry chains the entry on inside the transaction, which holds the table lock until it ends
+func appendEntry(ctx context.Context, tx pgx.Tx, entry *models.AuditEntry) error {
+	_, err := tx.Exec(ctx, "LOCK TABLE audit_log IN SHARE ROW EXCLUSIVE MODE")
+	if err != nil {
+		return err
+	}
+
+	var prevHash string
+	err = tx.QueryRow(ctx, "SELECT hash FROM audit_log ORDER BY id DESC LIMIT 1").Scan(&prevHash)
+	if err != nil && !errors.Is(err, pgx.ErrNoRows) {
+		return err
+	}
+	entry.PrevHash = prevHash
+	entry.Hash = audit.Hash(entry)
+
+	stmt := "INSERT INTO audit_log (event, load_id, customer_id, transaction_id, load_amount, accepted, actor, note, created_at, prev_hash, hash) VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11) RETURNING id"
+	return tx.QueryRow(ctx, stmt, entry.Event, entry.LoadId, entry.CustomerId, entry.TransactionId, entry.Amount, entry.Accepted,
+		entry.Actor, entry.Note, entry.CreatedAt, entry.PrevHash, entry.Hash).Scan(&entry.Id)
+}
+
+//Walk calls fn for every entry in the order they were appended, stopping at the first error fn returns
+func (m *AuditModel) Walk(fn func(entry *models.AuditEntry) error) error {
+	stmt := "SELECT id, event, load_id, customer_id, transaction_id, load_amount, accepted, actor, note, created_at, prev_hash, hash FROM audit_log ORDER BY id"
+	rows, err := m.DB.Query(context.Background(), stmt)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var entry models.AuditEntry
+		err := rows.Scan(&entry.Id, &entry.Event, &entry.LoadId, &entry.CustomerId, &entry.TransactionId, &entry.Amount,
+			&entry.Accepted, &entry.Actor, &entry.Note, &entry.CreatedAt, &entry.PrevHash, &entry.Hash)
+		if err != nil {
+			return err
+		}
+		err = fn(&entry)
+		if err != nil {
+			return err
+		}
+	}
+	return rows.Err()
+}
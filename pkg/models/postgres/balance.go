@@ -0,0 +1,65 @@
+package postgres
+
+import (
+	"context"
+	"errors"
+	"fireynis/velocity_checker/pkg/models"
+	"github.com/jackc/pgx/v4"
+	"github.com/jackc/pgx/v4/pgxpool"
+)
+
+type BalanceModel struct {
+	DB *pgxpool.Pool
+}
+
+//Get retrieves the customer's balance. A customer with no balance yet has a balance of 0.
+func (m *BalanceModel) Get(customerId int64) (int64, error) {
+	stmt := "SELECT balance FROM balances WHERE customer_id = $1"
+
+	var balance int64
+	err := m.DB.QueryRow(context.Background(), stmt, customerId).Scan(&balance)
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return 0, nil
+		}
+		return 0, err
+	}
+	return balance, nil
+}
+
+//Adjust adds delta to the customer's balance in a single statement, so concurrent adjustments can't lose each other,
+//and returns the new balance
+func (m *BalanceModel) Adjust(customerId int64, delta int64) (int64, error) {
+	return adjustBalance(context.Background(), m.DB, customerId, delta)
+}
+
+func adjustBalance(ctx context.Context, db querier, customerId int64, delta int64) (int64, error) {
+	stmt := "INSERT INTO balances (customer_id, balance, updated_at) VALUES ($1, $2, now()) ON CONFLICT (customer_id) DO UPDATE SET balance = balances.balance + EXCLUDED.balance, updated_at = now() RETURNING balance"
+
+	var balance int64
+	err := db.QueryRow(ctx, stmt, customerId, delta).Scan(&balance)
+	if err != nil {
+		return 0, err
+	}
+	return balance, nil
+}
+
+//adjustBalanceWithin adds delta to the customer's balance like adjustBalance, but only while the new balance is within
+//min and max, either of which can be nil for no bound. The bounds are checked in the same statement that moves the
+//balance, so two loads can't both pass them on the balance from before either. models.ErrBalanceChanged is returned
+//when the balance would leave them.
+func adjustBalanceWithin(ctx context.Context, db querier, customerId int64, delta int64, min *int64, max *int64) (int64, error) {
+	//A customer with no balance yet starts from 0, which is what the engine checked, so only the update needs bounding
+	stmt := "INSERT INTO balances (customer_id, balance, updated_at) VALUES ($1, $2, now()) ON CONFLICT (customer_id) DO UPDATE SET balance = balances.balance + EXCLUDED.balance, updated_at = now() WHERE ($3::bigint IS NULL OR balances.balance + EXCLUDED.balance >= $3) AND ($4::bigint IS NULL OR balances.balance + EXCLUDED.balance <= $4) RETURNING balance"
+
+	var balance int64
+	err := db.QueryRow(ctx, stmt, customerId, delta, min, max).Scan(&balance)
+	if err != nil {
+		//The update was skipped so no row came back
+		if errors.Is(err, pgx.ErrNoRows) {
+			return 0, models.ErrBalanceChanged
+		}
+		return 0, err
+	}
+	return balance, nil
+}
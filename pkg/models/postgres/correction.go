@@ -0,0 +1,48 @@
+package postgres
+
+import (
+	"context"
+	"errors"
+	"fireynis/velocity_checker/pkg/models"
+	"github.com/jackc/pgx/v4"
+	"github.com/jackc/pgx/v4/pgxpool"
+	"time"
+)
+
+type CorrectionModel struct {
+	DB *pgxpool.Pool
+}
+
+//GetByDateRange retrieves every correction raised within the range, oldest first
+func (m *CorrectionModel) GetByDateRange(startDate time.Time, endDate time.Time) ([]*models.Correction, error) {
+	stmt := "SELECT id, customer_id, transaction_id, was_outcome, outcome, failed_rules, late_customer_id, late_transaction_id, created_at FROM corrections WHERE created_at >= $1 and created_at <= $2 ORDER BY created_at, id"
+
+	rows, err := m.DB.Query(context.Background(), stmt, startDate, endDate)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	corrections := make([]*models.Correction, 0)
+	for rows.Next() {
+		correction := &models.Correction{}
+		err := rows.Scan(&correction.Id, &correction.CustomerId, &correction.TransactionId, &correction.WasOutcome, &correction.Outcome,
+			&correction.FailedRules, &correction.LateCustomerId, &correction.LateTransactionId, &correction.CreatedAt)
+		if err != nil {
+			return nil, err
+		}
+		corrections = append(corrections, correction)
+	}
+	return corrections, rows.Err()
+}
+
+//Insert stores the correction unless the same late load has already flagged the same decision
+func (m *CorrectionModel) Insert(correction *models.Correction) error {
+	stmt := "INSERT INTO corrections (customer_id, transaction_id, was_outcome, outcome, failed_rules, late_customer_id, late_transaction_id) VALUES ($1, $2, $3, $4, $5, $6, $7) ON CONFLICT (customer_id, transaction_id, late_customer_id, late_transaction_id) DO NOTHING RETURNING id, created_at"
+	err := m.DB.QueryRow(context.Background(), stmt, correction.CustomerId, correction.TransactionId, correction.WasOutcome, correction.Outcome,
+		correction.FailedRules, correction.LateCustomerId, correction.LateTransactionId).Scan(&correction.Id, &correction.CreatedAt)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return nil
+	}
+	return err
+}
@@ -0,0 +1,130 @@
+package postgres
+
+import (
+	"context"
+	"fireynis/velocity_checker/pkg/balance"
+	"fireynis/velocity_checker/pkg/models"
+	"github.com/jackc/pgx/v4"
+	"github.com/jackc/pgx/v4/pgxpool"
+)
+
+type DecisionModel struct {
+	DB *pgxpool.Pool
+}
+
+//Insert stores the load, its audit entry, its webhook deliveries and its outbox event in one transaction, and moves the
+//customer's balance when the load is accepted. The entry is pointed at the load's new id before it is chained on, and the audit log is
+//locked last to keep the time it is held short. An event whose key is already in the outbox is left out, event can be
+//nil when the outbox is off.
+//
+//The balance is moved only while it stays within the load's BalanceMin and BalanceMax, as the engine checked it outside
+//the transaction. models.ErrBalanceChanged is returned, and nothing stored, when it has moved past them since.
+func (m *DecisionModel) Insert(load *models.Load, entry *models.AuditEntry, event *models.OutboxEvent, deliveries []*models.Delivery) (int64, error) {
+	ctx := context.Background()
+	tx, err := m.DB.Begin(ctx)
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback(ctx)
+
+	id, err := insertLoad(ctx, tx, load)
+	if err != nil {
+		return 0, err
+	}
+
+	if load.Accepted {
+		_, err = adjustBalanceWithin(ctx, tx, load.CustomerId, balance.Delta(load), load.BalanceMin, load.BalanceMax)
+		if err != nil {
+			return 0, err
+		}
+	}
+
+	err = insertEvent(ctx, tx, event)
+	if err != nil {
+		return 0, err
+	}
+
+	err = insertDeliveries(ctx, tx, deliveries)
+	if err != nil {
+		return 0, err
+	}
+
+	entry.LoadId = id
+	err = appendEntry(ctx, tx, entry)
+	if err != nil {
+		return 0, err
+	}
+	return id, tx.Commit(ctx)
+}
+
+//Update stores the load's new decision with its audit entry, webhook deliveries and outbox event in one transaction, moving the customer's balance when
+//the load's acceptance changed from previous. The load is only updated while its acceptance, status and analyst are
+//still those of previous, so two people changing it at once can't both move the balance. models.ErrChanged is returned
+//when they aren't.
+func (m *DecisionModel) Update(previous *models.Load, load *models.Load, entry *models.AuditEntry, event *models.OutboxEvent, deliveries []*models.Delivery) error {
+	ctx := context.Background()
+	tx, err := m.DB.Begin(ctx)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback(ctx)
+
+	stmt := "UPDATE loads SET accepted = $1, outcome = $2, status = $3, analyst = $4, review_note = $5 WHERE id = $6 AND accepted = $7 AND status = $8 AND analyst = $9"
+	tag, err := tx.Exec(ctx, stmt, load.Accepted, load.Outcome, load.Status, load.Analyst, load.ReviewNote, load.Id,
+		previous.Accepted, previous.Status, previous.Analyst)
+	if err != nil {
+		return err
+	}
+	if tag.RowsAffected() < 1 {
+		return models.ErrChanged
+	}
+
+	if load.Accepted != previous.Accepted {
+		delta := balance.Delta(load)
+		if !load.Accepted {
+			delta = -delta
+		}
+		_, err = adjustBalance(ctx, tx, load.CustomerId, delta)
+		if err != nil {
+			return err
+		}
+	}
+
+	err = insertEvent(ctx, tx, event)
+	if err != nil {
+		return err
+	}
+
+	err = insertDeliveries(ctx, tx, deliveries)
+	if err != nil {
+		return err
+	}
+
+	entry.LoadId = load.Id
+	err = appendEntry(ctx, tx, entry)
+	if err != nil {
+		return err
+	}
+	return tx.Commit(ctx)
+}
+
+//insertEvent adds the event to the outbox unless its key is already there. A nil event, as when the outbox is off, is
+//left out.
+func insertEvent(ctx context.Context, tx pgx.Tx, event *models.OutboxEvent) error {
+	if event == nil {
+		return nil
+	}
+	stmt := "INSERT INTO outbox (key, event, payload) VALUES ($1, $2, $3) ON CONFLICT (key) DO NOTHING"
+	_, err := tx.Exec(ctx, stmt, event.Key, event.Event, []byte(event.Payload))
+	return err
+}
+
+func insertDeliveries(ctx context.Context, tx pgx.Tx, deliveries []*models.Delivery) error {
+	for _, delivery := range deliveries {
+		err := insertDelivery(ctx, tx, delivery)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
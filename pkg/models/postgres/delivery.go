@@ -0,0 +1,109 @@
+package postgres
+
+import (
+	"context"
+	"errors"
+	"fireynis/velocity_checker/pkg/models"
+	"github.com/jackc/pgx/v4"
+	"github.com/jackc/pgx/v4/pgxpool"
+	"time"
+)
+
+const deliveryColumns = "id, subscription_id, url, event, payload, status, attempts, next_attempt, response_code, last_error, created_at, updated_at"
+
+type DeliveryModel struct {
+	DB *pgxpool.Pool
+}
+
+func (m *DeliveryModel) Get(id int64) (*models.Delivery, error) {
+	stmt := "SELECT " + deliveryColumns + " FROM webhook_deliveries WHERE id = $1"
+
+	delivery, err := scanDelivery(m.DB.QueryRow(context.Background(), stmt, id))
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return nil, models.ErrNoRecord
+		}
+		return nil, err
+	}
+	return delivery, nil
+}
+
+//GetByStatus retrieves every delivery in the status, oldest first
+func (m *DeliveryModel) GetByStatus(status string) ([]*models.Delivery, error) {
+	stmt := "SELECT " + deliveryColumns + " FROM webhook_deliveries WHERE status = $1 ORDER BY created_at, id"
+	return m.query(stmt, status)
+}
+
+//GetDue retrieves the pending deliveries whose next attempt is at or before now, and the ones whose claim has lapsed,
+//oldest first
+func (m *DeliveryModel) GetDue(now time.Time) ([]*models.Delivery, error) {
+	stmt := "SELECT " + deliveryColumns + " FROM webhook_deliveries WHERE status IN ($1, $2) and next_attempt <= $3 ORDER BY next_attempt, id"
+	return m.query(stmt, models.DeliveryPending, models.DeliverySending, now)
+}
+
+//Claim marks the delivery as sending until until and returns it, so only one sender can have it at a time. A delivery
+//already being sent can only be claimed once its claim has lapsed by now. With due set only a pending delivery whose
+//next attempt is at or before now can be claimed, otherwise one in any other status can. A delivery that can't be
+//claimed returns models.ErrNoRecord.
+func (m *DeliveryModel) Claim(id int64, now time.Time, until time.Time, due bool) (*models.Delivery, error) {
+	stmt := "UPDATE webhook_deliveries SET status = $1, next_attempt = $2, updated_at = now() WHERE id = $3 AND " +
+		"((status = $1 AND next_attempt <= $4) OR (status <> $1 AND (NOT $5 OR (status = $6 AND next_attempt <= $4)))) RETURNING " + deliveryColumns
+
+	delivery, err := scanDelivery(m.DB.QueryRow(context.Background(), stmt, models.DeliverySending, until, id, now, due, models.DeliveryPending))
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return nil, models.ErrNoRecord
+		}
+		return nil, err
+	}
+	return delivery, nil
+}
+
+func (m *DeliveryModel) Insert(delivery *models.Delivery) error {
+	return insertDelivery(context.Background(), m.DB, delivery)
+}
+
+func insertDelivery(ctx context.Context, db querier, delivery *models.Delivery) error {
+	stmt := "INSERT INTO webhook_deliveries (subscription_id, url, event, payload, status, attempts, next_attempt) VALUES ($1, $2, $3, $4, $5, $6, $7) RETURNING id, created_at, updated_at"
+	return db.QueryRow(ctx, stmt, delivery.Subscription, delivery.Url, delivery.Event, []byte(delivery.Payload), delivery.Status, delivery.Attempts, delivery.NextAttempt).
+		Scan(&delivery.Id, &delivery.CreatedAt, &delivery.UpdatedAt)
+}
+
+func (m *DeliveryModel) Update(delivery *models.Delivery) error {
+	stmt := "UPDATE webhook_deliveries SET status = $1, attempts = $2, next_attempt = $3, response_code = $4, last_error = $5, updated_at = now() WHERE id = $6 RETURNING updated_at"
+	err := m.DB.QueryRow(context.Background(), stmt, delivery.Status, delivery.Attempts, delivery.NextAttempt, delivery.ResponseCode, delivery.LastError, delivery.Id).Scan(&delivery.UpdatedAt)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return models.ErrNoRecord
+	}
+	return err
+}
+
+func (m *DeliveryModel) query(stmt string, args ...interface{}) ([]*models.Delivery, error) {
+	rows, err := m.DB.Query(context.Background(), stmt, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	deliveries := make([]*models.Delivery, 0)
+	for rows.Next() {
+		delivery, err := scanDelivery(rows)
+		if err != nil {
+			return nil, err
+		}
+		deliveries = append(deliveries, delivery)
+	}
+	return deliveries, rows.Err()
+}
+
+func scanDelivery(row pgx.Row) (*models.Delivery, error) {
+	delivery := &models.Delivery{}
+	var payload []byte
+	err := row.Scan(&delivery.Id, &delivery.Subscription, &delivery.Url, &delivery.Event, &payload, &delivery.Status, &delivery.Attempts, &delivery.NextAttempt,
+		&delivery.ResponseCode, &delivery.LastError, &delivery.CreatedAt, &delivery.UpdatedAt)
+	if err != nil {
+		return nil, err
+	}
+	delivery.Payload = payload
+	return delivery, nil
+}
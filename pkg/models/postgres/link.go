@@ -0,0 +1,62 @@
+package postgres
+
+import (
+	"context"
+	"fireynis/velocity_checker/pkg/models"
+	"github.com/jackc/pgx/v4/pgxpool"
+)
+
+type LinkModel struct {
+	DB *pgxpool.Pool
+}
+
+//GetCustomerIds finds every customer that has used the device, address or funding source
+func (m *LinkModel) GetCustomerIds(kind string, value string) ([]int64, error) {
+	stmt := "SELECT customer_id FROM customer_links WHERE kind = $1 and value = $2 ORDER BY customer_id"
+
+	rows, err := m.DB.Query(context.Background(), stmt, kind, value)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	customerIds := make([]int64, 0)
+	for rows.Next() {
+		var customerId int64
+		err := rows.Scan(&customerId)
+		if err != nil {
+			return nil, err
+		}
+		customerIds = append(customerIds, customerId)
+	}
+	return customerIds, rows.Err()
+}
+
+//GetByCustomerId retrieves everything the customer has been seen using
+func (m *LinkModel) GetByCustomerId(customerId int64) ([]*models.Link, error) {
+	stmt := "SELECT kind, value, customer_id, first_seen FROM customer_links WHERE customer_id = $1 ORDER BY first_seen"
+
+	rows, err := m.DB.Query(context.Background(), stmt, customerId)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	links := make([]*models.Link, 0)
+	for rows.Next() {
+		link := &models.Link{}
+		err := rows.Scan(&link.Kind, &link.Value, &link.CustomerId, &link.FirstSeen)
+		if err != nil {
+			return nil, err
+		}
+		links = append(links, link)
+	}
+	return links, rows.Err()
+}
+
+//Insert records the link, keeping the first time it was seen if it already exists
+func (m *LinkModel) Insert(link *models.Link) error {
+	stmt := "INSERT INTO customer_links (kind, value, customer_id, first_seen) VALUES ($1, $2, $3, $4) ON CONFLICT (kind, value, customer_id) DO NOTHING"
+	_, err := m.DB.Exec(context.Background(), stmt, link.Kind, link.Value, link.CustomerId, link.FirstSeen)
+	return err
+}
@@ -3,19 +3,25 @@ package postgres
 import (
 	"context"
 	"database/sql"
+	"encoding/json"
 	"errors"
 	"fireynis/velocity_checker/pkg/models"
+	"github.com/jackc/pgconn"
 	"github.com/jackc/pgx/v4"
+	"github.com/jackc/pgx/v4/pgxpool"
 	"time"
 )
 
+//loadColumns is the column list every select on loads uses, in the order scanFields expects
+const loadColumns = "id, customer_id, transaction_id, load_amount, transaction_time, accepted, policy_version, risk_score, outcome, status, analyst, review_note, device_id, address, funding_source, funding_type, channel, merchant_id, ip_address, metadata, currency, original_amount, transaction_type, client_time, received_at, reason"
+
 type LoadModel struct {
-	DB *pgx.Conn
+	DB *pgxpool.Pool
 }
 
 //Get retrieves a load from the database based on its ID
 func (m *LoadModel) Get(id int64) (*models.Load, error) {
-	stmt := "SELECT id, customer_id, transaction_id, load_amount, transaction_time, accepted FROM loads WHERE id = $1"
+	stmt := "SELECT " + loadColumns + " FROM loads WHERE id = $1"
 	row := m.DB.QueryRow(context.Background(), stmt, id)
 	load, err := m.scanModel(row)
 	return load, err
@@ -23,25 +29,26 @@ func (m *LoadModel) Get(id int64) (*models.Load, error) {
 
 //GetByTransactionId finds the transaction based on the customer and id of the request.
 func (m *LoadModel) GetByTransactionId(customerId int64, transactionId int64) (*models.Load, error) {
-	stmt := "SELECT id, customer_id, transaction_id, load_amount, transaction_time, accepted FROM loads WHERE customer_id = $1 and transaction_id = $2"
+	stmt := "SELECT " + loadColumns + " FROM loads WHERE customer_id = $1 and transaction_id = $2"
 	row := m.DB.QueryRow(context.Background(), stmt, customerId, transactionId)
 	load, err := m.scanModel(row)
 	return load, err
 }
 
 func (m *LoadModel) GetByCustomerTransactionsByDateRange(customerId int64, startDate time.Time, endDate time.Time) ([]*models.Load, error) {
-	stmt := "SELECT id, customer_id, transaction_id, load_amount, transaction_time, accepted FROM loads WHERE customer_id = $1 and transaction_time >= $2 and transaction_time <= $3"
+	stmt := "SELECT " + loadColumns + " FROM loads WHERE customer_id = $1 and transaction_time >= $2 and transaction_time <= $3"
 
 	rows, err := m.DB.Query(context.Background(), stmt, customerId, startDate, endDate)
 	if err != nil {
 		return nil, err
 	}
+	defer rows.Close()
 
 	loadModels := make([]*models.Load, 0)
 
 	for rows.Next() {
 		var tempModel models.Load
-		err := rows.Scan(&tempModel.Id, &tempModel.CustomerId, &tempModel.TransactionId, &tempModel.Amount, &tempModel.Time, &tempModel.Accepted)
+		err := rows.Scan(scanFields(&tempModel)...)
 		if err != nil {
 			if errors.Is(err, pgx.ErrNoRows) {
 				return nil, models.ErrNoRecord
@@ -51,15 +58,104 @@ func (m *LoadModel) GetByCustomerTransactionsByDateRange(customerId int64, start
 		}
 		loadModels = append(loadModels, &tempModel)
 	}
-	return loadModels, nil
+	return loadModels, rows.Err()
+}
+
+//GetByCustomersByDateRange retrieves the loads of several customers timed within the range, oldest first
+func (m *LoadModel) GetByCustomersByDateRange(customerIds []int64, startDate time.Time, endDate time.Time) ([]*models.Load, error) {
+	stmt := "SELECT " + loadColumns + " FROM loads WHERE customer_id = ANY($1) and transaction_time >= $2 and transaction_time <= $3 ORDER BY transaction_time, id"
+
+	rows, err := m.DB.Query(context.Background(), stmt, customerIds, startDate, endDate)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	loadModels := make([]*models.Load, 0)
+	for rows.Next() {
+		var tempModel models.Load
+		err := rows.Scan(scanFields(&tempModel)...)
+		if err != nil {
+			return nil, err
+		}
+		loadModels = append(loadModels, &tempModel)
+	}
+	return loadModels, rows.Err()
+}
+
+//GetByDateRange retrieves every customer's loads timed within the range, oldest first
+func (m *LoadModel) GetByDateRange(startDate time.Time, endDate time.Time) ([]*models.Load, error) {
+	stmt := "SELECT " + loadColumns + " FROM loads WHERE transaction_time >= $1 and transaction_time <= $2 ORDER BY transaction_time, id"
+
+	rows, err := m.DB.Query(context.Background(), stmt, startDate, endDate)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	loadModels := make([]*models.Load, 0)
+	for rows.Next() {
+		var tempModel models.Load
+		err := rows.Scan(scanFields(&tempModel)...)
+		if err != nil {
+			return nil, err
+		}
+		loadModels = append(loadModels, &tempModel)
+	}
+	return loadModels, rows.Err()
+}
+
+//GetByStatus retrieves every load in the given status, oldest first
+func (m *LoadModel) GetByStatus(status string) ([]*models.Load, error) {
+	stmt := "SELECT " + loadColumns + " FROM loads WHERE status = $1 ORDER BY transaction_time, id"
+
+	rows, err := m.DB.Query(context.Background(), stmt, status)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	loadModels := make([]*models.Load, 0)
+	for rows.Next() {
+		var tempModel models.Load
+		err := rows.Scan(scanFields(&tempModel)...)
+		if err != nil {
+			return nil, err
+		}
+		loadModels = append(loadModels, &tempModel)
+	}
+	return loadModels, rows.Err()
 }
 
 //Insert saves the record to the database
 func (m *LoadModel) Insert(load *models.Load) (int64, error) {
-	stmt := "INSERT INTO loads (customer_id, transaction_id, load_amount, transaction_time, accepted) VALUES ($1, $2, $3, $4, $5) RETURNING id"
+	return insertLoad(context.Background(), m.DB, load)
+}
+
+//uniqueViolation is the postgres error code for an insert that breaks a unique index
+const uniqueViolation = "23505"
+
+//querier is what the pool and a transaction have in common, so a load can be inserted inside a transaction
+type querier interface {
+	QueryRow(ctx context.Context, sql string, args ...interface{}) pgx.Row
+}
+
+func insertLoad(ctx context.Context, db querier, load *models.Load) (int64, error) {
+	stmt := "INSERT INTO loads (customer_id, transaction_id, load_amount, transaction_time, accepted, policy_version, risk_score, outcome, status, analyst, review_note, device_id, address, funding_source, funding_type, channel, merchant_id, ip_address, metadata, currency, original_amount, transaction_type, client_time, received_at, reason) VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12, $13, $14, $15, $16, $17, $18, $19, $20, $21, $22, $23, $24, $25) RETURNING id"
+	metadata, err := metadataJson(load)
+	if err != nil {
+		return 0, err
+	}
+
 	var lastInsertId int64
-	err := m.DB.QueryRow(context.Background(), stmt, load.CustomerId, load.TransactionId, load.Amount, load.Time, load.Accepted).Scan(&lastInsertId)
+	err = db.QueryRow(ctx, stmt, load.CustomerId, load.TransactionId, load.Amount, load.Time, load.Accepted, load.PolicyVersion,
+		load.RiskScore, load.Outcome, load.Status, load.Analyst, load.ReviewNote, load.DeviceId, load.Address, load.FundingSource,
+		load.FundingType, load.Channel, load.MerchantId, load.IpAddress, metadata, load.Currency, load.OriginalAmount, load.Type, load.ClientTime, load.ReceivedAt, load.Reason).Scan(&lastInsertId)
 	if err != nil {
+		var pgErr *pgconn.PgError
+		if errors.As(err, &pgErr) && pgErr.Code == uniqueViolation && pgErr.ConstraintName == "loads_customer_transaction_idx" {
+			return 0, models.ErrDuplicate
+		}
 		return 0, err
 	}
 	load.Id = lastInsertId
@@ -67,13 +163,33 @@ func (m *LoadModel) Insert(load *models.Load) (int64, error) {
 }
 
 func (m *LoadModel) Update(model *models.Load) error {
-	stmt := "UPDATE loads SET customer_id = $1, transaction_id = $2, load_amount = $3, transaction_time = $4, accepted = $5 WHERE id = $6"
+	stmt := "UPDATE loads SET customer_id = $1, transaction_id = $2, load_amount = $3, transaction_time = $4, accepted = $5, policy_version = $6, risk_score = $7, outcome = $8, status = $9, analyst = $10, review_note = $11, device_id = $12, address = $13, funding_source = $14, funding_type = $15, channel = $16, merchant_id = $17, ip_address = $18, metadata = $19, currency = $20, original_amount = $21, transaction_type = $22, client_time = $23, received_at = $24, reason = $25 WHERE id = $26"
+	metadata, err := metadataJson(model)
+	if err != nil {
+		return err
+	}
 
 	//Using Exec as I don't need to know anything other than if it works, which the Error will determine
-	_, err := m.DB.Exec(context.Background(), stmt, model.CustomerId, model.TransactionId, model.Amount, model.Time, model.Accepted, model.Id)
+	_, err = m.DB.Exec(context.Background(), stmt, model.CustomerId, model.TransactionId, model.Amount, model.Time, model.Accepted, model.PolicyVersion,
+		model.RiskScore, model.Outcome, model.Status, model.Analyst, model.ReviewNote, model.DeviceId, model.Address, model.FundingSource,
+		model.FundingType, model.Channel, model.MerchantId, model.IpAddress, metadata, model.Currency, model.OriginalAmount, model.Type, model.ClientTime, model.ReceivedAt, model.Reason, model.Id)
 	return err
 }
 
+//Claim assigns a load pending review to the analyst in a single statement, so of two analysts claiming it at once only
+//one gets it. models.ErrChanged is returned when the load isn't pending review or another analyst already holds it.
+func (m *LoadModel) Claim(id int64, analyst string) error {
+	stmt := "UPDATE loads SET analyst = $1 WHERE id = $2 AND status = $3 AND (analyst = '' OR analyst = $1)"
+	tag, err := m.DB.Exec(context.Background(), stmt, analyst, id, models.StatusPendingReview)
+	if err != nil {
+		return err
+	}
+	if tag.RowsAffected() < 1 {
+		return models.ErrChanged
+	}
+	return nil
+}
+
 func (m *LoadModel) hasExceededWeeklyLoadLimit(customerId int64, transactionTime time.Time, transactionAmount int64) bool {
 	startTime := time.Date(transactionTime.Year(), transactionTime.Month(), transactionTime.Day(), 0, 0, 0, 0, time.UTC)
 	for startTime.Weekday() != time.Monday {
@@ -104,7 +220,7 @@ func (m *LoadModel) hasExceededWeeklyLoadLimit(customerId int64, transactionTime
 //scanModel is a helper function to scan a row into a load struct.
 func (m LoadModel) scanModel(row pgx.Row) (*models.Load, error) {
 	load := &models.Load{}
-	err := row.Scan(&load.Id, &load.CustomerId, &load.TransactionId, &load.Amount, &load.Time, &load.Accepted)
+	err := row.Scan(scanFields(load)...)
 	if err != nil {
 		if errors.Is(err, pgx.ErrNoRows) {
 			return nil, models.ErrNoRecord
@@ -114,3 +230,18 @@ func (m LoadModel) scanModel(row pgx.Row) (*models.Load, error) {
 	}
 	return load, nil
 }
+
+//scanFields lists the destinations for loadColumns
+func scanFields(load *models.Load) []interface{} {
+	return []interface{}{&load.Id, &load.CustomerId, &load.TransactionId, &load.Amount, &load.Time, &load.Accepted, &load.PolicyVersion,
+		&load.RiskScore, &load.Outcome, &load.Status, &load.Analyst, &load.ReviewNote, &load.DeviceId, &load.Address, &load.FundingSource,
+		&load.FundingType, &load.Channel, &load.MerchantId, &load.IpAddress, &load.Metadata, &load.Currency, &load.OriginalAmount, &load.Type, &load.ClientTime, &load.ReceivedAt, &load.Reason}
+}
+
+//metadataJson encodes the load's metadata for the jsonb column, which holds an empty object rather than null
+func metadataJson(load *models.Load) ([]byte, error) {
+	if len(load.Metadata) == 0 {
+		return []byte("{}"), nil
+	}
+	return json.Marshal(load.Metadata)
+}
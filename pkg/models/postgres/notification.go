@@ -0,0 +1,31 @@
+package postgres
+
+import (
+	"context"
+	"errors"
+	"fireynis/velocity_checker/pkg/models"
+	"github.com/jackc/pgx/v4"
+	"github.com/jackc/pgx/v4/pgxpool"
+)
+
+type NotificationModel struct {
+	DB *pgxpool.Pool
+}
+
+//Insert stores the notification unless it has already been sent for the customer, limit, window and threshold, in
+//which case its Id is left at 0
+func (m *NotificationModel) Insert(notification *models.Notification) error {
+	stmt := "INSERT INTO notifications (customer_id, transaction_id, limit_name, window_name, window_start, window_end, threshold, used, max) VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9) ON CONFLICT (customer_id, limit_name, window_start, threshold) DO NOTHING RETURNING id, created_at"
+	err := m.DB.QueryRow(context.Background(), stmt, notification.CustomerId, notification.TransactionId, notification.Limit, notification.Window, notification.WindowStart, notification.WindowEnd, notification.Threshold, notification.Used, notification.Max).Scan(&notification.Id, &notification.CreatedAt)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return nil
+	}
+	return err
+}
+
+//Delete removes a notification that couldn't be sent, so that it can be sent again
+func (m *NotificationModel) Delete(id int64) error {
+	stmt := "DELETE FROM notifications WHERE id = $1"
+	_, err := m.DB.Exec(context.Background(), stmt, id)
+	return err
+}
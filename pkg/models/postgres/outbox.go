@@ -0,0 +1,41 @@
+package postgres
+
+import (
+	"context"
+	"fireynis/velocity_checker/pkg/models"
+	"github.com/jackc/pgx/v4/pgxpool"
+	"time"
+)
+
+type OutboxModel struct {
+	DB *pgxpool.Pool
+}
+
+//GetUnrelayed retrieves up to limit events that haven't been relayed yet, in the order they were written
+func (m *OutboxModel) GetUnrelayed(limit int) ([]*models.OutboxEvent, error) {
+	stmt := "SELECT id, key, event, payload, created_at FROM outbox WHERE relayed_at IS NULL ORDER BY id LIMIT $1"
+
+	rows, err := m.DB.Query(context.Background(), stmt, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	events := make([]*models.OutboxEvent, 0)
+	for rows.Next() {
+		event := &models.OutboxEvent{}
+		var payload []byte
+		err := rows.Scan(&event.Id, &event.Key, &event.Event, &payload, &event.CreatedAt)
+		if err != nil {
+			return nil, err
+		}
+		event.Payload = payload
+		events = append(events, event)
+	}
+	return events, rows.Err()
+}
+
+func (m *OutboxModel) MarkRelayed(id int64) error {
+	_, err := m.DB.Exec(context.Background(), "UPDATE outbox SET relayed_at = $1 WHERE id = $2", time.Now(), id)
+	return err
+}
@@ -0,0 +1,84 @@
+package postgres
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fireynis/velocity_checker/pkg/models"
+	"github.com/jackc/pgx/v4"
+	"github.com/jackc/pgx/v4/pgxpool"
+	"time"
+)
+
+type PolicyModel struct {
+	DB *pgxpool.Pool
+}
+
+//policySettings holds the parts of a policy other than its limits
+type policySettings struct {
+	Risk        *models.RiskConfig        `json:"risk,omitempty"`
+	Structuring *models.StructuringConfig `json:"structuring,omitempty"`
+	Anomaly     *models.AnomalyConfig     `json:"anomaly,omitempty"`
+	CoolingOff  *models.CoolingOffConfig  `json:"cooling_off,omitempty"`
+}
+
+//Get retrieves a specific policy version
+func (m *PolicyModel) Get(version int64) (*models.Policy, error) {
+	stmt := "SELECT version, effective_from, limits, settings FROM policies WHERE version = $1"
+	return m.scanPolicy(m.DB.QueryRow(context.Background(), stmt, version))
+}
+
+//GetEffective finds the policy in force at the given time. When two versions share an effective time the later
+//version wins.
+func (m *PolicyModel) GetEffective(at time.Time) (*models.Policy, error) {
+	stmt := "SELECT version, effective_from, limits, settings FROM policies WHERE effective_from <= $1 ORDER BY effective_from DESC, version DESC LIMIT 1"
+	return m.scanPolicy(m.DB.QueryRow(context.Background(), stmt, at))
+}
+
+//Insert stores the policy as the next version number, which is set on the policy and returned
+func (m *PolicyModel) Insert(policy *models.Policy) (int64, error) {
+	stmt := "INSERT INTO policies (version, effective_from, limits, settings) SELECT COALESCE(MAX(version), 0) + 1, $1, $2, $3 FROM policies RETURNING version"
+	limits, err := json.Marshal(policy.Limits)
+	if err != nil {
+		return 0, err
+	}
+	settings, err := json.Marshal(policySettings{Risk: policy.Risk, Structuring: policy.Structuring, Anomaly: policy.Anomaly, CoolingOff: policy.CoolingOff})
+	if err != nil {
+		return 0, err
+	}
+
+	err = m.DB.QueryRow(context.Background(), stmt, policy.EffectiveFrom, limits, settings).Scan(&policy.Version)
+	if err != nil {
+		return 0, err
+	}
+	return policy.Version, nil
+}
+
+func (m *PolicyModel) scanPolicy(row pgx.Row) (*models.Policy, error) {
+	policy := &models.Policy{}
+	var limits, settings []byte
+	err := row.Scan(&policy.Version, &policy.EffectiveFrom, &limits, &settings)
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return nil, models.ErrNoRecord
+		} else {
+			return nil, err
+		}
+	}
+
+	err = json.Unmarshal(limits, &policy.Limits)
+	if err != nil {
+		return nil, err
+	}
+
+	var extra policySettings
+	err = json.Unmarshal(settings, &extra)
+	if err != nil {
+		return nil, err
+	}
+	policy.Risk = extra.Risk
+	policy.Structuring = extra.Structuring
+	policy.Anomaly = extra.Anomaly
+	policy.CoolingOff = extra.CoolingOff
+	return policy, nil
+}
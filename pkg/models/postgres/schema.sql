@@ -0,0 +1,214 @@
+-- Schema for the postgres models. Every statement is safe to re-run against an existing database.
+
+CREATE TABLE IF NOT EXISTS loads (
+    id               BIGSERIAL PRIMARY KEY,
+    customer_id      BIGINT      NOT NULL,
+    transaction_id   BIGINT      NOT NULL,
+    load_amount      BIGINT      NOT NULL,
+    transaction_time TIMESTAMPTZ NOT NULL,
+    accepted         BOOLEAN     NOT NULL
+);
+
+ALTER TABLE loads ADD COLUMN IF NOT EXISTS policy_version BIGINT NOT NULL DEFAULT 0;
+ALTER TABLE loads ADD COLUMN IF NOT EXISTS risk_score BIGINT NOT NULL DEFAULT 0;
+ALTER TABLE loads ADD COLUMN IF NOT EXISTS outcome TEXT NOT NULL DEFAULT '';
+ALTER TABLE loads ADD COLUMN IF NOT EXISTS status TEXT NOT NULL DEFAULT 'decided';
+ALTER TABLE loads ADD COLUMN IF NOT EXISTS analyst TEXT NOT NULL DEFAULT '';
+ALTER TABLE loads ADD COLUMN IF NOT EXISTS review_note TEXT NOT NULL DEFAULT '';
+
+-- Loads decided before outcome existed are given the one their accepted flag stands for, so rechecks don't flag them as
+-- changed and cooling-off counts their declines
+UPDATE loads SET outcome = CASE WHEN accepted THEN 'approve' ELSE 'decline' END WHERE outcome = '';
+
+CREATE INDEX IF NOT EXISTS loads_customer_time_idx ON loads (customer_id, transaction_time);
+CREATE INDEX IF NOT EXISTS loads_pending_review_idx ON loads (status) WHERE status = 'pending_review';
+
+CREATE TABLE IF NOT EXISTS decision_traces (
+    load_id    BIGINT PRIMARY KEY REFERENCES loads (id),
+    trace      JSONB       NOT NULL,
+    created_at TIMESTAMPTZ NOT NULL DEFAULT now()
+);
+
+CREATE TABLE IF NOT EXISTS audit_log (
+    id             BIGSERIAL PRIMARY KEY,
+    event          TEXT        NOT NULL,
+    load_id        BIGINT      NOT NULL,
+    customer_id    BIGINT      NOT NULL,
+    transaction_id BIGINT      NOT NULL,
+    load_amount    BIGINT      NOT NULL,
+    accepted       BOOLEAN     NOT NULL,
+    actor          TEXT        NOT NULL,
+    note           TEXT        NOT NULL,
+    created_at     TIMESTAMPTZ NOT NULL,
+    prev_hash      TEXT        NOT NULL,
+    hash           TEXT        NOT NULL UNIQUE
+);
+
+-- The audit log is append only. The hash chain makes tampering evident, this makes it awkward as well.
+CREATE OR REPLACE FUNCTION audit_log_append_only() RETURNS trigger AS $$
+BEGIN
+    RAISE EXCEPTION 'audit_log is append only';
+END;
+$$ LANGUAGE plpgsql;
+
+DROP TRIGGER IF EXISTS audit_log_append_only ON audit_log;
+CREATE TRIGGER audit_log_append_only BEFORE UPDATE OR DELETE ON audit_log
+    FOR EACH ROW EXECUTE PROCEDURE audit_log_append_only();
+
+DROP TRIGGER IF EXISTS audit_log_no_truncate ON audit_log;
+CREATE TRIGGER audit_log_no_truncate BEFORE TRUNCATE ON audit_log
+    FOR EACH STATEMENT EXECUTE PROCEDURE audit_log_append_only();
+
+CREATE TABLE IF NOT EXISTS policies (
+    version        BIGINT PRIMARY KEY,
+    effective_from TIMESTAMPTZ NOT NULL,
+    limits         JSONB       NOT NULL,
+    created_at     TIMESTAMPTZ NOT NULL DEFAULT now()
+);
+
+CREATE INDEX IF NOT EXISTS policies_effective_from_idx ON policies (effective_from);
+
+-- Risk scoring and structuring detection settings
+ALTER TABLE policies ADD COLUMN IF NOT EXISTS settings JSONB NOT NULL DEFAULT '{}';
+
+-- A pattern is only alerted on once for the customer and the day it completed on
+CREATE TABLE IF NOT EXISTS alerts (
+    id              BIGSERIAL PRIMARY KEY,
+    customer_id     BIGINT      NOT NULL,
+    pattern         TEXT        NOT NULL,
+    window_start    TIMESTAMPTZ NOT NULL,
+    window_end      TIMESTAMPTZ NOT NULL,
+    transaction_ids BIGINT[]    NOT NULL,
+    detail          TEXT        NOT NULL,
+    created_at      TIMESTAMPTZ NOT NULL DEFAULT now(),
+    UNIQUE (customer_id, pattern, window_end)
+);
+
+CREATE INDEX IF NOT EXISTS alerts_window_end_idx ON alerts (window_end);
+
+-- Where a load came from, used to link customers
+ALTER TABLE loads ADD COLUMN IF NOT EXISTS device_id TEXT NOT NULL DEFAULT '';
+ALTER TABLE loads ADD COLUMN IF NOT EXISTS address TEXT NOT NULL DEFAULT '';
+ALTER TABLE loads ADD COLUMN IF NOT EXISTS funding_source TEXT NOT NULL DEFAULT '';
+
+CREATE TABLE IF NOT EXISTS customer_links (
+    kind        TEXT        NOT NULL,
+    value       TEXT        NOT NULL,
+    customer_id BIGINT      NOT NULL,
+    first_seen  TIMESTAMPTZ NOT NULL,
+    PRIMARY KEY (kind, value, customer_id)
+);
+
+CREATE INDEX IF NOT EXISTS customer_links_customer_idx ON customer_links (customer_id);
+
+-- Optional details of a load that limit expressions can read
+ALTER TABLE loads ADD COLUMN IF NOT EXISTS funding_type TEXT NOT NULL DEFAULT '';
+ALTER TABLE loads ADD COLUMN IF NOT EXISTS channel TEXT NOT NULL DEFAULT '';
+ALTER TABLE loads ADD COLUMN IF NOT EXISTS merchant_id TEXT NOT NULL DEFAULT '';
+ALTER TABLE loads ADD COLUMN IF NOT EXISTS ip_address TEXT NOT NULL DEFAULT '';
+ALTER TABLE loads ADD COLUMN IF NOT EXISTS metadata JSONB NOT NULL DEFAULT '{}';
+
+-- load_amount is in the base currency, original_amount in the minor units of currency. Loads stored before currencies
+-- were dollars.
+ALTER TABLE loads ADD COLUMN IF NOT EXISTS currency TEXT NOT NULL DEFAULT 'USD';
+ALTER TABLE loads ADD COLUMN IF NOT EXISTS original_amount BIGINT;
+UPDATE loads SET original_amount = load_amount WHERE original_amount IS NULL;
+ALTER TABLE loads ALTER COLUMN original_amount SET NOT NULL;
+
+-- Debits take away from the balance the way loads add to it. Everything stored before debits existed is a load.
+ALTER TABLE loads ADD COLUMN IF NOT EXISTS transaction_type TEXT NOT NULL DEFAULT 'load';
+
+-- The running balance of each customer's accepted loads less their accepted debits, seeded from the loads already stored
+CREATE TABLE IF NOT EXISTS balances (
+    customer_id BIGINT PRIMARY KEY,
+    balance     BIGINT      NOT NULL,
+    updated_at  TIMESTAMPTZ NOT NULL DEFAULT now()
+);
+
+INSERT INTO balances (customer_id, balance)
+SELECT customer_id, SUM(CASE WHEN transaction_type = 'debit' THEN -load_amount ELSE load_amount END)
+FROM loads
+WHERE accepted
+GROUP BY customer_id
+ON CONFLICT (customer_id) DO NOTHING;
+
+-- transaction_time is the time a load is evaluated on, the client's time or when it was received. Loads stored before
+-- both were kept were evaluated on the client's time.
+ALTER TABLE loads ADD COLUMN IF NOT EXISTS client_time TIMESTAMPTZ;
+ALTER TABLE loads ADD COLUMN IF NOT EXISTS received_at TIMESTAMPTZ;
+UPDATE loads SET client_time = transaction_time WHERE client_time IS NULL;
+UPDATE loads SET received_at = transaction_time WHERE received_at IS NULL;
+ALTER TABLE loads ALTER COLUMN client_time SET NOT NULL;
+ALTER TABLE loads ALTER COLUMN received_at SET NOT NULL;
+
+-- Decisions that would have gone the other way had a late load arrived in time
+CREATE TABLE IF NOT EXISTS corrections (
+    id                  BIGSERIAL PRIMARY KEY,
+    customer_id         BIGINT      NOT NULL,
+    transaction_id      BIGINT      NOT NULL,
+    was_outcome         TEXT        NOT NULL,
+    outcome             TEXT        NOT NULL,
+    failed_rules        TEXT[]      NOT NULL,
+    late_customer_id    BIGINT      NOT NULL,
+    late_transaction_id BIGINT      NOT NULL,
+    created_at          TIMESTAMPTZ NOT NULL DEFAULT now(),
+    UNIQUE (customer_id, transaction_id, late_customer_id, late_transaction_id)
+);
+
+CREATE INDEX IF NOT EXISTS corrections_created_at_idx ON corrections (created_at);
+
+-- Why a load was declined without being checked against the limits, e.g. COOLING_OFF
+ALTER TABLE loads ADD COLUMN IF NOT EXISTS reason TEXT NOT NULL DEFAULT '';
+
+-- Approaching-limit notifications, sent once per customer, limit, window and threshold
+CREATE TABLE IF NOT EXISTS notifications (
+    id             BIGSERIAL PRIMARY KEY,
+    customer_id    BIGINT      NOT NULL,
+    transaction_id BIGINT      NOT NULL,
+    limit_name     TEXT        NOT NULL,
+    window_name    TEXT        NOT NULL,
+    window_start   TIMESTAMPTZ NOT NULL,
+    window_end     TIMESTAMPTZ NOT NULL,
+    threshold      BIGINT      NOT NULL,
+    used           BIGINT      NOT NULL,
+    max            BIGINT      NOT NULL,
+    created_at     TIMESTAMPTZ NOT NULL DEFAULT now(),
+    UNIQUE (customer_id, limit_name, window_start, threshold)
+);
+
+-- Outbound webhook deliveries, one per event and subscriber, kept so retries survive a restart
+CREATE TABLE IF NOT EXISTS webhook_deliveries (
+    id            BIGSERIAL PRIMARY KEY,
+    url           TEXT        NOT NULL,
+    event         TEXT        NOT NULL,
+    payload       JSONB       NOT NULL,
+    status        TEXT        NOT NULL,
+    attempts      INT         NOT NULL DEFAULT 0,
+    next_attempt  TIMESTAMPTZ NOT NULL,
+    response_code INT         NOT NULL DEFAULT 0,
+    last_error    TEXT        NOT NULL DEFAULT '',
+    created_at    TIMESTAMPTZ NOT NULL DEFAULT now(),
+    updated_at    TIMESTAMPTZ NOT NULL DEFAULT now()
+);
+
+CREATE INDEX IF NOT EXISTS webhook_deliveries_due_idx ON webhook_deliveries (status, next_attempt);
+
+-- Decision events written in the same transaction as the load, relayed to the outbox sinks in id order
+CREATE TABLE IF NOT EXISTS outbox (
+    id         BIGSERIAL PRIMARY KEY,
+    key        TEXT        NOT NULL UNIQUE,
+    event      TEXT        NOT NULL,
+    payload    JSONB       NOT NULL,
+    created_at TIMESTAMPTZ NOT NULL DEFAULT now(),
+    relayed_at TIMESTAMPTZ
+);
+
+CREATE INDEX IF NOT EXISTS outbox_unrelayed_idx ON outbox (id) WHERE relayed_at IS NULL;
+
+-- A customer's transaction id can only be stored once, so two writers racing past the duplicate check can't both insert
+-- it. Any duplicates stored before this index existed have to be removed before it can be built.
+CREATE UNIQUE INDEX IF NOT EXISTS loads_customer_transaction_idx ON loads (customer_id, transaction_id);
+
+-- The subscription a webhook delivery is for, so it is sent with that subscription's secret even when two share a url.
+-- Deliveries stored before this column existed are left blank and matched on their url.
+ALTER TABLE webhook_deliveries ADD COLUMN IF NOT EXISTS subscription_id TEXT NOT NULL DEFAULT '';
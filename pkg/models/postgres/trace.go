@@ -0,0 +1,76 @@
+package postgres
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fireynis/velocity_checker/pkg/models"
+	"github.com/jackc/pgx/v4"
+	"github.com/jackc/pgx/v4/pgxpool"
+	"time"
+)
+
+type TraceModel struct {
+	DB *pgxpool.Pool
+}
+
+//GetByLoadId retrieves the evaluation trace stored for a decided load
+func (m *TraceModel) GetByLoadId(loadId int64) ([]models.RuleTrace, error) {
+	stmt := "SELECT trace FROM decision_traces WHERE load_id = $1"
+	var raw []byte
+	err := m.DB.QueryRow(context.Background(), stmt, loadId).Scan(&raw)
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return nil, models.ErrNoRecord
+		} else {
+			return nil, err
+		}
+	}
+
+	var trace []models.RuleTrace
+	err = json.Unmarshal(raw, &trace)
+	if err != nil {
+		return nil, err
+	}
+	return trace, nil
+}
+
+//GetByDateRange retrieves every traced load timed within the range, oldest first
+func (m *TraceModel) GetByDateRange(startDate time.Time, endDate time.Time) ([]*models.TracedLoad, error) {
+	stmt := "SELECT " + loadColumns + ", trace FROM loads JOIN decision_traces ON decision_traces.load_id = loads.id WHERE transaction_time >= $1 and transaction_time <= $2 ORDER BY transaction_time, id"
+
+	rows, err := m.DB.Query(context.Background(), stmt, startDate, endDate)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	traced := make([]*models.TracedLoad, 0)
+	for rows.Next() {
+		var load models.Load
+		var raw []byte
+		err := rows.Scan(append(scanFields(&load), &raw)...)
+		if err != nil {
+			return nil, err
+		}
+
+		tracedLoad := &models.TracedLoad{Load: &load}
+		err = json.Unmarshal(raw, &tracedLoad.Trace)
+		if err != nil {
+			return nil, err
+		}
+		traced = append(traced, tracedLoad)
+	}
+	return traced, rows.Err()
+}
+
+//Insert saves the trace for a load. The trace is stored as jsonb since its shape follows the limits in force.
+func (m *TraceModel) Insert(loadId int64, trace []models.RuleTrace) error {
+	stmt := "INSERT INTO decision_traces (load_id, trace) VALUES ($1, $2)"
+	raw, err := json.Marshal(trace)
+	if err != nil {
+		return err
+	}
+	_, err = m.DB.Exec(context.Background(), stmt, loadId, raw)
+	return err
+}
@@ -0,0 +1,90 @@
+package notify
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fireynis/velocity_checker/pkg/models"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+//Thresholds are the percentages of a limit's max a customer is told about reaching
+var Thresholds = []int64{80, 100}
+
+//INotifier sends a notification on to wherever customers are told about them
+type INotifier interface {
+	Notify(notification *models.Notification) error
+}
+
+//Writer writes each notification to Out as a line of json. It is safe to share between goroutines.
+type Writer struct {
+	Out io.Writer
+	mu  sync.Mutex
+}
+
+func (n *Writer) Notify(notification *models.Notification) error {
+	line, err := json.Marshal(notification)
+	if err != nil {
+		return err
+	}
+
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	_, err = n.Out.Write(append(line, '\n'))
+	return err
+}
+
+//Webhook posts each notification as json to Url and fails on any response but a 2xx
+type Webhook struct {
+	Url    string
+	Client *http.Client
+}
+
+func (n *Webhook) Notify(notification *models.Notification) error {
+	body, err := json.Marshal(notification)
+	if err != nil {
+		return err
+	}
+
+	client := n.Client
+	if client == nil {
+		client = &http.Client{Timeout: 10 * time.Second}
+	}
+	response, err := client.Post(n.Url, "application/json", bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	defer response.Body.Close()
+	_, _ = io.Copy(ioutil.Discard, response.Body)
+
+	if response.StatusCode < 200 || response.StatusCode > 299 {
+		return errors.New(fmt.Sprintf("notify: %s responded %s", n.Url, response.Status))
+	}
+	return nil
+}
+
+//Open returns the notifier for a target, stdout, an http or https url for a webhook, or otherwise the path of a file
+//to append to. A blank target turns notifications off and returns nil.
+func Open(target string) (INotifier, error) {
+	switch {
+	case len(target) < 1:
+		return nil, nil
+	case target == "stdout":
+		return &Writer{Out: os.Stdout}, nil
+	case strings.HasPrefix(target, "http://") || strings.HasPrefix(target, "https://"):
+		return &Webhook{Url: target}, nil
+	}
+
+	file, err := os.OpenFile(target, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+	if err != nil {
+		return nil, err
+	}
+	return &Writer{Out: file}, nil
+}
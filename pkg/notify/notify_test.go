@@ -0,0 +1,69 @@
+package notify
+
+import (
+	"encoding/json"
+	"fireynis/velocity_checker/pkg/models"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"testing"
+)
+
+func TestWebhook_Notify(t *testing.T) {
+	tests := []struct {
+		name    string
+		status  int
+		wantErr bool
+	}{
+		{"Accepted", http.StatusOK, false},
+		{"No content", http.StatusNoContent, false},
+		{"Server error", http.StatusInternalServerError, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var received models.Notification
+			ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				_ = json.NewDecoder(r.Body).Decode(&received)
+				w.WriteHeader(tt.status)
+			}))
+			defer ts.Close()
+
+			webhook := &Webhook{Url: ts.URL, Client: ts.Client()}
+			err := webhook.Notify(&models.Notification{CustomerId: 1, Limit: "daily_load_amount", Threshold: 80})
+			if (err != nil) != tt.wantErr {
+				t.Errorf("Notify() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if received.Limit != "daily_load_amount" || received.Threshold != 80 {
+				t.Errorf("Notify() posted %+v", received)
+			}
+		})
+	}
+}
+
+func TestOpen(t *testing.T) {
+	tests := []struct {
+		name    string
+		target  string
+		wantNil bool
+		wantErr bool
+	}{
+		{"Off", "", true, false},
+		{"Stdout", "stdout", false, false},
+		{"Webhook", "https://example.com/notify", false, false},
+		{"File", filepath.Join(t.TempDir(), "notifications.jsonl"), false, false},
+		{"Missing directory", filepath.Join(t.TempDir(), "missing", "notifications.jsonl"), true, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			notifier, err := Open(tt.target)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("Open() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if (notifier == nil) != tt.wantNil {
+				t.Errorf("Open() = %v, want nil %v", notifier, tt.wantNil)
+			}
+		})
+	}
+}
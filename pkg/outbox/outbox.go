@@ -0,0 +1,193 @@
+package outbox
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fireynis/velocity_checker/pkg/audit"
+	"fireynis/velocity_checker/pkg/models"
+	"fireynis/velocity_checker/pkg/webhook"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+//HeaderKey carries an event's dedupe key to an HTTP sink
+const HeaderKey = "Idempotency-Key"
+
+//DefaultBatchSize is how many events a relay reads at a time when its BatchSize is left at zero
+const DefaultBatchSize = 100
+
+//DecisionEvent is the event written alongside a decided load. Its key is the customer and transaction, so a load can
+//only ever produce one decision event. The payload is the same as the decision webhook's.
+func DecisionEvent(load *models.Load) (*models.OutboxEvent, error) {
+	key := fmt.Sprintf("%s:%d:%d", audit.EventDecision, load.CustomerId, load.TransactionId)
+	return newEvent(audit.EventDecision, key, load, time.Now().UTC())
+}
+
+//OverrideEvent is the event written alongside an override. A load can be overridden more than once, so its key has the
+//time of the override after the customer and transaction.
+func OverrideEvent(load *models.Load) (*models.OutboxEvent, error) {
+	now := time.Now().UTC()
+	key := fmt.Sprintf("%s:%d:%d:%d", audit.EventOverride, load.CustomerId, load.TransactionId, now.UnixNano())
+	return newEvent(audit.EventOverride, key, load, now)
+}
+
+//AdjudicationEvent is the event written alongside a decision on a load sent for review. A load leaves review once, so
+//its key is the customer and transaction like a decision event's.
+func AdjudicationEvent(load *models.Load) (*models.OutboxEvent, error) {
+	key := fmt.Sprintf("%s:%d:%d", audit.EventAdjudication, load.CustomerId, load.TransactionId)
+	return newEvent(audit.EventAdjudication, key, load, time.Now().UTC())
+}
+
+func newEvent(event string, key string, load *models.Load, occurredAt time.Time) (*models.OutboxEvent, error) {
+	payload, err := json.Marshal(webhook.Payload{
+		Event:         event,
+		Id:            load.TransactionId,
+		CustomerId:    load.CustomerId,
+		Type:          load.Type,
+		Amount:        load.Amount,
+		Time:          load.Time,
+		Accepted:      load.Accepted,
+		Outcome:       load.Outcome,
+		Reason:        load.Reason,
+		Status:        load.Status,
+		RiskScore:     load.RiskScore,
+		PolicyVersion: load.PolicyVersion,
+		OccurredAt:    occurredAt,
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &models.OutboxEvent{
+		Key:     key,
+		Event:   event,
+		Payload: payload,
+	}, nil
+}
+
+//ISink takes events from the relay. An event is only marked relayed once every sink has taken it, so a sink can be
+//sent the same event more than once and should drop repeats by its key.
+type ISink interface {
+	Send(event *models.OutboxEvent) error
+}
+
+//Writer writes each event to Out as a line of json. It is safe to share between goroutines.
+type Writer struct {
+	Out io.Writer
+	mu  sync.Mutex
+}
+
+func (s *Writer) Send(event *models.OutboxEvent) error {
+	line, err := json.Marshal(event)
+	if err != nil {
+		return err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	_, err = s.Out.Write(append(line, '\n'))
+	return err
+}
+
+//HTTP posts each event's payload to Url with its key in the Idempotency-Key header, and fails on any response but a 2xx
+type HTTP struct {
+	Url    string
+	Client *http.Client
+}
+
+func (s *HTTP) Send(event *models.OutboxEvent) error {
+	request, err := http.NewRequest(http.MethodPost, s.Url, bytes.NewReader(event.Payload))
+	if err != nil {
+		return err
+	}
+	request.Header.Set("Content-Type", "application/json")
+	request.Header.Set(HeaderKey, event.Key)
+
+	client := s.Client
+	if client == nil {
+		client = &http.Client{Timeout: 10 * time.Second}
+	}
+	response, err := client.Do(request)
+	if err != nil {
+		return err
+	}
+	defer response.Body.Close()
+	_, _ = io.Copy(ioutil.Discard, response.Body)
+
+	if response.StatusCode < 200 || response.StatusCode > 299 {
+		return errors.New(fmt.Sprintf("outbox: %s responded %s", s.Url, response.Status))
+	}
+	return nil
+}
+
+//OpenSinks returns a sink for each comma separated target, stdout, an http or https url, or otherwise the path of a
+//file to append to
+func OpenSinks(targets string) ([]ISink, error) {
+	var sinks []ISink
+	for _, target := range strings.Split(targets, ",") {
+		target = strings.TrimSpace(target)
+		switch {
+		case len(target) < 1:
+			continue
+		case target == "stdout":
+			sinks = append(sinks, &Writer{Out: os.Stdout})
+		case strings.HasPrefix(target, "http://") || strings.HasPrefix(target, "https://"):
+			sinks = append(sinks, &HTTP{Url: target})
+		default:
+			file, err := os.OpenFile(target, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+			if err != nil {
+				return nil, err
+			}
+			sinks = append(sinks, &Writer{Out: file})
+		}
+	}
+	return sinks, nil
+}
+
+//Relay moves events from the outbox to the sinks in the order they were written. Delivery is at least once: an event
+//is marked relayed after every sink has taken it, so a failure or a crash in between sends it again next time.
+type Relay struct {
+	Outbox    models.IOutbox
+	Sinks     []ISink
+	BatchSize int
+}
+
+//RelayPending sends every unrelayed event, returning how many were relayed. It stops at the first event a sink fails
+//to take so that later events don't overtake it.
+func (r *Relay) RelayPending() (int, error) {
+	batchSize := r.BatchSize
+	if batchSize <= 0 {
+		batchSize = DefaultBatchSize
+	}
+
+	relayed := 0
+	for {
+		events, err := r.Outbox.GetUnrelayed(batchSize)
+		if err != nil {
+			return relayed, err
+		}
+		if len(events) < 1 {
+			return relayed, nil
+		}
+
+		for _, event := range events {
+			for _, sink := range r.Sinks {
+				err = sink.Send(event)
+				if err != nil {
+					return relayed, errors.New(fmt.Sprintf("outbox: unable to relay %s. %s", event.Key, err))
+				}
+			}
+			err = r.Outbox.MarkRelayed(event.Id)
+			if err != nil {
+				return relayed, err
+			}
+			relayed++
+		}
+	}
+}
@@ -0,0 +1,87 @@
+package outbox
+
+import (
+	"errors"
+	"fireynis/velocity_checker/pkg/models"
+	"fireynis/velocity_checker/pkg/models/mock"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+)
+
+//recordingSink keeps the keys it is sent and fails while failOn is set to one of them
+type recordingSink struct {
+	keys   []string
+	failOn string
+}
+
+func (s *recordingSink) Send(event *models.OutboxEvent) error {
+	if event.Key == s.failOn {
+		return errors.New("sink unavailable")
+	}
+	s.keys = append(s.keys, event.Key)
+	return nil
+}
+
+func TestRelay_RelayPending(t *testing.T) {
+	store := &mock.Outbox{}
+	for _, id := range []int64{1, 2, 3} {
+		load := &models.Load{CustomerId: 1, TransactionId: id, Amount: 100, Accepted: true, Outcome: models.OutcomeApprove}
+		event, err := DecisionEvent(load)
+		if err != nil {
+			t.Fatalf("DecisionEvent() unexpected error %v", err)
+		}
+		store.Insert(event)
+	}
+
+	first := &recordingSink{}
+	second := &recordingSink{failOn: "decision:1:2"}
+	relay := &Relay{Outbox: store, Sinks: []ISink{first, second}, BatchSize: 2}
+
+	//The second event fails on the second sink, so it and the event after it are held back
+	relayed, err := relay.RelayPending()
+	if err == nil || relayed != 1 {
+		t.Fatalf("RelayPending() = %d, %v, want 1 and an error", relayed, err)
+	}
+
+	second.failOn = ""
+	relayed, err = relay.RelayPending()
+	if err != nil || relayed != 2 {
+		t.Fatalf("RelayPending() = %d, %v, want 2", relayed, err)
+	}
+
+	//At least once, the first sink was sent the held back event again
+	if want := []string{"decision:1:1", "decision:1:2", "decision:1:2", "decision:1:3"}; !reflect.DeepEqual(first.keys, want) {
+		t.Errorf("first sink got %v, want %v", first.keys, want)
+	}
+	if want := []string{"decision:1:1", "decision:1:2", "decision:1:3"}; !reflect.DeepEqual(second.keys, want) {
+		t.Errorf("second sink got %v, want %v", second.keys, want)
+	}
+
+	relayed, err = relay.RelayPending()
+	if err != nil || relayed != 0 {
+		t.Errorf("RelayPending() = %d, %v, want nothing left to relay", relayed, err)
+	}
+}
+
+func TestHTTP_Send(t *testing.T) {
+	var gotKey string
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		gotKey = r.Header.Get(HeaderKey)
+		if gotKey == "decision:1:2" {
+			w.WriteHeader(http.StatusBadGateway)
+		}
+	}))
+	defer ts.Close()
+
+	sink := &HTTP{Url: ts.URL, Client: ts.Client()}
+	err := sink.Send(&models.OutboxEvent{Key: "decision:1:1", Payload: []byte(`{}`)})
+	if err != nil || gotKey != "decision:1:1" {
+		t.Errorf("Send() error %v with key %q", err, gotKey)
+	}
+	err = sink.Send(&models.OutboxEvent{Key: "decision:1:2", Payload: []byte(`{}`)})
+	if err == nil {
+		t.Errorf("Send() want an error on a 502")
+	}
+}
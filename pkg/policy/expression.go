@@ -0,0 +1,413 @@
+package policy
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"unicode"
+)
+
+//The expression language lets a limit be written as a boolean expression over the load being evaluated and aggregates
+//of the customer's stored loads, e.g.
+//
+//	sum(amount, window="24h", status="accepted") + load.amount <= 500000 && count(window="1h") < 2
+//
+//It only has integer, boolean and string values, arithmetic, comparison and logic operators, load fields and the
+//aggregate functions below. Strings can only be compared for equality, e.g. load.channel == "branch". There are no
+//variables, loops or side effects so an expression always terminates and can only read the loads it is given.
+
+const (
+	//maxExpressionLength and maxExpressionDepth bound the work done parsing an expression
+	maxExpressionLength = 4096
+	maxExpressionDepth  = 64
+
+	StatusAll      = "all"
+	StatusAccepted = "accepted"
+	StatusRejected = "rejected"
+)
+
+type exprType int
+
+const (
+	typeInt exprType = iota
+	typeBool
+	typeString
+)
+
+func (t exprType) String() string {
+	switch t {
+	case typeBool:
+		return "bool"
+	case typeString:
+		return "string"
+	}
+	return "int"
+}
+
+//loadFields are the fields of the load being evaluated, available as load.<name>. Metadata values are available as
+//load.metadata.<key> and are empty when the key isn't set.
+var loadFields = map[string]exprType{
+	"amount":         typeInt,
+	"hour":           typeInt,
+	"channel":        typeString,
+	"funding_source": typeString,
+	"funding_type":   typeString,
+	"merchant_id":    typeString,
+	"ip_address":     typeString,
+	"device_id":      typeString,
+}
+
+//aggregateFields are the fields of stored loads an aggregate function can be applied to
+var aggregateFields = map[string]bool{
+	"amount": true,
+}
+
+//distinctFields are the fields of stored loads distinct can count the values of
+var distinctFields = map[string]bool{
+	"funding_source": true,
+	"merchant_id":    true,
+	"ip_address":     true,
+	"device_id":      true,
+}
+
+//filterFields are the fields an aggregate can be narrowed to a single value of, as a named argument
+var filterFields = map[string]bool{
+	"channel":      true,
+	"funding_type": true,
+	"merchant_id":  true,
+}
+
+//functions lists the aggregate functions and whether they take a field as their first argument
+var functions = map[string]bool{
+	"count":    false,
+	"sum":      true,
+	"min":      true,
+	"max":      true,
+	"avg":      true,
+	"distinct": true,
+}
+
+//Expression is a parsed and type checked limit expression
+type Expression struct {
+	source string
+	root   node
+}
+
+//String returns the expression as it was written
+func (e *Expression) String() string {
+	return e.source
+}
+
+//Compile parses the expression and checks that it is a boolean expression every part of which is well typed
+func Compile(source string) (*Expression, error) {
+	if len(source) > maxExpressionLength {
+		return nil, errors.New(fmt.Sprintf("expression is longer than %d characters", maxExpressionLength))
+	}
+
+	tokens, err := lex(source)
+	if err != nil {
+		return nil, err
+	}
+
+	p := &parser{tokens: tokens}
+	root, err := p.parseExpression(0)
+	if err != nil {
+		return nil, err
+	}
+	if p.peek().kind != tokEOF {
+		return nil, p.errorf("unexpected %q", p.peek().text)
+	}
+
+	rootType, err := root.check()
+	if err != nil {
+		return nil, err
+	}
+	if rootType != typeBool {
+		return nil, errors.New("expression must evaluate to a bool")
+	}
+	return &Expression{source: source, root: root}, nil
+}
+
+type tokenKind int
+
+const (
+	tokEOF tokenKind = iota
+	tokInt
+	tokString
+	tokIdent
+	tokOperator
+)
+
+type token struct {
+	kind tokenKind
+	text string
+	pos  int
+}
+
+//operators is ordered so the two character operators are matched first
+var operators = []string{"&&", "||", "==", "!=", "<=", ">=", "<", ">", "+", "-", "*", "/", "%", "!", "(", ")", ",", ".", "="}
+
+func lex(source string) ([]token, error) {
+	var tokens []token
+	i := 0
+	for i < len(source) {
+		c := rune(source[i])
+		switch {
+		case unicode.IsSpace(c):
+			i++
+		case unicode.IsDigit(c):
+			start := i
+			for i < len(source) && unicode.IsDigit(rune(source[i])) {
+				i++
+			}
+			tokens = append(tokens, token{kind: tokInt, text: source[start:i], pos: start})
+		case unicode.IsLetter(c) || c == '_':
+			start := i
+			for i < len(source) && (unicode.IsLetter(rune(source[i])) || unicode.IsDigit(rune(source[i])) || source[i] == '_') {
+				i++
+			}
+			tokens = append(tokens, token{kind: tokIdent, text: source[start:i], pos: start})
+		case c == '"':
+			start := i
+			i++
+			for i < len(source) && source[i] != '"' {
+				i++
+			}
+			if i >= len(source) {
+				return nil, errors.New(fmt.Sprintf("unterminated string at %d", start))
+			}
+			i++
+			tokens = append(tokens, token{kind: tokString, text: source[start+1 : i-1], pos: start})
+		default:
+			matched := false
+			for _, op := range operators {
+				if strings.HasPrefix(source[i:], op) {
+					tokens = append(tokens, token{kind: tokOperator, text: op, pos: i})
+					i += len(op)
+					matched = true
+					break
+				}
+			}
+			if !matched {
+				return nil, errors.New(fmt.Sprintf("unexpected character %q at %d", c, i))
+			}
+		}
+	}
+	return append(tokens, token{kind: tokEOF, pos: len(source)}), nil
+}
+
+//precedence of the binary operators, higher binds tighter
+var precedence = map[string]int{
+	"||": 1,
+	"&&": 2,
+	"==": 3, "!=": 3, "<": 3, "<=": 3, ">": 3, ">=": 3,
+	"+": 4, "-": 4,
+	"*": 5, "/": 5, "%": 5,
+}
+
+type parser struct {
+	tokens []token
+	pos    int
+	depth  int
+}
+
+func (p *parser) peek() token {
+	return p.tokens[p.pos]
+}
+
+func (p *parser) next() token {
+	t := p.tokens[p.pos]
+	if t.kind != tokEOF {
+		p.pos++
+	}
+	return t
+}
+
+func (p *parser) isOperator(text string) bool {
+	t := p.peek()
+	return t.kind == tokOperator && t.text == text
+}
+
+func (p *parser) expect(text string) error {
+	if !p.isOperator(text) {
+		return p.errorf("expected %q", text)
+	}
+	p.next()
+	return nil
+}
+
+func (p *parser) errorf(format string, args ...interface{}) error {
+	return errors.New(fmt.Sprintf("%s at %d", fmt.Sprintf(format, args...), p.peek().pos))
+}
+
+//parseExpression parses binary operators binding tighter than minPrecedence by precedence climbing
+func (p *parser) parseExpression(minPrecedence int) (node, error) {
+	p.depth++
+	defer func() { p.depth-- }()
+	if p.depth > maxExpressionDepth {
+		return nil, p.errorf("expression is nested too deeply")
+	}
+
+	left, err := p.parseUnary()
+	if err != nil {
+		return nil, err
+	}
+
+	for {
+		t := p.peek()
+		prec, ok := precedence[t.text]
+		if t.kind != tokOperator || !ok || prec <= minPrecedence {
+			return left, nil
+		}
+		p.next()
+
+		right, err := p.parseExpression(prec)
+		if err != nil {
+			return nil, err
+		}
+		left = &binaryNode{op: t.text, left: left, right: right}
+	}
+}
+
+func (p *parser) parseUnary() (node, error) {
+	if p.isOperator("!") || p.isOperator("-") {
+		op := p.next().text
+		p.depth++
+		defer func() { p.depth-- }()
+		if p.depth > maxExpressionDepth {
+			return nil, p.errorf("expression is nested too deeply")
+		}
+
+		operand, err := p.parseUnary()
+		if err != nil {
+			return nil, err
+		}
+		return &unaryNode{op: op, operand: operand}, nil
+	}
+	return p.parsePrimary()
+}
+
+func (p *parser) parsePrimary() (node, error) {
+	t := p.next()
+	switch t.kind {
+	case tokInt:
+		value, err := strconv.ParseInt(t.text, 10, 64)
+		if err != nil {
+			return nil, errors.New(fmt.Sprintf("integer %s out of range at %d", t.text, t.pos))
+		}
+		return &intNode{value: value}, nil
+	case tokIdent:
+		switch t.text {
+		case "true", "false":
+			return &boolNode{value: t.text == "true"}, nil
+		case "load":
+			err := p.expect(".")
+			if err != nil {
+				return nil, err
+			}
+			name := p.next()
+			if name.kind == tokIdent && name.text == "metadata" {
+				err = p.expect(".")
+				if err != nil {
+					return nil, err
+				}
+				key := p.next()
+				if key.kind != tokIdent {
+					return nil, errors.New(fmt.Sprintf("expected a metadata key at %d", key.pos))
+				}
+				return &fieldNode{name: name.text, key: key.text, typ: typeString}, nil
+			}
+			fieldType, ok := loadFields[name.text]
+			if name.kind != tokIdent || !ok {
+				return nil, errors.New(fmt.Sprintf("unknown load field %q at %d", name.text, name.pos))
+			}
+			return &fieldNode{name: name.text, typ: fieldType}, nil
+		}
+		if _, ok := functions[t.text]; ok {
+			return p.parseCall(t)
+		}
+		return nil, errors.New(fmt.Sprintf("unknown identifier %q at %d", t.text, t.pos))
+	case tokOperator:
+		if t.text == "(" {
+			inner, err := p.parseExpression(0)
+			if err != nil {
+				return nil, err
+			}
+			return inner, p.expect(")")
+		}
+	case tokString:
+		return &stringNode{value: t.text}, nil
+	}
+	return nil, errors.New(fmt.Sprintf("unexpected %q at %d", t.text, t.pos))
+}
+
+//parseCall parses an aggregate call. Arguments are restricted to a bare field name followed by named string literals,
+//so every call can be resolved to a window and filter while parsing.
+func (p *parser) parseCall(name token) (node, error) {
+	call := &callNode{name: name.text, window: WindowDay, status: StatusAll, filters: make(map[string]string)}
+	err := p.expect("(")
+	if err != nil {
+		return nil, err
+	}
+
+	takesField := functions[name.text]
+	first := true
+	for !p.isOperator(")") {
+		if !first {
+			err = p.expect(",")
+			if err != nil {
+				return nil, err
+			}
+		}
+
+		arg := p.next()
+		if arg.kind != tokIdent {
+			return nil, errors.New(fmt.Sprintf("unexpected %q in arguments to %s at %d", arg.text, name.text, arg.pos))
+		}
+
+		if !p.isOperator("=") {
+			if !first || !takesField {
+				return nil, errors.New(fmt.Sprintf("unexpected argument %q to %s at %d", arg.text, name.text, arg.pos))
+			}
+			if (name.text == "distinct" && !distinctFields[arg.text]) || (name.text != "distinct" && !aggregateFields[arg.text]) {
+				return nil, errors.New(fmt.Sprintf("%s can't be applied to %q at %d", name.text, arg.text, arg.pos))
+			}
+			call.field = arg.text
+			first = false
+			continue
+		}
+		p.next()
+
+		value := p.next()
+		if value.kind != tokString {
+			return nil, errors.New(fmt.Sprintf("argument %s to %s must be a string at %d", arg.text, name.text, value.pos))
+		}
+		switch arg.text {
+		case "window":
+			err := validWindow(value.text)
+			if err != nil {
+				return nil, errors.New(fmt.Sprintf("%s at %d", err, value.pos))
+			}
+			call.window = value.text
+		case "status":
+			if value.text != StatusAll && value.text != StatusAccepted && value.text != StatusRejected {
+				return nil, errors.New(fmt.Sprintf("unknown status %q at %d", value.text, value.pos))
+			}
+			call.status = value.text
+		default:
+			if filterFields[arg.text] {
+				call.filters[arg.text] = value.text
+				break
+			}
+			return nil, errors.New(fmt.Sprintf("unknown argument %q to %s at %d", arg.text, name.text, arg.pos))
+		}
+		first = false
+	}
+	p.next()
+
+	if takesField && len(call.field) < 1 {
+		return nil, errors.New(fmt.Sprintf("%s needs a field as its first argument at %d", name.text, name.pos))
+	}
+	return call, nil
+}
@@ -0,0 +1,351 @@
+package policy
+
+import (
+	"errors"
+	"fireynis/velocity_checker/pkg/models"
+	"fmt"
+	"sort"
+	"strconv"
+)
+
+//ErrEval is wrapped around an error raised by the expression itself while it was evaluated, such as a division by zero,
+//to tell it apart from an error its Source returned
+var ErrEval = errors.New("policy: expression failed")
+
+//Source supplies an expression with the load being evaluated and the customer's stored loads in a window
+type Source interface {
+	Load() *models.Load
+	LoadsInWindow(window string) ([]*models.Load, error)
+}
+
+//Eval evaluates the expression. Alongside the result it returns the value of every aggregate call, keyed by the call as
+//written, so the decision can be explained.
+func (e *Expression) Eval(source Source) (bool, map[string]int64, error) {
+	ctx := &evalContext{source: source, values: make(map[string]int64)}
+	result, err := e.root.eval(ctx)
+	if err != nil {
+		return false, nil, err
+	}
+	return result.b, ctx.values, nil
+}
+
+//Windows lists the distinct windows the expression's aggregates read
+func (e *Expression) Windows() []string {
+	seen := make(map[string]bool)
+	var windows []string
+	e.root.walk(func(n node) {
+		call, ok := n.(*callNode)
+		if ok && !seen[call.window] {
+			seen[call.window] = true
+			windows = append(windows, call.window)
+		}
+	})
+	return windows
+}
+
+type evalContext struct {
+	source Source
+	values map[string]int64
+}
+
+type value struct {
+	i int64
+	b bool
+	s string
+}
+
+type node interface {
+	check() (exprType, error)
+	eval(ctx *evalContext) (value, error)
+	walk(fn func(n node))
+	String() string
+}
+
+type intNode struct {
+	value int64
+}
+
+func (n *intNode) check() (exprType, error)             { return typeInt, nil }
+func (n *intNode) eval(ctx *evalContext) (value, error) { return value{i: n.value}, nil }
+func (n *intNode) walk(fn func(n node))                 { fn(n) }
+func (n *intNode) String() string                       { return strconv.FormatInt(n.value, 10) }
+
+type boolNode struct {
+	value bool
+}
+
+func (n *boolNode) check() (exprType, error)             { return typeBool, nil }
+func (n *boolNode) eval(ctx *evalContext) (value, error) { return value{b: n.value}, nil }
+func (n *boolNode) walk(fn func(n node))                 { fn(n) }
+func (n *boolNode) String() string                       { return strconv.FormatBool(n.value) }
+
+type stringNode struct {
+	value string
+}
+
+func (n *stringNode) check() (exprType, error)             { return typeString, nil }
+func (n *stringNode) eval(ctx *evalContext) (value, error) { return value{s: n.value}, nil }
+func (n *stringNode) walk(fn func(n node))                 { fn(n) }
+func (n *stringNode) String() string                       { return strconv.Quote(n.value) }
+
+//fieldNode is a field of the load being evaluated. Key is set for a metadata value.
+type fieldNode struct {
+	name string
+	key  string
+	typ  exprType
+}
+
+func (n *fieldNode) check() (exprType, error) { return n.typ, nil }
+func (n *fieldNode) walk(fn func(n node))     { fn(n) }
+
+func (n *fieldNode) String() string {
+	if len(n.key) > 0 {
+		return "load." + n.name + "." + n.key
+	}
+	return "load." + n.name
+}
+
+func (n *fieldNode) eval(ctx *evalContext) (value, error) {
+	load := ctx.source.Load()
+	switch n.name {
+	case "amount":
+		return value{i: load.Amount}, nil
+	case "hour":
+		return value{i: int64(load.Time.UTC().Hour())}, nil
+	case "metadata":
+		return value{s: load.Metadata[n.key]}, nil
+	}
+	if n.typ == typeString {
+		return value{s: FieldValue(load, n.name)}, nil
+	}
+	return value{}, fmt.Errorf("%w. unknown load field %s", ErrEval, n.name)
+}
+
+type unaryNode struct {
+	op      string
+	operand node
+}
+
+func (n *unaryNode) walk(fn func(n node)) {
+	fn(n)
+	n.operand.walk(fn)
+}
+
+func (n *unaryNode) String() string {
+	return n.op + n.operand.String()
+}
+
+func (n *unaryNode) check() (exprType, error) {
+	operandType, err := n.operand.check()
+	if err != nil {
+		return 0, err
+	}
+	want := typeInt
+	if n.op == "!" {
+		want = typeBool
+	}
+	if operandType != want {
+		return 0, errors.New(fmt.Sprintf("%s needs a %s operand, %s is a %s", n.op, want, n.operand, operandType))
+	}
+	return want, nil
+}
+
+func (n *unaryNode) eval(ctx *evalContext) (value, error) {
+	operand, err := n.operand.eval(ctx)
+	if err != nil {
+		return value{}, err
+	}
+	if n.op == "!" {
+		return value{b: !operand.b}, nil
+	}
+	return value{i: -operand.i}, nil
+}
+
+type binaryNode struct {
+	op    string
+	left  node
+	right node
+}
+
+func (n *binaryNode) walk(fn func(n node)) {
+	fn(n)
+	n.left.walk(fn)
+	n.right.walk(fn)
+}
+
+func (n *binaryNode) String() string {
+	return "(" + n.left.String() + " " + n.op + " " + n.right.String() + ")"
+}
+
+func (n *binaryNode) check() (exprType, error) {
+	leftType, err := n.left.check()
+	if err != nil {
+		return 0, err
+	}
+	rightType, err := n.right.check()
+	if err != nil {
+		return 0, err
+	}
+
+	switch n.op {
+	case "&&", "||":
+		if leftType != typeBool || rightType != typeBool {
+			return 0, errors.New(fmt.Sprintf("%s needs bool operands in %s", n.op, n))
+		}
+		return typeBool, nil
+	case "==", "!=":
+		if leftType != rightType {
+			return 0, errors.New(fmt.Sprintf("can't compare %s with %s in %s", leftType, rightType, n))
+		}
+		return typeBool, nil
+	case "<", "<=", ">", ">=":
+		if leftType != typeInt || rightType != typeInt {
+			return 0, errors.New(fmt.Sprintf("%s needs int operands in %s", n.op, n))
+		}
+		return typeBool, nil
+	}
+
+	if leftType != typeInt || rightType != typeInt {
+		return 0, errors.New(fmt.Sprintf("%s needs int operands in %s", n.op, n))
+	}
+	return typeInt, nil
+}
+
+func (n *binaryNode) eval(ctx *evalContext) (value, error) {
+	left, err := n.left.eval(ctx)
+	if err != nil {
+		return value{}, err
+	}
+
+	//Short circuit the logic operators so aggregates that can't change the result aren't fetched
+	if n.op == "&&" && !left.b {
+		return value{b: false}, nil
+	}
+	if n.op == "||" && left.b {
+		return value{b: true}, nil
+	}
+
+	right, err := n.right.eval(ctx)
+	if err != nil {
+		return value{}, err
+	}
+
+	switch n.op {
+	case "&&", "||":
+		return value{b: right.b}, nil
+	case "==":
+		return value{b: left == right}, nil
+	case "!=":
+		return value{b: left != right}, nil
+	case "<":
+		return value{b: left.i < right.i}, nil
+	case "<=":
+		return value{b: left.i <= right.i}, nil
+	case ">":
+		return value{b: left.i > right.i}, nil
+	case ">=":
+		return value{b: left.i >= right.i}, nil
+	case "+":
+		return value{i: left.i + right.i}, nil
+	case "-":
+		return value{i: left.i - right.i}, nil
+	case "*":
+		return value{i: left.i * right.i}, nil
+	case "/", "%":
+		if right.i == 0 {
+			return value{}, fmt.Errorf("%w. division by zero in %s", ErrEval, n)
+		}
+		if n.op == "/" {
+			return value{i: left.i / right.i}, nil
+		}
+		return value{i: left.i % right.i}, nil
+	}
+	return value{}, fmt.Errorf("%w. unknown operator %s", ErrEval, n.op)
+}
+
+type callNode struct {
+	name    string
+	field   string
+	window  string
+	status  string
+	filters map[string]string
+}
+
+func (n *callNode) check() (exprType, error) { return typeInt, nil }
+func (n *callNode) walk(fn func(n node))     { fn(n) }
+
+func (n *callNode) String() string {
+	args := ""
+	if len(n.field) > 0 {
+		args = n.field + ", "
+	}
+	filters := ""
+	names := make([]string, 0, len(n.filters))
+	for name := range n.filters {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		filters += fmt.Sprintf(", %s=%q", name, n.filters[name])
+	}
+	return fmt.Sprintf("%s(%swindow=%q, status=%q%s)", n.name, args, n.window, n.status, filters)
+}
+
+//matches reports whether a stored load is one the aggregate covers
+func (n *callNode) matches(load *models.Load) bool {
+	if (n.status == StatusAccepted && !load.Accepted) || (n.status == StatusRejected && load.Accepted) {
+		return false
+	}
+	for name, want := range n.filters {
+		if FieldValue(load, name) != want {
+			return false
+		}
+	}
+	return true
+}
+
+func (n *callNode) eval(ctx *evalContext) (value, error) {
+	loads, err := ctx.source.LoadsInWindow(n.window)
+	if err != nil {
+		return value{}, err
+	}
+
+	var values []int64
+	distinct := make(map[string]bool)
+	for _, load := range loads {
+		if !n.matches(load) {
+			continue
+		}
+		values = append(values, load.Amount)
+		if n.name == "distinct" {
+			field := FieldValue(load, n.field)
+			if len(field) > 0 {
+				distinct[field] = true
+			}
+		}
+	}
+
+	var result int64
+	switch n.name {
+	case "distinct":
+		result = int64(len(distinct))
+	case "count":
+		result = int64(len(values))
+	case "sum", "avg":
+		for _, v := range values {
+			result += v
+		}
+		if n.name == "avg" && len(values) > 0 {
+			result /= int64(len(values))
+		}
+	case "min", "max":
+		for i, v := range values {
+			if i == 0 || (n.name == "min" && v < result) || (n.name == "max" && v > result) {
+				result = v
+			}
+		}
+	}
+
+	ctx.values[n.String()] = result
+	return value{i: result}, nil
+}
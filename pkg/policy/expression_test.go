@@ -0,0 +1,121 @@
+package policy
+
+import (
+	"errors"
+	"fireynis/velocity_checker/pkg/models"
+	"testing"
+	"time"
+)
+
+type testSource struct {
+	load    *models.Load
+	windows map[string][]*models.Load
+}
+
+func (s *testSource) Load() *models.Load {
+	return s.load
+}
+
+func (s *testSource) LoadsInWindow(window string) ([]*models.Load, error) {
+	return s.windows[window], nil
+}
+
+func TestCompile(t *testing.T) {
+	tests := []struct {
+		name    string
+		source  string
+		wantErr bool
+	}{
+		{"Example", `sum(amount, window="24h", status="accepted") + load.amount <= 500000 && count(window="1h") < 2`, false},
+		{"Default window", `count() < 3`, false},
+		{"Precedence", `1 + 2 * 3 == 7 || !(load.hour < 6)`, false},
+		{"Not a bool", `count() + 1`, true},
+		{"Int and bool", `count() < 3 && 4`, true},
+		{"Unknown function", `median(amount) < 3`, true},
+		{"Unknown field", `load.card < 3`, true},
+		{"Missing field", `sum(window="day") < 3`, true},
+		{"Field on count", `count(amount) < 3`, true},
+		{"Bad window", `count(window="fortnight") < 3`, true},
+		{"Bad status", `count(status="pending") < 3`, true},
+		{"Non string argument", `count(window=24) < 3`, true},
+		{"Ordering strings", `load.channel < "web"`, true},
+		{"String and int", `load.channel == 3`, true},
+		{"Channel", `load.channel == "branch" && sum(amount, channel="branch") + load.amount <= 1000000`, false},
+		{"Metadata", `load.metadata.segment != "vip" || count() < 10`, false},
+		{"Distinct cards", `distinct(funding_source, window="week", funding_type="card") < 3`, false},
+		{"Distinct amounts", `distinct(amount) < 3`, true},
+		{"Unknown filter", `count(ip_address="127.0.0.1") < 3`, true},
+		{"Trailing tokens", `count() < 3 3`, true},
+		{"Unterminated string", `count(window="day) < 3`, true},
+		{"Integer overflow", `count() < 99999999999999999999`, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := Compile(tt.source)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("Compile() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestExpressionEval(t *testing.T) {
+	source := &testSource{
+		load: &models.Load{Amount: 100000, Time: time.Date(2000, 1, 1, 3, 0, 0, 0, time.UTC), Channel: "branch", Metadata: map[string]string{"segment": "vip"}},
+		windows: map[string][]*models.Load{
+			"day": {
+				{Id: 1, Amount: 200000, Accepted: true, Channel: "branch", FundingType: "card", FundingSource: "card-a"},
+				{Id: 2, Amount: 400000, Accepted: false, Channel: "web", FundingType: "card", FundingSource: "card-b"},
+			},
+			"1h": {
+				{Id: 2, Amount: 400000, Accepted: false},
+			},
+		},
+	}
+
+	tests := []struct {
+		name   string
+		source string
+		want   bool
+	}{
+		{"Accepted sum under max", `sum(amount, status="accepted") + load.amount <= 300000`, true},
+		{"All sum over max", `sum(amount) + load.amount <= 300000`, false},
+		{"Rolling count", `count(window="1h") < 2`, true},
+		{"Rejected count", `count(status="rejected") == 1`, true},
+		{"Min max avg", `min(amount) == 200000 && max(amount) == 400000 && avg(amount) == 300000`, true},
+		{"Load hour", `load.hour >= 6`, false},
+		{"Arithmetic", `-load.amount / 1000 % 7 == -2`, true},
+		{"Channel", `load.channel == "branch" && sum(amount, channel="branch") == 200000`, true},
+		{"Metadata", `load.metadata.segment == "vip" && load.metadata.missing == ""`, true},
+		{"Distinct cards", `distinct(funding_source, funding_type="card") == 2`, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			expression, err := Compile(tt.source)
+			if err != nil {
+				t.Fatalf("Compile() unexpected error %v", err)
+			}
+			got, _, err := expression.Eval(source)
+			if err != nil {
+				t.Fatalf("Eval() unexpected error %v", err)
+			}
+			if got != tt.want {
+				t.Errorf("Eval() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+
+	expression, _ := Compile(`count(window="1h") < 2`)
+	_, values, _ := expression.Eval(source)
+	if values[`count(window="1h", status="all")`] != 1 {
+		t.Errorf("Eval() values = %v, want the count recorded", values)
+	}
+
+	expression, _ = Compile(`count() / (count(window="1h") - 1) > 0`)
+	_, _, err := expression.Eval(source)
+	if !errors.Is(err, ErrEval) {
+		t.Errorf("Eval() want division by zero error; got %v", err)
+	}
+}
@@ -0,0 +1,420 @@
+package policy
+
+import (
+	"encoding/json"
+	"errors"
+	"fireynis/velocity_checker/pkg/models"
+	"fmt"
+	"io"
+	"time"
+)
+
+const (
+	WindowDay  = "day"
+	WindowWeek = "week"
+
+	MetricCount = "count"
+	MetricSum   = "sum"
+	//MetricAnomaly fails a load whose amount or time of day is an outlier against the customer's loads in the window
+	MetricAnomaly = "anomaly"
+	//MetricDistinct counts the different values of the limit's field
+	MetricDistinct = "distinct"
+	//MetricBalance caps the customer's balance after the load. It has no window.
+	MetricBalance = "balance"
+	//MetricAmount bounds the amount of each load on its own. It has no window.
+	MetricAmount = "amount"
+
+	//ScopeCustomer limits total the customer's own loads, ScopeLinked limits total the loads of every linked customer
+	ScopeCustomer = "customer"
+	ScopeLinked   = "linked"
+
+	//SignalLimitProximity is how close the load takes the customer to their nearest window limit
+	SignalLimitProximity = "limit_proximity"
+	//SignalVelocitySpike is how many loads the customer made in the last hour, saturating at three
+	SignalVelocitySpike = "velocity_spike"
+	//SignalNewCustomer is set when the customer has no loads in the previous 90 days
+	SignalNewCustomer = "new_customer"
+	//SignalUnusualHour is set for loads between midnight and 6am UTC
+	SignalUnusualHour = "unusual_hour"
+	//SignalAnomaly is how far the load's amount or time of day is from the customer's history, reaching 1 at the
+	//anomaly threshold
+	SignalAnomaly = "anomaly"
+)
+
+//Dimensions are the load fields a limit can group by, filter on or count the distinct values of
+var Dimensions = []string{"channel", "funding_source", "funding_type", "merchant_id", "ip_address", "device_id"}
+
+//Signals lists every risk signal a RiskConfig can weigh
+var Signals = []string{SignalLimitProximity, SignalVelocitySpike, SignalNewCustomer, SignalUnusualHour, SignalAnomaly}
+
+//DefaultAnomaly is used when the policy doesn't configure anomaly detection. Customers need ten loads in the last 90
+//days before their history is trusted.
+var DefaultAnomaly = models.AnomalyConfig{
+	Window: "2160h",
+	Tiers: []models.AnomalyTier{
+		{Name: "established", MinHistory: 10, AmountScore: 3.5, HourScore: 3.5},
+	},
+}
+
+//Default is the policy used when no version has been stored. It is version 0 and holds the original limits.
+var Default = models.Policy{
+	Version: 0,
+	Limits: []models.Limit{
+		{Name: "daily_load_count", Window: WindowDay, Metric: MetricCount, Max: 3},
+		{Name: "daily_load_amount", Window: WindowDay, Metric: MetricSum, Max: 500000},
+		{Name: "weekly_load_amount", Window: WindowWeek, Metric: MetricSum, Max: 2000000},
+	},
+}
+
+//EffectiveAt returns the policy version effective at t, falling back to the default policy when none has been stored
+func EffectiveAt(policies models.IPolicies, t time.Time) (*models.Policy, error) {
+	activePolicy, err := policies.GetEffective(t)
+	if err != nil {
+		if errors.Is(err, models.ErrNoRecord) {
+			return &Default, nil
+		}
+		return nil, err
+	}
+	return activePolicy, nil
+}
+
+//Parse reads a policy in its json form and validates it
+func Parse(r io.Reader) (*models.Policy, error) {
+	var policy models.Policy
+	err := json.NewDecoder(r).Decode(&policy)
+	if err != nil {
+		return nil, errors.New(fmt.Sprintf("unable to parse policy. %s", err))
+	}
+
+	err = Validate(&policy)
+	if err != nil {
+		return nil, err
+	}
+	return &policy, nil
+}
+
+//Validate checks every limit can be evaluated so that a bad policy is caught when it is loaded rather than when a
+//load arrives
+func Validate(policy *models.Policy) error {
+	if len(policy.Limits) < 1 {
+		return errors.New("policy has no limits")
+	}
+
+	if policy.Risk != nil {
+		err := validateRisk(policy.Risk)
+		if err != nil {
+			return err
+		}
+	}
+
+	if policy.Structuring != nil {
+		if policy.Structuring.NearPercent <= 0 || policy.Structuring.NearPercent >= 100 {
+			return errors.New("structuring: near_percent must be between 0 and 100")
+		}
+		if policy.Structuring.Days < 1 {
+			return errors.New("structuring: days must be at least 1")
+		}
+	}
+
+	if policy.Anomaly != nil {
+		err := validateAnomaly(policy.Anomaly)
+		if err != nil {
+			return err
+		}
+	}
+
+	if policy.CoolingOff != nil {
+		err := validateCoolingOff(policy.CoolingOff)
+		if err != nil {
+			return err
+		}
+	}
+
+	names := make(map[string]bool)
+	for _, limit := range policy.Limits {
+		if len(limit.Name) < 1 {
+			return errors.New("every limit needs a name")
+		}
+		if names[limit.Name] {
+			return errors.New(fmt.Sprintf("limit %s is defined more than once", limit.Name))
+		}
+		names[limit.Name] = true
+
+		if len(limit.Scope) > 0 && limit.Scope != ScopeCustomer && limit.Scope != ScopeLinked {
+			return errors.New(fmt.Sprintf("limit %s: unknown scope %q", limit.Name, limit.Scope))
+		}
+
+		err := validDimensions(limit)
+		if err != nil {
+			return err
+		}
+
+		if limit.Min != 0 && limit.Metric != MetricAmount {
+			return errors.New(fmt.Sprintf("limit %s: min only applies to amount limits", limit.Name))
+		}
+
+		if len(limit.Expression) > 0 {
+			if len(limit.Window) > 0 || len(limit.Metric) > 0 || limit.Max != 0 {
+				return errors.New(fmt.Sprintf("limit %s: an expression limit can't also have a window, metric or max", limit.Name))
+			}
+			_, err := Compile(limit.Expression)
+			if err != nil {
+				return errors.New(fmt.Sprintf("limit %s: %s", limit.Name, err))
+			}
+			continue
+		}
+
+		if limit.Metric == MetricBalance {
+			if len(limit.Window) > 0 {
+				return errors.New(fmt.Sprintf("limit %s: a balance limit caps the balance itself and has no window", limit.Name))
+			}
+			if limit.Scope == ScopeLinked {
+				return errors.New(fmt.Sprintf("limit %s: a balance limit can't be linked", limit.Name))
+			}
+			if limit.Max <= 0 {
+				return errors.New(fmt.Sprintf("limit %s: a balance limit needs a positive max", limit.Name))
+			}
+			continue
+		}
+		if limit.Metric == MetricAmount {
+			if len(limit.Window) > 0 || limit.Scope == ScopeLinked {
+				return errors.New(fmt.Sprintf("limit %s: an amount limit covers a single load and has no window or scope", limit.Name))
+			}
+			if limit.Min < 0 || limit.Max < 0 || (limit.Min == 0 && limit.Max == 0) {
+				return errors.New(fmt.Sprintf("limit %s: an amount limit needs a positive min, max or both", limit.Name))
+			}
+			if limit.Max > 0 && limit.Min > limit.Max {
+				return errors.New(fmt.Sprintf("limit %s: min can't be above max", limit.Name))
+			}
+			continue
+		}
+
+		err = validWindow(limit.Window)
+		if err != nil {
+			return errors.New(fmt.Sprintf("limit %s: %s", limit.Name, err))
+		}
+		if limit.Metric == MetricAnomaly {
+			if limit.Max != 0 {
+				return errors.New(fmt.Sprintf("limit %s: an anomaly limit takes its thresholds from the anomaly tiers, not max", limit.Name))
+			}
+			continue
+		}
+		if limit.Metric != MetricCount && limit.Metric != MetricSum && limit.Metric != MetricDistinct {
+			return errors.New(fmt.Sprintf("limit %s: unknown metric %q", limit.Name, limit.Metric))
+		}
+		if limit.Max < 0 {
+			return errors.New(fmt.Sprintf("limit %s: max can't be negative", limit.Name))
+		}
+	}
+	return nil
+}
+
+//WindowBounds returns the start and end of the window containing t
+func WindowBounds(window string, t time.Time) (time.Time, time.Time, error) {
+	switch window {
+	case WindowDay:
+		start, end := DayWindow(t)
+		return start, end, nil
+	case WindowWeek:
+		start, end := WeekWindow(t)
+		return start, end, nil
+	}
+
+	duration, err := time.ParseDuration(window)
+	if err != nil || duration <= 0 {
+		return time.Time{}, time.Time{}, errors.New(fmt.Sprintf("unknown window %q", window))
+	}
+	return t.Add(-duration), t, nil
+}
+
+func validateRisk(risk *models.RiskConfig) error {
+	known := make(map[string]bool)
+	for _, signal := range Signals {
+		known[signal] = true
+	}
+
+	total := float64(0)
+	for signal, weight := range risk.Weights {
+		if !known[signal] {
+			return errors.New(fmt.Sprintf("risk: unknown signal %q", signal))
+		}
+		if weight < 0 {
+			return errors.New(fmt.Sprintf("risk: signal %s can't have a negative weight", signal))
+		}
+		total += weight
+	}
+	if total <= 0 {
+		return errors.New("risk: at least one signal needs a weight")
+	}
+	if risk.ReviewAt < 0 || risk.ReviewAt > risk.DeclineAt || risk.DeclineAt > 100 {
+		return errors.New("risk: thresholds must satisfy 0 <= review_at <= decline_at <= 100")
+	}
+	return nil
+}
+
+func validateAnomaly(anomaly *models.AnomalyConfig) error {
+	err := validWindow(anomaly.Window)
+	if err != nil {
+		return errors.New(fmt.Sprintf("anomaly: %s", err))
+	}
+	if len(anomaly.Tiers) < 1 {
+		return errors.New("anomaly: at least one tier is required")
+	}
+
+	names := make(map[string]bool)
+	for _, tier := range anomaly.Tiers {
+		if len(tier.Name) < 1 || names[tier.Name] {
+			return errors.New("anomaly: every tier needs a unique name")
+		}
+		names[tier.Name] = true
+		if tier.MinHistory < 1 {
+			return errors.New(fmt.Sprintf("anomaly: tier %s needs a min_history of at least 1", tier.Name))
+		}
+		if tier.AmountScore < 0 || tier.HourScore < 0 || tier.AmountScore+tier.HourScore == 0 {
+			return errors.New(fmt.Sprintf("anomaly: tier %s needs a positive amount_score or hour_score", tier.Name))
+		}
+	}
+	return nil
+}
+
+func validateCoolingOff(coolingOff *models.CoolingOffConfig) error {
+	if coolingOff.Rejections < 1 {
+		return errors.New("cooling_off: rejections must be at least 1")
+	}
+	window, err := time.ParseDuration(coolingOff.Window)
+	if err != nil || window <= 0 {
+		return errors.New(fmt.Sprintf("cooling_off: window %q must be a positive duration", coolingOff.Window))
+	}
+	lockout, err := time.ParseDuration(coolingOff.Lockout)
+	if err != nil || lockout <= 0 {
+		return errors.New(fmt.Sprintf("cooling_off: lockout %q must be a positive duration", coolingOff.Lockout))
+	}
+	return nil
+}
+
+//CoolingOffSpan returns how far back rejections can still hold a customer in a lockout, the window plus the lockout
+func CoolingOffSpan(policy *models.Policy) time.Duration {
+	if policy.CoolingOff == nil {
+		return 0
+	}
+	window, _ := time.ParseDuration(policy.CoolingOff.Window)
+	lockout, _ := time.ParseDuration(policy.CoolingOff.Lockout)
+	return window + lockout
+}
+
+//validDimensions checks group_by, where and field only name dimensions and are only used on limits that support them
+func validDimensions(limit models.Limit) error {
+	known := make(map[string]bool)
+	for _, dimension := range Dimensions {
+		known[dimension] = true
+	}
+
+	if len(limit.GroupBy) > 0 || len(limit.Where) > 0 {
+		if len(limit.Expression) > 0 || limit.Metric == MetricAnomaly || limit.Metric == MetricBalance || limit.Metric == MetricAmount {
+			return errors.New(fmt.Sprintf("limit %s: group_by and where only apply to count, sum and distinct limits", limit.Name))
+		}
+	}
+	if len(limit.GroupBy) > 0 && !known[limit.GroupBy] {
+		return errors.New(fmt.Sprintf("limit %s: can't group by %q", limit.Name, limit.GroupBy))
+	}
+	for dimension := range limit.Where {
+		if !known[dimension] {
+			return errors.New(fmt.Sprintf("limit %s: can't filter on %q", limit.Name, dimension))
+		}
+	}
+
+	if limit.Metric == MetricDistinct && !known[limit.Field] {
+		return errors.New(fmt.Sprintf("limit %s: distinct needs a field, one of %v", limit.Name, Dimensions))
+	}
+	if limit.Metric != MetricDistinct && len(limit.Field) > 0 {
+		return errors.New(fmt.Sprintf("limit %s: field only applies to distinct limits", limit.Name))
+	}
+	return nil
+}
+
+//FieldValue returns the load's value for a dimension, by the name policies use for it
+func FieldValue(load *models.Load, name string) string {
+	switch name {
+	case "channel":
+		return load.Channel
+	case "funding_source":
+		return load.FundingSource
+	case "funding_type":
+		return load.FundingType
+	case "merchant_id":
+		return load.MerchantId
+	case "ip_address":
+		return load.IpAddress
+	case "device_id":
+		return load.DeviceId
+	}
+	return ""
+}
+
+func validWindow(window string) error {
+	_, _, err := WindowBounds(window, time.Now())
+	return err
+}
+
+//Lookback returns how far before a load the policy's widest window can reach
+func Lookback(policy *models.Policy) time.Duration {
+	var windows []string
+	for _, limit := range policy.Limits {
+		if len(limit.Expression) > 0 {
+			expression, err := Compile(limit.Expression)
+			if err != nil {
+				continue
+			}
+			windows = append(windows, expression.Windows()...)
+		} else {
+			windows = append(windows, limit.Window)
+		}
+	}
+	if policy.Risk != nil && policy.Risk.Weights[SignalAnomaly] > 0 {
+		windows = append(windows, AnomalyFor(policy).Window)
+	}
+
+	longest := time.Duration(0)
+	for _, window := range windows {
+		var length time.Duration
+		switch window {
+		case WindowDay:
+			length = 24 * time.Hour
+		case WindowWeek:
+			length = 7 * 24 * time.Hour
+		default:
+			length, _ = time.ParseDuration(window)
+		}
+		if length > longest {
+			longest = length
+		}
+	}
+	if span := CoolingOffSpan(policy); span > longest {
+		longest = span
+	}
+	return longest
+}
+
+//AnomalyFor returns the policy's anomaly config or the default when it has none
+func AnomalyFor(policy *models.Policy) models.AnomalyConfig {
+	if policy.Anomaly != nil {
+		return *policy.Anomaly
+	}
+	return DefaultAnomaly
+}
+
+//DayWindow returns the bounds of the UTC day t falls in
+func DayWindow(t time.Time) (time.Time, time.Time) {
+	t = t.UTC()
+	start := time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, time.UTC)
+	return start, start.AddDate(0, 0, 1).Add(-time.Nanosecond)
+}
+
+//WeekWindow returns the bounds of the UTC week t falls in. Weeks start on Monday.
+func WeekWindow(t time.Time) (time.Time, time.Time) {
+	start, _ := DayWindow(t)
+	for start.Weekday() != time.Monday {
+		start = start.AddDate(0, 0, -1)
+	}
+	return start, start.AddDate(0, 0, 7).Add(-time.Nanosecond)
+}
@@ -0,0 +1,88 @@
+package policy
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestParse(t *testing.T) {
+	tests := []struct {
+		name    string
+		input   string
+		wantErr bool
+	}{
+		{"Valid", `{"effective_from":"2021-02-01T00:00:00Z","limits":[{"name":"a","window":"day","metric":"count","max":3},{"name":"b","window":"24h","metric":"sum","max":100}]}`, false},
+		{"Expression", `{"limits":[{"name":"a","expression":"count(window=\"1h\") < 2"}]}`, false},
+		{"Bad expression", `{"limits":[{"name":"a","expression":"count(window=\"1h\") +"}]}`, true},
+		{"Expression and window", `{"limits":[{"name":"a","window":"day","expression":"count() < 2"}]}`, true},
+		{"Risk", `{"limits":[{"name":"a","window":"day","metric":"count","max":3}],"risk":{"weights":{"new_customer":1},"review_at":40,"decline_at":80}}`, false},
+		{"Unknown signal", `{"limits":[{"name":"a","window":"day","metric":"count","max":3}],"risk":{"weights":{"zodiac":1},"review_at":40,"decline_at":80}}`, true},
+		{"Review above decline", `{"limits":[{"name":"a","window":"day","metric":"count","max":3}],"risk":{"weights":{"new_customer":1},"review_at":90,"decline_at":80}}`, true},
+		{"Structuring", `{"limits":[{"name":"a","window":"day","metric":"count","max":3}],"structuring":{"near_percent":5,"days":4}}`, false},
+		{"Anomaly", `{"limits":[{"name":"a","window":"2160h","metric":"anomaly"}],"anomaly":{"window":"720h","tiers":[{"name":"new","min_history":3,"amount_score":10},{"name":"established","min_history":10,"amount_score":3.5,"hour_score":3.5}]}}`, false},
+		{"Anomaly with a max", `{"limits":[{"name":"a","window":"2160h","metric":"anomaly","max":3}]}`, true},
+		{"Anomaly tier without scores", `{"limits":[{"name":"a","window":"day","metric":"count","max":3}],"anomaly":{"window":"720h","tiers":[{"name":"new","min_history":3}]}}`, true},
+		{"Structuring without days", `{"limits":[{"name":"a","window":"day","metric":"count","max":3}],"structuring":{"near_percent":5}}`, true},
+		{"Linked", `{"limits":[{"name":"a","window":"day","metric":"sum","max":3,"scope":"linked"}]}`, false},
+		{"Unknown scope", `{"limits":[{"name":"a","window":"day","metric":"sum","max":3,"scope":"galaxy"}]}`, true},
+		{"Dimensions", `{"limits":[{"name":"a","window":"day","metric":"sum","max":3,"group_by":"channel","where":{"funding_type":"card"}},{"name":"b","window":"week","metric":"distinct","field":"funding_source","max":3}]}`, false},
+		{"Distinct without field", `{"limits":[{"name":"a","window":"week","metric":"distinct","max":3}]}`, true},
+		{"Field on sum", `{"limits":[{"name":"a","window":"week","metric":"sum","field":"channel","max":3}]}`, true},
+		{"Unknown group by", `{"limits":[{"name":"a","window":"day","metric":"sum","max":3,"group_by":"amount"}]}`, true},
+		{"Where on expression", `{"limits":[{"name":"a","expression":"count() < 3","where":{"channel":"web"}}]}`, true},
+		{"Balance", `{"limits":[{"name":"a","metric":"balance","max":1000000}]}`, false},
+		{"Balance with a window", `{"limits":[{"name":"a","window":"day","metric":"balance","max":1000000}]}`, true},
+		{"Balance without a max", `{"limits":[{"name":"a","metric":"balance"}]}`, true},
+		{"Linked balance", `{"limits":[{"name":"a","metric":"balance","max":1000000,"scope":"linked"}]}`, true},
+		{"Amount", `{"limits":[{"name":"a","metric":"amount","min":100,"max":500000}]}`, false},
+		{"Amount with only a min", `{"limits":[{"name":"a","metric":"amount","min":100}]}`, false},
+		{"Amount without bounds", `{"limits":[{"name":"a","metric":"amount"}]}`, true},
+		{"Amount min above max", `{"limits":[{"name":"a","metric":"amount","min":500,"max":100}]}`, true},
+		{"Amount with a window", `{"limits":[{"name":"a","window":"day","metric":"amount","max":100}]}`, true},
+		{"Min on sum", `{"limits":[{"name":"a","window":"day","metric":"sum","min":1,"max":100}]}`, true},
+		{"Cooling off", `{"limits":[{"name":"a","window":"day","metric":"count","max":3}],"cooling_off":{"rejections":3,"window":"1h","lockout":"24h"}}`, false},
+		{"Cooling off without rejections", `{"limits":[{"name":"a","window":"day","metric":"count","max":3}],"cooling_off":{"window":"1h","lockout":"24h"}}`, true},
+		{"Cooling off with a day window", `{"limits":[{"name":"a","window":"day","metric":"count","max":3}],"cooling_off":{"rejections":3,"window":"day","lockout":"24h"}}`, true},
+		{"Cooling off without a lockout", `{"limits":[{"name":"a","window":"day","metric":"count","max":3}],"cooling_off":{"rejections":3,"window":"1h"}}`, true},
+		{"No limits", `{"effective_from":"2021-02-01T00:00:00Z","limits":[]}`, true},
+		{"Duplicate name", `{"limits":[{"name":"a","window":"day","metric":"count","max":3},{"name":"a","window":"week","metric":"sum","max":3}]}`, true},
+		{"Unknown window", `{"limits":[{"name":"a","window":"month","metric":"count","max":3}]}`, true},
+		{"Unknown metric", `{"limits":[{"name":"a","window":"day","metric":"avg","max":3}]}`, true},
+		{"Bad json", `{"limits":`, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := Parse(strings.NewReader(tt.input))
+			if (err != nil) != tt.wantErr {
+				t.Errorf("Parse() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestWindowBounds(t *testing.T) {
+	at := time.Date(2000, 1, 5, 16, 0, 0, 0, time.UTC)
+	tests := []struct {
+		window    string
+		wantStart time.Time
+		wantEnd   time.Time
+	}{
+		{WindowDay, time.Date(2000, 1, 5, 0, 0, 0, 0, time.UTC), time.Date(2000, 1, 6, 0, 0, 0, 0, time.UTC).Add(-time.Nanosecond)},
+		{WindowWeek, time.Date(2000, 1, 3, 0, 0, 0, 0, time.UTC), time.Date(2000, 1, 10, 0, 0, 0, 0, time.UTC).Add(-time.Nanosecond)},
+		{"1h", time.Date(2000, 1, 5, 15, 0, 0, 0, time.UTC), at},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.window, func(t *testing.T) {
+			start, end, err := WindowBounds(tt.window, at)
+			if err != nil {
+				t.Fatalf("Unexpected error %v", err)
+			}
+			if !start.Equal(tt.wantStart) || !end.Equal(tt.wantEnd) {
+				t.Errorf("want %s - %s; got %s - %s", tt.wantStart, tt.wantEnd, start, end)
+			}
+		})
+	}
+}
@@ -0,0 +1,171 @@
+package queue
+
+import (
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+//The directories a Dir keeps its messages in
+const (
+	dirReady      = "ready"
+	dirProcessing = "processing"
+	dirDead       = "dead"
+)
+
+//validKey keeps keys safe to use in a file name
+var validKey = regexp.MustCompile(`^[A-Za-z0-9_]+$`)
+
+//Dir is a queue kept in a directory, one file per message. Files are named for the order they were published in and
+//their key, and move from ready to processing when received, then are deleted when acked or moved back to ready or on
+//to dead when nacked. A key with a message in processing, from this process or another sharing the directory, is held
+//back so messages with the same key are handled one at a time in order.
+type Dir struct {
+	Path string
+
+	locks keyLocks
+	mu    sync.Mutex
+	seq   int64
+}
+
+//OpenDir creates the queue's directories under path if they don't exist
+func OpenDir(path string) (*Dir, error) {
+	for _, sub := range []string{dirReady, dirProcessing, dirDead} {
+		err := os.MkdirAll(filepath.Join(path, sub), 0755)
+		if err != nil {
+			return nil, err
+		}
+	}
+	return &Dir{Path: path}, nil
+}
+
+//Publish writes the message to ready. It is written under another name first and renamed, so it is never received
+//half written.
+func (d *Dir) Publish(key string, body []byte) error {
+	if !validKey.MatchString(key) {
+		return errors.New(fmt.Sprintf("queue: key %q can only have letters, digits and underscores", key))
+	}
+
+	d.mu.Lock()
+	d.seq++
+	name := fmt.Sprintf("%020d%06d-%s.json", time.Now().UnixNano(), d.seq%1000000, key)
+	d.mu.Unlock()
+
+	temp := filepath.Join(d.Path, "."+name)
+	err := ioutil.WriteFile(temp, body, 0644)
+	if err != nil {
+		return err
+	}
+	return os.Rename(temp, filepath.Join(d.Path, dirReady, name))
+}
+
+//Receive moves the oldest ready message whose key isn't already being handled to processing and returns it, or
+//ErrEmpty when there is none
+func (d *Dir) Receive() (*Message, error) {
+	//Ready is listed before processing so a message received by another consumer in between is seen in one or the other
+	ready, err := d.list(dirReady)
+	if err != nil {
+		return nil, err
+	}
+	processing, err := d.list(dirProcessing)
+	if err != nil {
+		return nil, err
+	}
+
+	held := make(map[string]bool)
+	for _, name := range processing {
+		held[keyOf(name)] = true
+	}
+
+	for _, name := range ready {
+		key := keyOf(name)
+		if held[key] {
+			continue
+		}
+		//Whether or not this message can be had, later ones with its key have to wait for it
+		held[key] = true
+		if !d.locks.tryLock(key) {
+			continue
+		}
+
+		err = os.Rename(filepath.Join(d.Path, dirReady, name), filepath.Join(d.Path, dirProcessing, name))
+		if err != nil {
+			d.locks.unlock(key)
+			//Another consumer got there first
+			if os.IsNotExist(err) {
+				continue
+			}
+			return nil, err
+		}
+
+		body, err := ioutil.ReadFile(filepath.Join(d.Path, dirProcessing, name))
+		if err != nil {
+			d.locks.unlock(key)
+			return nil, err
+		}
+		return &Message{Id: name, Key: key, Body: body}, nil
+	}
+	return nil, ErrEmpty
+}
+
+//Ack deletes the message
+func (d *Dir) Ack(message *Message) error {
+	defer d.locks.unlock(message.Key)
+	return os.Remove(filepath.Join(d.Path, dirProcessing, message.Id))
+}
+
+//Nack moves the message back to ready, where it keeps its place ahead of later messages with its key, or to dead
+func (d *Dir) Nack(message *Message, requeue bool) error {
+	defer d.locks.unlock(message.Key)
+	to := dirDead
+	if requeue {
+		to = dirReady
+	}
+	return os.Rename(filepath.Join(d.Path, dirProcessing, message.Id), filepath.Join(d.Path, to, message.Id))
+}
+
+//Recover moves every message left in processing back to ready, as happens when a consumer dies before acking. Only call
+//it when no other consumer is using the directory, or their messages will be handed out twice.
+func (d *Dir) Recover() (int, error) {
+	processing, err := d.list(dirProcessing)
+	if err != nil {
+		return 0, err
+	}
+	for i, name := range processing {
+		err = os.Rename(filepath.Join(d.Path, dirProcessing, name), filepath.Join(d.Path, dirReady, name))
+		if err != nil {
+			return i, err
+		}
+	}
+	return len(processing), nil
+}
+
+//list returns the message files in a directory, oldest first
+func (d *Dir) list(sub string) ([]string, error) {
+	entries, err := ioutil.ReadDir(filepath.Join(d.Path, sub))
+	if err != nil {
+		return nil, err
+	}
+
+	names := make([]string, 0, len(entries))
+	for _, entry := range entries {
+		if !entry.IsDir() && strings.HasSuffix(entry.Name(), ".json") && !strings.HasPrefix(entry.Name(), ".") {
+			names = append(names, entry.Name())
+		}
+	}
+	sort.Strings(names)
+	return names, nil
+}
+
+//keyOf takes the key out of a message file name, after the sequence and before the extension
+func keyOf(name string) string {
+	name = strings.TrimSuffix(name, ".json")
+	return name[strings.Index(name, "-")+1:]
+}
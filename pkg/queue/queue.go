@@ -0,0 +1,172 @@
+package queue
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"sync"
+	"time"
+)
+
+var (
+	//ErrEmpty is returned by Receive when there is no message that can be handed out
+	ErrEmpty = errors.New("queue: no message ready")
+	//ErrReject wraps a handler error for a message that will never succeed, so it is dead lettered rather than retried
+	ErrReject = errors.New("queue: message rejected")
+)
+
+//Message is a message taken from a queue. Key orders messages, those with the same key are handed out one at a time in
+//the order they were published.
+type Message struct {
+	Id   string
+	Key  string
+	Body []byte
+}
+
+//IConsumer is what a queue, or an adapter for a broker, has to provide for messages to be consumed from it. A received
+//message must be acked once it has been handled or nacked to give it back, requeued or dead lettered. A message that is
+//neither, as when the consumer dies, is handed out again, so delivery is at least once.
+type IConsumer interface {
+	Receive() (*Message, error)
+	Ack(message *Message) error
+	Nack(message *Message, requeue bool) error
+}
+
+//IPublisher publishes a message to a topic
+type IPublisher interface {
+	Publish(key string, body []byte) error
+}
+
+//Handler handles a message, returning the reply to publish. An error wrapping ErrReject dead letters the message, any
+//other is retried.
+type Handler func(message *Message) ([]byte, error)
+
+//Consumer takes messages from Source, hands them to Handler and publishes the replies to Replies with the message's
+//key. The reply is published before the message is acked, so a reply can be published twice but never lost.
+type Consumer struct {
+	Source  IConsumer
+	Replies IPublisher
+	Handler Handler
+	//PollInterval is how long to wait when the queue is empty, RetryDelay how long to wait after a message is requeued
+	PollInterval time.Duration
+	RetryDelay   time.Duration
+}
+
+//drainAttempts is how many times in a row Drain lets messages be requeued before it gives up
+const drainAttempts = 3
+
+//Drain handles messages until there are none ready, returning how many were acked. As a requeued message is handed out
+//again straight away, it gives up once drainAttempts in a row have failed rather than retrying forever.
+func (c *Consumer) Drain() (int, error) {
+	handled := 0
+	failed := 0
+	for {
+		acked, requeued, err := c.next()
+		if errors.Is(err, ErrEmpty) {
+			return handled, nil
+		}
+		if err != nil {
+			return handled, err
+		}
+		if acked {
+			handled++
+		}
+		if !requeued {
+			failed = 0
+			continue
+		}
+
+		failed++
+		if failed >= drainAttempts {
+			return handled, errors.New(fmt.Sprintf("queue: gave up after %d failed attempts in a row", failed))
+		}
+		time.Sleep(c.RetryDelay)
+	}
+}
+
+//Run handles messages until ctx is cancelled. It only returns early if the queue itself fails.
+func (c *Consumer) Run(ctx context.Context) error {
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		default:
+		}
+
+		_, requeued, err := c.next()
+		var wait time.Duration
+		switch {
+		case errors.Is(err, ErrEmpty):
+			wait = c.PollInterval
+		case err != nil:
+			return err
+		case requeued:
+			wait = c.RetryDelay
+		}
+		if wait <= 0 {
+			continue
+		}
+
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-time.After(wait):
+		}
+	}
+}
+
+//next handles one message, reporting whether it was acked or requeued. Handler failures are logged and the message
+//nacked.
+func (c *Consumer) next() (bool, bool, error) {
+	message, err := c.Source.Receive()
+	if err != nil {
+		return false, false, err
+	}
+
+	reply, err := c.Handler(message)
+	if err != nil {
+		rejected := errors.Is(err, ErrReject)
+		log.Printf("Unable to handle message %s, requeued %t. %s", message.Id, !rejected, err)
+		return false, !rejected, c.Source.Nack(message, !rejected)
+	}
+
+	if reply != nil {
+		err = c.Replies.Publish(message.Key, reply)
+		if err != nil {
+			log.Printf("Unable to publish the reply to message %s. %s", message.Id, err)
+			return false, true, c.Source.Nack(message, true)
+		}
+	}
+	return true, false, c.Source.Ack(message)
+}
+
+//Reject wraps err so the message is dead lettered rather than retried
+func Reject(err error) error {
+	return fmt.Errorf("%w: %s", ErrReject, err)
+}
+
+//keyLocks tracks the keys of the messages handed out and not yet acked or nacked
+type keyLocks struct {
+	mu   sync.Mutex
+	held map[string]bool
+}
+
+func (l *keyLocks) tryLock(key string) bool {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.held == nil {
+		l.held = make(map[string]bool)
+	}
+	if l.held[key] {
+		return false
+	}
+	l.held[key] = true
+	return true
+}
+
+func (l *keyLocks) unlock(key string) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	delete(l.held, key)
+}
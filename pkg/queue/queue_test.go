@@ -0,0 +1,114 @@
+package queue
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+)
+
+func TestDir(t *testing.T) {
+	dir, err := OpenDir(t.TempDir())
+	if err != nil {
+		t.Fatalf("OpenDir() unexpected error %v", err)
+	}
+	for _, message := range []struct{ key, body string }{{"1", "a"}, {"1", "b"}, {"2", "c"}} {
+		err = dir.Publish(message.key, []byte(message.body))
+		if err != nil {
+			t.Fatalf("Publish() unexpected error %v", err)
+		}
+	}
+	if dir.Publish("../1", []byte("d")) == nil {
+		t.Errorf("Publish() want an error for a key that isn't safe in a file name")
+	}
+
+	//Customer 1's second message waits for its first
+	first, _ := dir.Receive()
+	second, _ := dir.Receive()
+	if string(first.Body) != "a" || string(second.Body) != "c" {
+		t.Fatalf("Receive() = %s then %s, want a then c", first.Body, second.Body)
+	}
+	if _, err = dir.Receive(); !errors.Is(err, ErrEmpty) {
+		t.Fatalf("Receive() error = %v, want ErrEmpty while both keys are held", err)
+	}
+
+	//A requeued message keeps its place ahead of the key's later messages
+	_ = dir.Nack(first, true)
+	again, _ := dir.Receive()
+	if string(again.Body) != "a" {
+		t.Fatalf("Receive() = %s, want a again after it was requeued", again.Body)
+	}
+	_ = dir.Ack(again)
+	_ = dir.Nack(second, false)
+
+	third, _ := dir.Receive()
+	if string(third.Body) != "b" {
+		t.Fatalf("Receive() = %s, want b once a was acked", third.Body)
+	}
+
+	//A consumer that dies leaves the message in processing until it is recovered
+	restarted := &Dir{Path: dir.Path}
+	if _, err = restarted.Receive(); !errors.Is(err, ErrEmpty) {
+		t.Fatalf("Receive() error = %v, want ErrEmpty while b is in processing", err)
+	}
+	recovered, err := restarted.Recover()
+	if err != nil || recovered != 1 {
+		t.Fatalf("Recover() = %d, %v, want 1", recovered, err)
+	}
+	redelivered, _ := restarted.Receive()
+	if string(redelivered.Body) != "b" {
+		t.Errorf("Receive() = %s, want b redelivered", redelivered.Body)
+	}
+
+	dead, _ := dir.list(dirDead)
+	if len(dead) != 1 || keyOf(dead[0]) != "2" {
+		t.Errorf("dead = %v, want c dead lettered", dead)
+	}
+}
+
+//recordingPublisher keeps the bodies published to it
+type recordingPublisher struct {
+	bodies []string
+}
+
+func (p *recordingPublisher) Publish(key string, body []byte) error {
+	p.bodies = append(p.bodies, key+":"+string(body))
+	return nil
+}
+
+func TestConsumer_Drain(t *testing.T) {
+	dir, _ := OpenDir(t.TempDir())
+	for _, body := range []string{"ok", "bad", "flaky", "ok"} {
+		_ = dir.Publish("1", []byte(body))
+	}
+
+	attempts := 0
+	replies := &recordingPublisher{}
+	consumer := &Consumer{
+		Source:  dir,
+		Replies: replies,
+		Handler: func(message *Message) ([]byte, error) {
+			switch string(message.Body) {
+			case "bad":
+				return nil, Reject(errors.New("unparseable"))
+			case "flaky":
+				attempts++
+				if attempts < 2 {
+					return nil, errors.New("database unavailable")
+				}
+			}
+			return []byte("decided " + string(message.Body)), nil
+		},
+	}
+
+	handled, err := consumer.Drain()
+	if err != nil || handled != 3 {
+		t.Fatalf("Drain() = %d, %v, want 3", handled, err)
+	}
+	if want := []string{"1:decided ok", "1:decided flaky", "1:decided ok"}; !reflect.DeepEqual(replies.bodies, want) {
+		t.Errorf("Drain() published %v, want %v", replies.bodies, want)
+	}
+	dead, _ := dir.list(dirDead)
+	if len(dead) != 1 {
+		t.Errorf("dead = %v, want the rejected message", dead)
+	}
+}
@@ -0,0 +1,111 @@
+package report
+
+import (
+	"errors"
+	"fireynis/velocity_checker/pkg/engine"
+	"fireynis/velocity_checker/pkg/models"
+	"fireynis/velocity_checker/pkg/models/memory"
+	"fireynis/velocity_checker/pkg/policy"
+	"fireynis/velocity_checker/pkg/validators"
+	"fmt"
+	"sort"
+	"time"
+)
+
+//Flip is a load whose decision would change under the candidate policy
+type Flip struct {
+	Load        *models.Load
+	WasAccepted bool
+	FailedRules []string
+}
+
+//RuleImpact is how often a limit in the candidate policy failed and how many of those failures flipped a decision
+type RuleImpact struct {
+	Rule    string
+	Failed  int
+	Flipped int
+}
+
+//BacktestResult is the difference between the stored decisions and the candidate policy's decisions
+type BacktestResult struct {
+	Replayed          int
+	Flips             []Flip
+	Rules             []RuleImpact
+	CustomersAffected []int64
+}
+
+//Backtest replays every stored load in the date range, oldest first, through the engine with the candidate policy.
+//The engine reads and writes an in-memory store seeded with the history its windows can reach, so nothing is written
+//back to history. Debits aren't decided by the policy so they are left out of the replay, and balance limits pass as
+//there are no balances to replay.
+func Backtest(history models.ILoads, candidate *models.Policy, startDate time.Time, endDate time.Time) (*BacktestResult, error) {
+	seed, err := history.GetByDateRange(startDate.Add(-policy.Lookback(candidate)), startDate.Add(-time.Nanosecond))
+	if err != nil && !errors.Is(err, models.ErrNoRecord) {
+		return nil, errors.New(fmt.Sprintf("unable to read loads before the range. %s", err))
+	}
+	replay, err := history.GetByDateRange(startDate, endDate)
+	if err != nil && !errors.Is(err, models.ErrNoRecord) {
+		return nil, errors.New(fmt.Sprintf("unable to read loads in the range. %s", err))
+	}
+
+	store := memory.NewLoad(seed)
+	candidateEngine := &engine.Engine{
+		Loads:     store,
+		Policies:  &memory.Policy{Policy: candidate},
+		Validator: &validators.LoadValidator{},
+	}
+
+	result := &BacktestResult{}
+	impacts := make(map[string]*RuleImpact)
+	customers := make(map[int64]bool)
+	for _, stored := range replay {
+		if stored.Type == models.TypeDebit {
+			continue
+		}
+		load := *stored
+		trace, err := candidateEngine.Evaluate(&load)
+		if err != nil {
+			return nil, errors.New(fmt.Sprintf("unable to evaluate load %d. %s", load.Id, err))
+		}
+		_, _ = store.Insert(&load)
+		result.Replayed++
+
+		flipped := load.Accepted != stored.Accepted
+		var failed []string
+		for _, rule := range trace {
+			if rule.Passed || rule.Shadow {
+				continue
+			}
+			failed = append(failed, rule.Rule)
+
+			impact, ok := impacts[rule.Rule]
+			if !ok {
+				impact = &RuleImpact{Rule: rule.Rule}
+				impacts[rule.Rule] = impact
+			}
+			impact.Failed++
+			if flipped {
+				impact.Flipped++
+			}
+		}
+
+		if flipped {
+			result.Flips = append(result.Flips, Flip{Load: &load, WasAccepted: stored.Accepted, FailedRules: failed})
+			customers[load.CustomerId] = true
+		}
+	}
+
+	for _, impact := range impacts {
+		result.Rules = append(result.Rules, *impact)
+	}
+	sort.Slice(result.Rules, func(i, j int) bool {
+		return result.Rules[i].Rule < result.Rules[j].Rule
+	})
+	for customerId := range customers {
+		result.CustomersAffected = append(result.CustomersAffected, customerId)
+	}
+	sort.Slice(result.CustomersAffected, func(i, j int) bool {
+		return result.CustomersAffected[i] < result.CustomersAffected[j]
+	})
+	return result, nil
+}
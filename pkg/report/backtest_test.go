@@ -0,0 +1,60 @@
+package report
+
+import (
+	"fireynis/velocity_checker/pkg/models"
+	"fireynis/velocity_checker/pkg/models/memory"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestBacktest(t *testing.T) {
+	day := time.Date(2000, 1, 4, 0, 0, 0, 0, time.UTC)
+	history := memory.NewLoad([]*models.Load{
+		//Before the range but inside the weekly window, so it has to be seeded
+		{Id: 1, TransactionId: 1, CustomerId: 1, Amount: 150000, Time: day.Add(-20 * time.Hour), Accepted: true},
+		{Id: 2, TransactionId: 2, CustomerId: 1, Amount: 100000, Time: day.Add(1 * time.Hour), Accepted: true},
+		{Id: 3, TransactionId: 3, CustomerId: 1, Amount: 100000, Time: day.Add(2 * time.Hour), Accepted: true},
+		{Id: 4, TransactionId: 1, CustomerId: 2, Amount: 100000, Time: day.Add(3 * time.Hour), Accepted: true},
+		{Id: 5, TransactionId: 2, CustomerId: 2, Amount: 900000, Time: day.Add(4 * time.Hour), Accepted: false},
+	})
+	candidate := &models.Policy{
+		Limits: []models.Limit{
+			{Name: "daily_load_amount", Window: "day", Metric: "sum", Max: 1000000},
+			{Name: "weekly_load_amount", Window: "week", Metric: "sum", Max: 300000},
+		},
+	}
+
+	result, err := Backtest(history, candidate, day, day.Add(24*time.Hour-time.Nanosecond))
+	if err != nil {
+		t.Fatalf("Backtest() unexpected error %v", err)
+	}
+
+	if result.Replayed != 4 {
+		t.Errorf("want 4 loads replayed; got %d", result.Replayed)
+	}
+
+	var flipped [][2]int64
+	for _, flip := range result.Flips {
+		flipped = append(flipped, [2]int64{flip.Load.CustomerId, flip.Load.TransactionId})
+	}
+	wantFlipped := [][2]int64{{1, 3}}
+	if !reflect.DeepEqual(flipped, wantFlipped) {
+		t.Errorf("want flips %v; got %v", wantFlipped, flipped)
+	}
+
+	wantRules := []RuleImpact{{Rule: "weekly_load_amount", Failed: 2, Flipped: 1}}
+	if !reflect.DeepEqual(result.Rules, wantRules) {
+		t.Errorf("want rules %+v; got %+v", wantRules, result.Rules)
+	}
+
+	if !reflect.DeepEqual(result.CustomersAffected, []int64{1}) {
+		t.Errorf("want customers affected [1]; got %v", result.CustomersAffected)
+	}
+
+	//The history must be left as it was
+	stored, _ := history.Get(3)
+	if !stored.Accepted {
+		t.Errorf("backtest changed the stored decision")
+	}
+}
@@ -0,0 +1,103 @@
+package report
+
+import (
+	"errors"
+	"fireynis/velocity_checker/pkg/engine"
+	"fireynis/velocity_checker/pkg/linkage"
+	"fireynis/velocity_checker/pkg/models"
+	"fireynis/velocity_checker/pkg/models/memory"
+	"fireynis/velocity_checker/pkg/policy"
+	"fireynis/velocity_checker/pkg/validators"
+	"fmt"
+	"time"
+)
+
+//Recheck looks for decisions a late load should have changed. Loads are decided in the order they arrive, so a load
+//timed before loads already decided is checked against them but they were never checked against it.
+type Recheck struct {
+	Loads       models.ILoads
+	Policies    models.IPolicies
+	Links       models.ILinks
+	Corrections models.ICorrections
+}
+
+//Check replays the loads timed after the stored load that its windows reach, oldest first, with the load in their
+//history, and stores a correction for each decision that would have come out differently. The replay reads and
+//writes an in-memory copy of the loads so nothing stored changes. Loads of linked customers are replayed as well, as
+//linked limits count the late load too. Debits and balance limits are left alone, as in a backtest.
+func (r *Recheck) Check(load *models.Load) ([]*models.Correction, error) {
+	activePolicy, err := policy.EffectiveAt(r.Policies, load.Time)
+	if err != nil {
+		return nil, err
+	}
+	lookback := policy.Lookback(activePolicy)
+
+	customerIds := []int64{load.CustomerId}
+	if r.Links != nil {
+		customerIds, err = linkage.Group(r.Links, load)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	later, err := r.Loads.GetByCustomersByDateRange(customerIds, load.Time.Add(time.Nanosecond), load.Time.Add(lookback))
+	if err != nil && !errors.Is(err, models.ErrNoRecord) {
+		return nil, errors.New(fmt.Sprintf("unable to read loads after the late load. %s", err))
+	}
+	if len(later) == 0 {
+		return nil, nil
+	}
+
+	seed, err := r.Loads.GetByCustomersByDateRange(customerIds, load.Time.Add(-lookback), load.Time)
+	if err != nil && !errors.Is(err, models.ErrNoRecord) {
+		return nil, errors.New(fmt.Sprintf("unable to read loads before the late load. %s", err))
+	}
+
+	store := memory.NewLoad(seed)
+	replayEngine := &engine.Engine{
+		Loads:     store,
+		Policies:  r.Policies,
+		Validator: &validators.LoadValidator{},
+		Links:     r.Links,
+	}
+
+	var corrections []*models.Correction
+	for _, stored := range later {
+		replayed := *stored
+		if stored.Type == models.TypeDebit {
+			_, _ = store.Insert(&replayed)
+			continue
+		}
+
+		trace, err := replayEngine.Evaluate(&replayed)
+		if err != nil {
+			return nil, errors.New(fmt.Sprintf("unable to evaluate load %d. %s", stored.Id, err))
+		}
+		_, _ = store.Insert(&replayed)
+		if replayed.Outcome == stored.Outcome {
+			continue
+		}
+
+		failed := make([]string, 0)
+		for _, rule := range trace {
+			if !rule.Passed && !rule.Shadow {
+				failed = append(failed, rule.Rule)
+			}
+		}
+		correction := &models.Correction{
+			CustomerId:        stored.CustomerId,
+			TransactionId:     stored.TransactionId,
+			WasOutcome:        stored.Outcome,
+			Outcome:           replayed.Outcome,
+			FailedRules:       failed,
+			LateCustomerId:    load.CustomerId,
+			LateTransactionId: load.TransactionId,
+		}
+		err = r.Corrections.Insert(correction)
+		if err != nil {
+			return nil, err
+		}
+		corrections = append(corrections, correction)
+	}
+	return corrections, nil
+}
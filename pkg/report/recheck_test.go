@@ -0,0 +1,66 @@
+package report
+
+import (
+	"fireynis/velocity_checker/pkg/models"
+	"fireynis/velocity_checker/pkg/models/memory"
+	"fireynis/velocity_checker/pkg/models/mock"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestRecheck_Check(t *testing.T) {
+	day := time.Date(2000, 1, 4, 0, 0, 0, 0, time.UTC)
+	late := &models.Load{Id: 5, TransactionId: 5, CustomerId: 1, Amount: 100000, Time: day.Add(9 * time.Hour), Accepted: true, Outcome: models.OutcomeApprove}
+	loads := memory.NewLoad([]*models.Load{
+		{Id: 1, TransactionId: 1, CustomerId: 1, Amount: 300000, Time: day.Add(10 * time.Hour), Accepted: true, Outcome: models.OutcomeApprove},
+		{Id: 2, TransactionId: 2, CustomerId: 1, Type: models.TypeDebit, Amount: 200000, Time: day.Add(11 * time.Hour), Accepted: true, Outcome: models.OutcomeApprove},
+		{Id: 3, TransactionId: 3, CustomerId: 1, Amount: 150000, Time: day.Add(12 * time.Hour), Accepted: true, Outcome: models.OutcomeApprove},
+		{Id: 4, TransactionId: 1, CustomerId: 2, Amount: 450000, Time: day.Add(12 * time.Hour), Accepted: true, Outcome: models.OutcomeApprove},
+		late,
+	})
+	recheck := &Recheck{
+		Loads: loads,
+		Policies: &memory.Policy{Policy: &models.Policy{
+			Limits: []models.Limit{{Name: "daily_load_amount", Window: "day", Metric: "sum", Max: 500000}},
+		}},
+		Corrections: &mock.Correction{},
+	}
+
+	corrections, err := recheck.Check(late)
+	if err != nil {
+		t.Fatalf("Check() unexpected error %v", err)
+	}
+	want := []*models.Correction{{
+		CustomerId:        1,
+		TransactionId:     3,
+		WasOutcome:        models.OutcomeApprove,
+		Outcome:           models.OutcomeDecline,
+		FailedRules:       []string{"daily_load_amount"},
+		LateCustomerId:    1,
+		LateTransactionId: 5,
+	}}
+	for _, correction := range corrections {
+		correction.Id = 0
+		correction.CreatedAt = time.Time{}
+	}
+	if !reflect.DeepEqual(corrections, want) {
+		t.Errorf("Check() got %+v, want %+v", corrections, want)
+	}
+
+	//Nothing stored is changed and checking again doesn't store the correction twice
+	stored, _ := loads.Get(3)
+	if !stored.Accepted {
+		t.Errorf("Check() changed the stored decision")
+	}
+	_, _ = recheck.Check(late)
+	if len(recheck.Corrections.(*mock.Correction).Corrections) != 1 {
+		t.Errorf("Check() stored the correction more than once")
+	}
+
+	//A load with nothing after it has nothing to correct
+	corrections, err = recheck.Check(&models.Load{Id: 6, TransactionId: 6, CustomerId: 1, Amount: 100000, Time: day.Add(13 * time.Hour)})
+	if err != nil || corrections != nil {
+		t.Errorf("Check() of an in order load got %+v, %v", corrections, err)
+	}
+}
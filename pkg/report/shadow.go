@@ -0,0 +1,60 @@
+package report
+
+import (
+	"fireynis/velocity_checker/pkg/models"
+	"sort"
+)
+
+//ShadowSummary is how one shadow rule compared to the live decisions it ran alongside
+type ShadowSummary struct {
+	Rule string `json:"rule"`
+	//Evaluated is the number of loads the rule was evaluated on
+	Evaluated int `json:"evaluated"`
+	//Failed is the number of loads the rule would have rejected
+	Failed int `json:"failed"`
+	//Disagreed is the number of loads the rule would have rejected that the live rules accepted
+	Disagreed int `json:"disagreed"`
+	//CustomersAffected is the number of distinct customers with at least one disagreement
+	CustomersAffected int `json:"customers_affected"`
+}
+
+//Shadow summarises every shadow rule found in the traced loads, ordered by rule name
+func Shadow(traced []*models.TracedLoad) []ShadowSummary {
+	summaries := make(map[string]*ShadowSummary)
+	customers := make(map[string]map[int64]bool)
+
+	for _, tracedLoad := range traced {
+		for _, rule := range tracedLoad.Trace {
+			if !rule.Shadow {
+				continue
+			}
+
+			summary, ok := summaries[rule.Rule]
+			if !ok {
+				summary = &ShadowSummary{Rule: rule.Rule}
+				summaries[rule.Rule] = summary
+				customers[rule.Rule] = make(map[int64]bool)
+			}
+
+			summary.Evaluated++
+			if rule.Passed {
+				continue
+			}
+			summary.Failed++
+			if tracedLoad.Load.Accepted {
+				summary.Disagreed++
+				customers[rule.Rule][tracedLoad.Load.CustomerId] = true
+			}
+		}
+	}
+
+	out := make([]ShadowSummary, 0, len(summaries))
+	for name, summary := range summaries {
+		summary.CustomersAffected = len(customers[name])
+		out = append(out, *summary)
+	}
+	sort.Slice(out, func(i, j int) bool {
+		return out[i].Rule < out[j].Rule
+	})
+	return out
+}
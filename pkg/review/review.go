@@ -0,0 +1,166 @@
+package review
+
+import (
+	"errors"
+	"fireynis/velocity_checker/pkg/audit"
+	"fireynis/velocity_checker/pkg/models"
+	"fireynis/velocity_checker/pkg/outbox"
+	"fireynis/velocity_checker/pkg/webhook"
+	"fmt"
+	"time"
+)
+
+//ActorSla is recorded against loads declined because nobody reviewed them in time
+const ActorSla = "review_sla"
+
+var (
+	//ErrNotPending is returned when acting on a load that isn't waiting for review
+	ErrNotPending = errors.New("review: load is not pending review")
+	//ErrClaimed is returned when acting on a load another analyst has claimed
+	ErrClaimed = errors.New("review: load is claimed by another analyst")
+)
+
+//Queue is the manual review queue. Loads join it when the engine decides they need review and leave it when an analyst
+//approves or declines them, or the SLA runs out.
+type Queue struct {
+	Loads models.ILoads
+	//Decisions stores each load that leaves the queue with its move in the customer's balance and its audit entry
+	Decisions models.IDecisions
+	//Webhooks is told about every load that leaves the queue, it can be nil
+	Webhooks *webhook.Dispatcher
+	//Outbox is set when the outbox is on, to write an adjudication event with every load that leaves the queue
+	Outbox bool
+	//Sla is how long after it was received a load can wait before it is declined automatically
+	Sla time.Duration
+}
+
+//Pending lists the loads waiting for review, oldest first
+func (q *Queue) Pending() ([]*models.Load, error) {
+	return q.Loads.GetByStatus(models.StatusPendingReview)
+}
+
+//Claim assigns the load to the analyst. Claiming a load the analyst already holds is not an error. The claim is made
+//in the store, so of two analysts claiming a load at once only one gets it, whichever instance they went through.
+func (q *Queue) Claim(customerId int64, transactionId int64, analyst string) (*models.Load, error) {
+	for {
+		load, err := q.pending(customerId, transactionId, analyst)
+		if err != nil {
+			return nil, err
+		}
+
+		err = q.Loads.Claim(load.Id, analyst)
+		//The load changed since it was read, read it again to see whether the analyst can still claim it
+		if errors.Is(err, models.ErrChanged) {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		load.Analyst = analyst
+		return load, nil
+	}
+}
+
+//Approve accepts the load on the analyst's say so
+func (q *Queue) Approve(customerId int64, transactionId int64, analyst string, note string) (*models.Load, error) {
+	return q.decide(customerId, transactionId, analyst, note, models.OutcomeApprove)
+}
+
+//Decline rejects the load on the analyst's say so
+func (q *Queue) Decline(customerId int64, transactionId int64, analyst string, note string) (*models.Load, error) {
+	return q.decide(customerId, transactionId, analyst, note, models.OutcomeDecline)
+}
+
+//ExpireOverdue declines every pending load received longer than the SLA before now, returning how many were declined.
+//The SLA runs from when the load was received rather than its own time, so a backdated load still gets the full SLA.
+//Each load is read again before it is declined, and the decline only stored while the load is unchanged, so one an
+//analyst decided after it was listed is left alone.
+func (q *Queue) ExpireOverdue(now time.Time) (int, error) {
+	pending, err := q.Pending()
+	if err != nil {
+		return 0, err
+	}
+
+	expired := 0
+	for _, listed := range pending {
+		if now.Sub(listed.ReceivedAt) < q.Sla {
+			continue
+		}
+		load, err := q.Loads.GetByTransactionId(listed.CustomerId, listed.TransactionId)
+		if err != nil {
+			return expired, err
+		}
+		if load.Status != models.StatusPendingReview {
+			continue
+		}
+		err = q.adjudicate(load, ActorSla, fmt.Sprintf("not reviewed within %s", q.Sla), models.OutcomeDecline)
+		if errors.Is(err, models.ErrChanged) {
+			continue
+		}
+		if err != nil {
+			return expired, err
+		}
+		expired++
+	}
+	return expired, nil
+}
+
+//decide adjudicates the load for the analyst. The decision is only stored while the load is as it was read, so two
+//analysts deciding a load at once can't both decide it.
+func (q *Queue) decide(customerId int64, transactionId int64, analyst string, note string, outcome string) (*models.Load, error) {
+	for {
+		load, err := q.pending(customerId, transactionId, analyst)
+		if err != nil {
+			return nil, err
+		}
+
+		err = q.adjudicate(load, analyst, note, outcome)
+		//The load changed since it was read, read it again to see whether the analyst can still decide it
+		if errors.Is(err, models.ErrChanged) {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		return load, nil
+	}
+}
+
+//adjudicate records the outcome on the load, the customer's balance, the audit log, the outbox and the webhook
+//deliveries in one transaction. It fails with models.ErrChanged when the load was changed after it was read.
+func (q *Queue) adjudicate(load *models.Load, analyst string, note string, outcome string) error {
+	previous := *load
+	load.Outcome = outcome
+	load.Accepted = outcome == models.OutcomeApprove
+	load.Status = models.StatusDecided
+	load.Analyst = analyst
+	load.ReviewNote = note
+
+	deliveries, err := q.Webhooks.NewDeliveries(audit.EventAdjudication, load)
+	if err != nil {
+		return err
+	}
+	var event *models.OutboxEvent
+	if q.Outbox {
+		event, err = outbox.AdjudicationEvent(load)
+		if err != nil {
+			return err
+		}
+	}
+	return q.Decisions.Update(&previous, load, audit.NewEntry(audit.EventAdjudication, load, analyst, note), event, deliveries)
+}
+
+//pending finds the load and checks the analyst can act on it
+func (q *Queue) pending(customerId int64, transactionId int64, analyst string) (*models.Load, error) {
+	load, err := q.Loads.GetByTransactionId(customerId, transactionId)
+	if err != nil {
+		return nil, err
+	}
+	if load.Status != models.StatusPendingReview {
+		return nil, ErrNotPending
+	}
+	if len(load.Analyst) > 0 && load.Analyst != analyst {
+		return nil, ErrClaimed
+	}
+	return load, nil
+}
@@ -0,0 +1,208 @@
+package review
+
+import (
+	"errors"
+	"fireynis/velocity_checker/pkg/audit"
+	"fireynis/velocity_checker/pkg/models"
+	"fireynis/velocity_checker/pkg/models/memory"
+	"fireynis/velocity_checker/pkg/models/mock"
+	"fireynis/velocity_checker/pkg/webhook"
+	"testing"
+	"time"
+)
+
+func newQueue() *Queue {
+	day := time.Date(2000, 1, 1, 0, 0, 0, 0, time.UTC)
+	loads := memory.NewLoad([]*models.Load{
+		{Id: 1, TransactionId: 1, CustomerId: 1, Amount: 100000, Time: day, ReceivedAt: day, Outcome: models.OutcomeReview, Status: models.StatusPendingReview},
+		{Id: 2, TransactionId: 2, CustomerId: 1, Amount: 100000, Time: day.Add(12 * time.Hour), ReceivedAt: day.Add(12 * time.Hour), Outcome: models.OutcomeReview, Status: models.StatusPendingReview},
+		{Id: 3, TransactionId: 3, CustomerId: 1, Amount: 100000, Time: day, ReceivedAt: day, Accepted: true, Outcome: models.OutcomeApprove, Status: models.StatusDecided},
+		//Backdated by a week but only just received
+		{Id: 4, TransactionId: 4, CustomerId: 1, Amount: 100000, Time: day.AddDate(0, 0, -7), ReceivedAt: day.Add(12 * time.Hour), Outcome: models.OutcomeReview, Status: models.StatusPendingReview},
+	})
+	return &Queue{
+		Loads:     loads,
+		Decisions: &mock.Decision{Loads: loads, AuditLog: &mock.Audit{}, Balances: &mock.Balance{Balances: map[int64]int64{1: 100000}}},
+		Sla:       24 * time.Hour,
+	}
+}
+
+func TestQueue_ClaimAndDecide(t *testing.T) {
+	q := newQueue()
+
+	_, err := q.Claim(1, 1, "alice")
+	if err != nil {
+		t.Fatalf("Claim() unexpected error %v", err)
+	}
+	_, err = q.Claim(1, 1, "bob")
+	if !errors.Is(err, ErrClaimed) {
+		t.Errorf("Claim() by a second analyst got %v, want ErrClaimed", err)
+	}
+	_, err = q.Approve(1, 1, "bob", "looks fine")
+	if !errors.Is(err, ErrClaimed) {
+		t.Errorf("Approve() by a second analyst got %v, want ErrClaimed", err)
+	}
+
+	load, err := q.Approve(1, 1, "alice", "customer verified")
+	if err != nil {
+		t.Fatalf("Approve() unexpected error %v", err)
+	}
+	if !load.Accepted || load.Outcome != models.OutcomeApprove || load.Status != models.StatusDecided || load.ReviewNote != "customer verified" {
+		t.Errorf("Approve() left the load as %+v", load)
+	}
+	balance, _ := q.Decisions.(*mock.Decision).Balances.Get(1)
+	if balance != 200000 {
+		t.Errorf("Approve() left the balance at %d, want 200000", balance)
+	}
+
+	_, err = q.Decline(1, 1, "alice", "changed my mind")
+	if !errors.Is(err, ErrNotPending) {
+		t.Errorf("Decline() of a decided load got %v, want ErrNotPending", err)
+	}
+	_, err = q.Claim(1, 3, "alice")
+	if !errors.Is(err, ErrNotPending) {
+		t.Errorf("Claim() of a decided load got %v, want ErrNotPending", err)
+	}
+
+	entries := q.Decisions.(*mock.Decision).AuditLog.(*mock.Audit).Entries
+	if len(entries) != 1 || entries[0].Actor != "alice" || entries[0].Event != "adjudication" {
+		t.Errorf("want one adjudication by alice on the audit log; got %+v", entries)
+	}
+}
+
+func TestQueue_DecideStoredTogether(t *testing.T) {
+	q := newQueue()
+	decisions := q.Decisions.(*mock.Decision)
+	deliveries := &mock.Delivery{}
+	decisions.Deliveries = deliveries
+	q.Webhooks = &webhook.Dispatcher{
+		Subscriptions: []webhook.Subscription{{Id: "ledger", Url: "https://ledger.example.com", Events: []string{audit.EventAdjudication}, Secret: "s3cret"}},
+		Deliveries:    deliveries,
+	}
+	store := &mock.Outbox{}
+	decisions.Outbox = store
+	q.Outbox = true
+
+	decisions.Fail = errors.New("connection reset")
+	_, err := q.Approve(1, 1, "alice", "customer verified")
+	if err == nil {
+		t.Fatalf("Approve() want the failed transaction's error")
+	}
+
+	load, _ := q.Loads.GetByTransactionId(1, 1)
+	balance, _ := decisions.Balances.Get(1)
+	if load.Status != models.StatusPendingReview || balance != 100000 || len(decisions.AuditLog.(*mock.Audit).Entries) != 0 || len(deliveries.Deliveries) != 0 || len(store.Events) != 0 {
+		t.Errorf("Approve() stored part of the decision, load %+v and balance %d", load, balance)
+	}
+
+	decisions.Fail = nil
+	_, err = q.Approve(1, 1, "alice", "customer verified")
+	if err != nil {
+		t.Fatalf("Approve() unexpected error %v", err)
+	}
+	if len(deliveries.Deliveries) != 1 || deliveries.Deliveries[0].Event != audit.EventAdjudication {
+		t.Errorf("Approve() stored deliveries %+v, want the one adjudication", deliveries.Deliveries)
+	}
+	if len(store.Events) != 1 || store.Events[0].Key != "adjudication:1:1" {
+		t.Errorf("Approve() stored outbox events %+v, want the one adjudication", store.Events)
+	}
+}
+
+func TestQueue_ExpireOverdue(t *testing.T) {
+	q := newQueue()
+
+	expired, err := q.ExpireOverdue(time.Date(2000, 1, 2, 6, 0, 0, 0, time.UTC))
+	if err != nil {
+		t.Fatalf("ExpireOverdue() unexpected error %v", err)
+	}
+	if expired != 1 {
+		t.Errorf("ExpireOverdue() declined %d, want 1", expired)
+	}
+
+	pending, _ := q.Pending()
+	if len(pending) != 2 || pending[0].TransactionId != 4 || pending[1].TransactionId != 2 {
+		t.Errorf("want only the loads received since still pending; got %+v", pending)
+	}
+
+	load, _ := q.Loads.GetByTransactionId(1, 1)
+	if load.Accepted || load.Outcome != models.OutcomeDecline || load.Analyst != ActorSla {
+		t.Errorf("ExpireOverdue() left the load as %+v", load)
+	}
+}
+
+//staleLoads lists the pending loads as they were when it was made, as a listing taken before an analyst acted would
+type staleLoads struct {
+	*memory.Load
+	pending []*models.Load
+}
+
+func (s *staleLoads) GetByStatus(status string) ([]*models.Load, error) {
+	return s.pending, nil
+}
+
+func TestQueue_ExpireOverdueDecidedSinceListed(t *testing.T) {
+	q := newQueue()
+	pending, _ := q.Pending()
+	q.Loads = &staleLoads{Load: q.Loads.(*memory.Load), pending: pending}
+
+	_, err := q.Approve(1, 1, "alice", "customer verified")
+	if err != nil {
+		t.Fatalf("Approve() unexpected error %v", err)
+	}
+
+	expired, err := q.ExpireOverdue(time.Date(2000, 1, 2, 6, 0, 0, 0, time.UTC))
+	if err != nil {
+		t.Fatalf("ExpireOverdue() unexpected error %v", err)
+	}
+	if expired != 0 {
+		t.Errorf("ExpireOverdue() declined %d, want 0", expired)
+	}
+
+	load, _ := q.Loads.GetByTransactionId(1, 1)
+	if !load.Accepted || load.Analyst != "alice" {
+		t.Errorf("ExpireOverdue() overwrote the approval, left the load as %+v", load)
+	}
+	balance, _ := q.Decisions.(*mock.Decision).Balances.Get(1)
+	if balance != 200000 {
+		t.Errorf("ExpireOverdue() left the balance at %d, want 200000", balance)
+	}
+}
+
+//racingLoads runs before once, after the next load it is asked for has been read, as another instance acting on the
+//load between the read and the write would
+type racingLoads struct {
+	*memory.Load
+	before func()
+}
+
+func (s *racingLoads) GetByTransactionId(customerId int64, transactionId int64) (*models.Load, error) {
+	load, err := s.Load.GetByTransactionId(customerId, transactionId)
+	if s.before != nil {
+		before := s.before
+		s.before = nil
+		before()
+	}
+	return load, err
+}
+
+func TestQueue_DecideRacingAnotherInstance(t *testing.T) {
+	q := newQueue()
+	other := &Queue{Loads: q.Loads, Decisions: q.Decisions, Sla: q.Sla}
+	q.Loads = &racingLoads{Load: q.Loads.(*memory.Load), before: func() {
+		_, err := other.Decline(1, 1, "bob", "stolen card")
+		if err != nil {
+			t.Fatalf("Decline() unexpected error %v", err)
+		}
+	}}
+
+	_, err := q.Approve(1, 1, "alice", "customer verified")
+	if !errors.Is(err, ErrNotPending) {
+		t.Errorf("Approve() of a load declined since it was read got %v, want ErrNotPending", err)
+	}
+
+	load, _ := q.Loads.GetByTransactionId(1, 1)
+	entries := q.Decisions.(*mock.Decision).AuditLog.(*mock.Audit).Entries
+	if load.Accepted || load.Analyst != "bob" || len(entries) != 1 {
+		t.Errorf("want only bob's decline stored; got %+v with entries %+v", load, entries)
+	}
+}
@@ -0,0 +1,290 @@
+// Code generated by protoc-gen-go-grpc. DO NOT EDIT.
+
+package rpc
+
+import (
+	context "context"
+	grpc "google.golang.org/grpc"
+	codes "google.golang.org/grpc/codes"
+	status "google.golang.org/grpc/status"
+)
+
+// This is a compile-time assertion to ensure that this generated file
+// is compatible with the grpc package it is being compiled against.
+// Requires gRPC-Go v1.32.0 or later.
+const _ = grpc.SupportPackageIsVersion7
+
+// VelocityClient is the client API for Velocity service.
+//
+// For semantics around ctx use and closing/ending streaming RPCs, please refer to https://pkg.go.dev/google.golang.org/grpc/?tab=doc#ClientConn.NewStream.
+type VelocityClient interface {
+	// Evaluate decides a load and stores it, the same as POST / on the JSON API
+	Evaluate(ctx context.Context, in *LoadRequest, opts ...grpc.CallOption) (*Decision, error)
+	// Simulate decides a load without storing it or anything that follows from it
+	Simulate(ctx context.Context, in *LoadRequest, opts ...grpc.CallOption) (*Decision, error)
+	// GetLoad returns the stored decision for a load, along with its trace
+	GetLoad(ctx context.Context, in *GetLoadRequest, opts ...grpc.CallOption) (*Decision, error)
+	// GetCustomerUsage returns how much of each limit the customer has used and has left
+	GetCustomerUsage(ctx context.Context, in *GetCustomerUsageRequest, opts ...grpc.CallOption) (*CustomerUsage, error)
+	// BatchEvaluate decides a stream of loads in the order they are sent. A load that can't be decided gets an error
+	// in its reply rather than ending the stream.
+	BatchEvaluate(ctx context.Context, opts ...grpc.CallOption) (Velocity_BatchEvaluateClient, error)
+}
+
+type velocityClient struct {
+	cc grpc.ClientConnInterface
+}
+
+func NewVelocityClient(cc grpc.ClientConnInterface) VelocityClient {
+	return &velocityClient{cc}
+}
+
+func (c *velocityClient) Evaluate(ctx context.Context, in *LoadRequest, opts ...grpc.CallOption) (*Decision, error) {
+	out := new(Decision)
+	err := c.cc.Invoke(ctx, "/velocity.v1.Velocity/Evaluate", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *velocityClient) Simulate(ctx context.Context, in *LoadRequest, opts ...grpc.CallOption) (*Decision, error) {
+	out := new(Decision)
+	err := c.cc.Invoke(ctx, "/velocity.v1.Velocity/Simulate", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *velocityClient) GetLoad(ctx context.Context, in *GetLoadRequest, opts ...grpc.CallOption) (*Decision, error) {
+	out := new(Decision)
+	err := c.cc.Invoke(ctx, "/velocity.v1.Velocity/GetLoad", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *velocityClient) GetCustomerUsage(ctx context.Context, in *GetCustomerUsageRequest, opts ...grpc.CallOption) (*CustomerUsage, error) {
+	out := new(CustomerUsage)
+	err := c.cc.Invoke(ctx, "/velocity.v1.Velocity/GetCustomerUsage", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *velocityClient) BatchEvaluate(ctx context.Context, opts ...grpc.CallOption) (Velocity_BatchEvaluateClient, error) {
+	stream, err := c.cc.NewStream(ctx, &Velocity_ServiceDesc.Streams[0], "/velocity.v1.Velocity/BatchEvaluate", opts...)
+	if err != nil {
+		return nil, err
+	}
+	x := &velocityBatchEvaluateClient{stream}
+	return x, nil
+}
+
+type Velocity_BatchEvaluateClient interface {
+	Send(*LoadRequest) error
+	Recv() (*BatchDecision, error)
+	grpc.ClientStream
+}
+
+type velocityBatchEvaluateClient struct {
+	grpc.ClientStream
+}
+
+func (x *velocityBatchEvaluateClient) Send(m *LoadRequest) error {
+	return x.ClientStream.SendMsg(m)
+}
+
+func (x *velocityBatchEvaluateClient) Recv() (*BatchDecision, error) {
+	m := new(BatchDecision)
+	if err := x.ClientStream.RecvMsg(m); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+// VelocityServer is the server API for Velocity service.
+// All implementations must embed UnimplementedVelocityServer
+// for forward compatibility
+type VelocityServer interface {
+	// Evaluate decides a load and stores it, the same as POST / on the JSON API
+	Evaluate(context.Context, *LoadRequest) (*Decision, error)
+	// Simulate decides a load without storing it or anything that follows from it
+	Simulate(context.Context, *LoadRequest) (*Decision, error)
+	// GetLoad returns the stored decision for a load, along with its trace
+	GetLoad(context.Context, *GetLoadRequest) (*Decision, error)
+	// GetCustomerUsage returns how much of each limit the customer has used and has left
+	GetCustomerUsage(context.Context, *GetCustomerUsageRequest) (*CustomerUsage, error)
+	// BatchEvaluate decides a stream of loads in the order they are sent. A load that can't be decided gets an error
+	// in its reply rather than ending the stream.
+	BatchEvaluate(Velocity_BatchEvaluateServer) error
+	mustEmbedUnimplementedVelocityServer()
+}
+
+// UnimplementedVelocityServer must be embedded to have forward compatible implementations.
+type UnimplementedVelocityServer struct {
+}
+
+func (UnimplementedVelocityServer) Evaluate(context.Context, *LoadRequest) (*Decision, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method Evaluate not implemented")
+}
+func (UnimplementedVelocityServer) Simulate(context.Context, *LoadRequest) (*Decision, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method Simulate not implemented")
+}
+func (UnimplementedVelocityServer) GetLoad(context.Context, *GetLoadRequest) (*Decision, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method GetLoad not implemented")
+}
+func (UnimplementedVelocityServer) GetCustomerUsage(context.Context, *GetCustomerUsageRequest) (*CustomerUsage, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method GetCustomerUsage not implemented")
+}
+func (UnimplementedVelocityServer) BatchEvaluate(Velocity_BatchEvaluateServer) error {
+	return status.Errorf(codes.Unimplemented, "method BatchEvaluate not implemented")
+}
+func (UnimplementedVelocityServer) mustEmbedUnimplementedVelocityServer() {}
+
+// UnsafeVelocityServer may be embedded to opt out of forward compatibility for this service.
+// Use of this interface is not recommended, as added methods to VelocityServer will
+// result in compilation errors.
+type UnsafeVelocityServer interface {
+	mustEmbedUnimplementedVelocityServer()
+}
+
+func RegisterVelocityServer(s grpc.ServiceRegistrar, srv VelocityServer) {
+	s.RegisterService(&Velocity_ServiceDesc, srv)
+}
+
+func _Velocity_Evaluate_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(LoadRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(VelocityServer).Evaluate(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/velocity.v1.Velocity/Evaluate",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(VelocityServer).Evaluate(ctx, req.(*LoadRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _Velocity_Simulate_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(LoadRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(VelocityServer).Simulate(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/velocity.v1.Velocity/Simulate",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(VelocityServer).Simulate(ctx, req.(*LoadRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _Velocity_GetLoad_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(GetLoadRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(VelocityServer).GetLoad(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/velocity.v1.Velocity/GetLoad",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(VelocityServer).GetLoad(ctx, req.(*GetLoadRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _Velocity_GetCustomerUsage_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(GetCustomerUsageRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(VelocityServer).GetCustomerUsage(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/velocity.v1.Velocity/GetCustomerUsage",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(VelocityServer).GetCustomerUsage(ctx, req.(*GetCustomerUsageRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _Velocity_BatchEvaluate_Handler(srv interface{}, stream grpc.ServerStream) error {
+	return srv.(VelocityServer).BatchEvaluate(&velocityBatchEvaluateServer{stream})
+}
+
+type Velocity_BatchEvaluateServer interface {
+	Send(*BatchDecision) error
+	Recv() (*LoadRequest, error)
+	grpc.ServerStream
+}
+
+type velocityBatchEvaluateServer struct {
+	grpc.ServerStream
+}
+
+func (x *velocityBatchEvaluateServer) Send(m *BatchDecision) error {
+	return x.ServerStream.SendMsg(m)
+}
+
+func (x *velocityBatchEvaluateServer) Recv() (*LoadRequest, error) {
+	m := new(LoadRequest)
+	if err := x.ServerStream.RecvMsg(m); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+// Velocity_ServiceDesc is the grpc.ServiceDesc for Velocity service.
+// It's only intended for direct use with grpc.RegisterService,
+// and not to be introspected or modified (even as a copy)
+var Velocity_ServiceDesc = grpc.ServiceDesc{
+	ServiceName: "velocity.v1.Velocity",
+	HandlerType: (*VelocityServer)(nil),
+	Methods: []grpc.MethodDesc{
+		{
+			MethodName: "Evaluate",
+			Handler:    _Velocity_Evaluate_Handler,
+		},
+		{
+			MethodName: "Simulate",
+			Handler:    _Velocity_Simulate_Handler,
+		},
+		{
+			MethodName: "GetLoad",
+			Handler:    _Velocity_GetLoad_Handler,
+		},
+		{
+			MethodName: "GetCustomerUsage",
+			Handler:    _Velocity_GetCustomerUsage_Handler,
+		},
+	},
+	Streams: []grpc.StreamDesc{
+		{
+			StreamName:    "BatchEvaluate",
+			Handler:       _Velocity_BatchEvaluate_Handler,
+			ServerStreams: true,
+			ClientStreams: true,
+		},
+	},
+	Metadata: "velocity.proto",
+}
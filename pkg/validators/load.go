@@ -9,37 +9,44 @@ type LoadValidator struct{}
 //LessThanThreeLoadsDaily takes in an array of models that should all be from the same day. Basically it gets the
 //len of the models passed in
 func (l *LoadValidator) LessThanThreeLoadsDaily(loads []*models.Load) bool {
-	if len(loads) >= 3 {
-		return false
-	}
-	return true
+	return l.LessThanMaxLoads(loads, 3)
 }
 
 //LessThanFiveThousandLoadedDaily sums the accepted loads to determine if they exceed the daily 5k limit
 func (l *LoadValidator) LessThanFiveThousandLoadedDaily(loads []*models.Load, load *models.Load) bool {
-	return l.sumLessThanMax(loads, load, int64(500000))
+	return l.SumLessThanMax(loads, load, int64(500000))
 }
 
 //LessThanTwentyThousandLoadedWeekly sums the accepted loads
 func (l *LoadValidator) LessThanTwentyThousandLoadedWeekly(loads []*models.Load, load *models.Load) bool {
-	return l.sumLessThanMax(loads, load, int64(2000000))
+	return l.SumLessThanMax(loads, load, int64(2000000))
+}
+
+//LessThanMaxLoads checks there is room in the window for one more load
+func (l *LoadValidator) LessThanMaxLoads(loads []*models.Load, maxLoads int64) bool {
+	if int64(len(loads)) >= maxLoads {
+		return false
+	}
+	return true
 }
 
-func (l *LoadValidator) sumLessThanMax(loads []*models.Load, load *models.Load, maxAmount int64) bool {
+//SumLessThanMax checks the loads in the window plus the new load don't exceed maxAmount
+func (l *LoadValidator) SumLessThanMax(loads []*models.Load, load *models.Load, maxAmount int64) bool {
 	//Short circuit if the cur value is higher than the daily limit. Don't need to waste the computation.
 	if load.Amount > maxAmount {
 		return false
 	}
-	if load.Amount > maxAmount {
+	if SumAmounts(loads, load) > maxAmount {
 		return false
 	}
+	return true
+}
+
+//SumAmounts totals the loads already in the window plus the load being evaluated
+func SumAmounts(loads []*models.Load, load *models.Load) int64 {
 	amount := int64(0)
 	for _, load := range loads {
 		amount += load.Amount
 	}
-	amount += load.Amount
-	if amount > maxAmount {
-		return false
-	}
-	return true
+	return amount + load.Amount
 }
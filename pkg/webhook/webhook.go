@@ -0,0 +1,378 @@
+package webhook
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fireynis/velocity_checker/pkg/audit"
+	"fireynis/velocity_checker/pkg/models"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"os"
+	"strconv"
+	"time"
+)
+
+//The headers sent with every delivery. The signature is the hex HMAC-SHA256 of the body keyed with the subscription's
+//secret, prefixed with sha256=.
+const (
+	HeaderSignature = "X-Webhook-Signature"
+	HeaderEvent     = "X-Webhook-Event"
+	HeaderDelivery  = "X-Webhook-Delivery"
+)
+
+//Events are the event types a subscription can ask for, the same as the audit log's
+var Events = []string{audit.EventDecision, audit.EventOverride, audit.EventAdjudication}
+
+//ErrSending is returned when redelivering a delivery that is being sent
+var ErrSending = errors.New("webhook: delivery is being sent")
+
+//sendLease is how long a delivery is claimed for while it is sent, comfortably longer than the default client's timeout
+const sendLease = time.Minute
+
+//Subscription sends the events listed to Url, signed with Secret. Id is stored on each delivery to find the
+//subscription again when it is sent, so it must not change while deliveries for it are outstanding.
+type Subscription struct {
+	Id     string   `json:"id"`
+	Url    string   `json:"url"`
+	Events []string `json:"events"`
+	Secret string   `json:"secret"`
+}
+
+//Wants reports whether the subscription asked for the event
+func (s Subscription) Wants(event string) bool {
+	for _, wanted := range s.Events {
+		if wanted == event {
+			return true
+		}
+	}
+	return false
+}
+
+//LoadSubscriptions reads a json array of subscriptions and checks each has a unique id, an http or https url, known
+//events and a secret
+func LoadSubscriptions(r io.Reader) ([]Subscription, error) {
+	var subscriptions []Subscription
+	err := json.NewDecoder(r).Decode(&subscriptions)
+	if err != nil {
+		return nil, errors.New(fmt.Sprintf("webhook: unable to parse subscriptions. %s", err))
+	}
+
+	ids := make(map[string]bool)
+	for _, subscription := range subscriptions {
+		if len(subscription.Id) < 1 {
+			return nil, errors.New(fmt.Sprintf("webhook: %s needs an id", subscription.Url))
+		}
+		if ids[subscription.Id] {
+			return nil, errors.New(fmt.Sprintf("webhook: id %q is used by more than one subscription", subscription.Id))
+		}
+		ids[subscription.Id] = true
+
+		parsed, err := url.Parse(subscription.Url)
+		if err != nil || (parsed.Scheme != "http" && parsed.Scheme != "https") || len(parsed.Host) < 1 {
+			return nil, errors.New(fmt.Sprintf("webhook: %q is not an http or https url", subscription.Url))
+		}
+		if len(subscription.Secret) < 1 {
+			return nil, errors.New(fmt.Sprintf("webhook: %s needs a secret", subscription.Url))
+		}
+		if len(subscription.Events) < 1 {
+			return nil, errors.New(fmt.Sprintf("webhook: %s needs at least one event", subscription.Url))
+		}
+		for _, event := range subscription.Events {
+			if !known(event) {
+				return nil, errors.New(fmt.Sprintf("webhook: %s has unknown event %q", subscription.Url, event))
+			}
+		}
+	}
+	return subscriptions, nil
+}
+
+//OpenSubscriptions reads the subscriptions from a file
+func OpenSubscriptions(path string) ([]Subscription, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+	return LoadSubscriptions(file)
+}
+
+func known(event string) bool {
+	for _, name := range Events {
+		if name == event {
+			return true
+		}
+	}
+	return false
+}
+
+//Sign returns the signature header value for a body
+func Sign(secret string, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	_, _ = mac.Write(body)
+	return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+}
+
+//Payload is the body sent for an event about a load
+type Payload struct {
+	Event         string    `json:"event"`
+	Id            int64     `json:"id"`
+	CustomerId    int64     `json:"customer_id"`
+	Type          string    `json:"type"`
+	Amount        int64     `json:"amount"`
+	Time          time.Time `json:"time"`
+	Accepted      bool      `json:"accepted"`
+	Outcome       string    `json:"outcome"`
+	Reason        string    `json:"reason,omitempty"`
+	Status        string    `json:"status"`
+	RiskScore     int64     `json:"risk_score"`
+	PolicyVersion int64     `json:"policy_version,omitempty"`
+	OccurredAt    time.Time `json:"occurred_at"`
+}
+
+//Dispatcher stores a delivery for every subscription that wants an event and sends them, retrying failures with an
+//exponential backoff. As deliveries are stored before they are sent none are lost to a restart. A nil Dispatcher
+//publishes nothing, so it can be left unset when there are no subscriptions.
+type Dispatcher struct {
+	Subscriptions []Subscription
+	Deliveries    models.IDeliveries
+	Client        *http.Client
+	//MaxAttempts is how many times a delivery is tried before it fails, BaseDelay the wait after the first failure,
+	//doubling after each one up to MaxDelay
+	MaxAttempts int
+	BaseDelay   time.Duration
+	MaxDelay    time.Duration
+	//Now defaults to time.Now, tests set it to control the backoff
+	Now func() time.Time
+}
+
+//The retry settings used when the Dispatcher's are left at zero. Eight attempts back off over roughly an hour.
+const (
+	DefaultMaxAttempts = 8
+	DefaultBaseDelay   = 30 * time.Second
+	DefaultMaxDelay    = time.Hour
+)
+
+//Publish stores a pending delivery of the event for each subscription that wants it. They are sent by DeliverDue.
+func (d *Dispatcher) Publish(event string, load *models.Load) error {
+	deliveries, err := d.NewDeliveries(event, load)
+	if err != nil {
+		return err
+	}
+	for _, delivery := range deliveries {
+		err = d.Deliveries.Insert(delivery)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+//NewDeliveries builds a pending delivery of the event for each subscription that wants it without storing them, for
+//the caller to store in the same transaction as the change the event is about. They are sent by DeliverDue once stored.
+func (d *Dispatcher) NewDeliveries(event string, load *models.Load) ([]*models.Delivery, error) {
+	if d == nil {
+		return nil, nil
+	}
+
+	now := d.now()
+	body, err := json.Marshal(Payload{
+		Event:         event,
+		Id:            load.TransactionId,
+		CustomerId:    load.CustomerId,
+		Type:          load.Type,
+		Amount:        load.Amount,
+		Time:          load.Time,
+		Accepted:      load.Accepted,
+		Outcome:       load.Outcome,
+		Reason:        load.Reason,
+		Status:        load.Status,
+		RiskScore:     load.RiskScore,
+		PolicyVersion: load.PolicyVersion,
+		OccurredAt:    now,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	deliveries := make([]*models.Delivery, 0, len(d.Subscriptions))
+	for _, subscription := range d.Subscriptions {
+		if !subscription.Wants(event) {
+			continue
+		}
+		deliveries = append(deliveries, &models.Delivery{
+			Subscription: subscription.Id,
+			Url:          subscription.Url,
+			Event:        event,
+			Payload:      body,
+			Status:       models.DeliveryPending,
+			NextAttempt:  now,
+		})
+	}
+	return deliveries, nil
+}
+
+//DeliverDue attempts every pending delivery whose next attempt has come, returning how many were delivered. A failed
+//attempt isn't an error, it is recorded on the delivery and retried later. Each delivery is claimed before it is sent,
+//so one another sender or a redelivery got to first is skipped rather than sent twice.
+func (d *Dispatcher) DeliverDue() (int, error) {
+	if d == nil {
+		return 0, nil
+	}
+
+	now := d.now()
+	due, err := d.Deliveries.GetDue(now)
+	if err != nil {
+		return 0, err
+	}
+
+	delivered := 0
+	for _, listed := range due {
+		delivery, err := d.Deliveries.Claim(listed.Id, now, now.Add(sendLease), true)
+		if errors.Is(err, models.ErrNoRecord) {
+			continue
+		}
+		if err != nil {
+			return delivered, err
+		}
+
+		err = d.attempt(delivery)
+		if err != nil {
+			return delivered, err
+		}
+		if delivery.Status == models.DeliveryDelivered {
+			delivered++
+		}
+	}
+	return delivered, nil
+}
+
+//Redeliver sends a delivery again straight away whatever its status, unless it is being sent when ErrSending is
+//returned. Its attempts start again from zero, so if it fails it is retried as though it were new.
+func (d *Dispatcher) Redeliver(id int64) (*models.Delivery, error) {
+	_, err := d.Deliveries.Get(id)
+	if err != nil {
+		return nil, err
+	}
+
+	now := d.now()
+	delivery, err := d.Deliveries.Claim(id, now, now.Add(sendLease), false)
+	if errors.Is(err, models.ErrNoRecord) {
+		return nil, ErrSending
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	delivery.Attempts = 0
+	err = d.attempt(delivery)
+	if err != nil {
+		return nil, err
+	}
+	return delivery, nil
+}
+
+//attempt sends the delivery once and stores the result
+func (d *Dispatcher) attempt(delivery *models.Delivery) error {
+	delivery.Attempts++
+	delivery.ResponseCode, delivery.LastError = d.send(delivery)
+	if len(delivery.LastError) < 1 {
+		delivery.Status = models.DeliveryDelivered
+	} else if delivery.Attempts >= d.maxAttempts() {
+		delivery.Status = models.DeliveryFailed
+	} else {
+		delivery.Status = models.DeliveryPending
+		delivery.NextAttempt = d.now().Add(d.backoff(delivery.Attempts))
+	}
+	return d.Deliveries.Update(delivery)
+}
+
+//send posts the delivery, returning the response code and what went wrong if it wasn't a 2xx
+func (d *Dispatcher) send(delivery *models.Delivery) (int, string) {
+	subscription := d.subscription(delivery)
+	if subscription == nil && len(delivery.Subscription) > 0 {
+		return 0, "no subscription with id " + delivery.Subscription
+	}
+	if subscription == nil {
+		return 0, "no subscription for " + delivery.Url
+	}
+
+	request, err := http.NewRequest(http.MethodPost, delivery.Url, bytes.NewReader(delivery.Payload))
+	if err != nil {
+		return 0, err.Error()
+	}
+	request.Header.Set("Content-Type", "application/json")
+	request.Header.Set(HeaderSignature, Sign(subscription.Secret, delivery.Payload))
+	request.Header.Set(HeaderEvent, delivery.Event)
+	request.Header.Set(HeaderDelivery, strconv.FormatInt(delivery.Id, 10))
+
+	client := d.Client
+	if client == nil {
+		client = &http.Client{Timeout: 10 * time.Second}
+	}
+	response, err := client.Do(request)
+	if err != nil {
+		return 0, err.Error()
+	}
+	defer response.Body.Close()
+	_, _ = io.Copy(ioutil.Discard, response.Body)
+
+	if response.StatusCode < 200 || response.StatusCode > 299 {
+		return response.StatusCode, "responded " + response.Status
+	}
+	return response.StatusCode, ""
+}
+
+//subscription finds the subscription the delivery is for by its id. A delivery stored before subscriptions had ids is
+//matched on its url instead.
+func (d *Dispatcher) subscription(delivery *models.Delivery) *Subscription {
+	for i := range d.Subscriptions {
+		if len(delivery.Subscription) > 0 && d.Subscriptions[i].Id == delivery.Subscription {
+			return &d.Subscriptions[i]
+		}
+		if len(delivery.Subscription) < 1 && d.Subscriptions[i].Url == delivery.Url {
+			return &d.Subscriptions[i]
+		}
+	}
+	return nil
+}
+
+//backoff is how long to wait after the given number of failed attempts
+func (d *Dispatcher) backoff(attempts int) time.Duration {
+	base, max := d.BaseDelay, d.MaxDelay
+	if base <= 0 {
+		base = DefaultBaseDelay
+	}
+	if max <= 0 {
+		max = DefaultMaxDelay
+	}
+
+	delay := base
+	for i := 1; i < attempts && delay < max; i++ {
+		delay *= 2
+	}
+	if delay > max {
+		delay = max
+	}
+	return delay
+}
+
+func (d *Dispatcher) maxAttempts() int {
+	if d.MaxAttempts > 0 {
+		return d.MaxAttempts
+	}
+	return DefaultMaxAttempts
+}
+
+func (d *Dispatcher) now() time.Time {
+	if d.Now != nil {
+		return d.Now()
+	}
+	return time.Now()
+}
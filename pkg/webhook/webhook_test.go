@@ -0,0 +1,171 @@
+package webhook
+
+import (
+	"errors"
+	"fireynis/velocity_checker/pkg/audit"
+	"fireynis/velocity_checker/pkg/models"
+	"fireynis/velocity_checker/pkg/models/mock"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestLoadSubscriptions(t *testing.T) {
+	tests := []struct {
+		name    string
+		input   string
+		wantErr bool
+	}{
+		{"Valid", `[{"id":"ledger","url":"https://ledger.example.com/hooks","events":["decision","override"],"secret":"s3cret"}]`, false},
+		{"No url", `[{"id":"ledger","events":["decision"],"secret":"s3cret"}]`, true},
+		{"Not http", `[{"id":"ledger","url":"ftp://ledger.example.com","events":["decision"],"secret":"s3cret"}]`, true},
+		{"No secret", `[{"id":"ledger","url":"https://ledger.example.com/hooks","events":["decision"]}]`, true},
+		{"No events", `[{"id":"ledger","url":"https://ledger.example.com/hooks","secret":"s3cret"}]`, true},
+		{"Unknown event", `[{"id":"ledger","url":"https://ledger.example.com/hooks","events":["refund"],"secret":"s3cret"}]`, true},
+		{"No id", `[{"url":"https://ledger.example.com/hooks","events":["decision"],"secret":"s3cret"}]`, true},
+		{"Duplicate id", `[{"id":"ledger","url":"https://ledger.example.com/hooks","events":["decision"],"secret":"s3cret"},{"id":"ledger","url":"https://ledger.example.com/other","events":["override"],"secret":"s3cret"}]`, true},
+		{"Bad json", `[{"id":"ledger","url":`, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := LoadSubscriptions(strings.NewReader(tt.input))
+			if (err != nil) != tt.wantErr {
+				t.Errorf("LoadSubscriptions() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestDispatcher(t *testing.T) {
+	failing := true
+	var signatures []string
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := ioutil.ReadAll(r.Body)
+		if r.Header.Get(HeaderSignature) != Sign("s3cret", body) || r.Header.Get(HeaderEvent) != audit.EventDecision {
+			t.Errorf("Unexpected signature %q or event %q", r.Header.Get(HeaderSignature), r.Header.Get(HeaderEvent))
+		}
+		signatures = append(signatures, r.Header.Get(HeaderSignature))
+		if failing {
+			w.WriteHeader(http.StatusServiceUnavailable)
+		}
+	}))
+	defer ts.Close()
+
+	now := time.Date(2000, 1, 1, 0, 0, 0, 0, time.UTC)
+	deliveries := &mock.Delivery{}
+	d := &Dispatcher{
+		//Both share a url, so a decision is only signed with the right secret when found by its subscription's id
+		Subscriptions: []Subscription{
+			{Id: "overrides", Url: ts.URL, Events: []string{audit.EventOverride}, Secret: "other"},
+			{Id: "ledger", Url: ts.URL, Events: []string{audit.EventDecision}, Secret: "s3cret"},
+		},
+		Deliveries:  deliveries,
+		Client:      ts.Client(),
+		MaxAttempts: 3,
+		BaseDelay:   time.Minute,
+		Now:         func() time.Time { return now },
+	}
+
+	err := d.Publish(audit.EventDecision, &models.Load{TransactionId: 1, CustomerId: 2, Amount: 100, Accepted: true, Outcome: models.OutcomeApprove})
+	if err != nil {
+		t.Fatalf("Publish() unexpected error %v", err)
+	}
+	if len(deliveries.Deliveries) != 1 {
+		t.Fatalf("Publish() stored %d deliveries, want 1 for the one subscription that wants decisions", len(deliveries.Deliveries))
+	}
+
+	//The first failure waits BaseDelay, the second twice that, and the third fails the delivery
+	for _, wantWait := range []time.Duration{time.Minute, 2 * time.Minute} {
+		_, err = d.DeliverDue()
+		if err != nil {
+			t.Fatalf("DeliverDue() unexpected error %v", err)
+		}
+		delivery, _ := deliveries.Get(1)
+		if delivery.Status != models.DeliveryPending || !delivery.NextAttempt.Equal(now.Add(wantWait)) || delivery.ResponseCode != http.StatusServiceUnavailable {
+			t.Fatalf("DeliverDue() left %+v, want it pending for %s", delivery, wantWait)
+		}
+
+		//Nothing is sent again until the wait is over
+		sent := len(signatures)
+		_, _ = d.DeliverDue()
+		if len(signatures) != sent {
+			t.Fatalf("DeliverDue() retried before the backoff was over")
+		}
+		now = now.Add(wantWait)
+	}
+
+	_, _ = d.DeliverDue()
+	delivery, _ := deliveries.Get(1)
+	if delivery.Status != models.DeliveryFailed || delivery.Attempts != 3 {
+		t.Fatalf("DeliverDue() left %+v, want it failed after 3 attempts", delivery)
+	}
+
+	failing = false
+	delivery, err = d.Redeliver(1)
+	if err != nil {
+		t.Fatalf("Redeliver() unexpected error %v", err)
+	}
+	if delivery.Status != models.DeliveryDelivered || delivery.Attempts != 1 || len(delivery.LastError) > 0 {
+		t.Errorf("Redeliver() = %+v, want it delivered on its first attempt", delivery)
+	}
+	if len(signatures) != 4 {
+		t.Errorf("Sent %d requests, want 4", len(signatures))
+	}
+}
+
+func TestDispatcher_Claim(t *testing.T) {
+	sent := 0
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		sent++
+	}))
+	defer ts.Close()
+
+	now := time.Date(2000, 1, 1, 0, 0, 0, 0, time.UTC)
+	deliveries := &mock.Delivery{}
+	d := &Dispatcher{
+		Subscriptions: []Subscription{{Id: "ledger", Url: ts.URL, Events: []string{audit.EventDecision}, Secret: "s3cret"}},
+		Deliveries:    deliveries,
+		Client:        ts.Client(),
+		Now:           func() time.Time { return now },
+	}
+	err := d.Publish(audit.EventDecision, &models.Load{TransactionId: 1, CustomerId: 2})
+	if err != nil {
+		t.Fatalf("Publish() unexpected error %v", err)
+	}
+
+	//Another sender has claimed the delivery, so it is neither sent nor redelivered until that claim lapses
+	_, err = deliveries.Claim(1, now, now.Add(sendLease), true)
+	if err != nil {
+		t.Fatalf("Claim() unexpected error %v", err)
+	}
+	delivered, err := d.DeliverDue()
+	if delivered != 0 || err != nil || sent != 0 {
+		t.Errorf("DeliverDue() = %d, %v with %d sent, want the claimed delivery skipped", delivered, err, sent)
+	}
+	_, err = d.Redeliver(1)
+	if !errors.Is(err, ErrSending) || sent != 0 {
+		t.Errorf("Redeliver() error %v with %d sent, want ErrSending", err, sent)
+	}
+
+	now = now.Add(sendLease)
+	delivered, err = d.DeliverDue()
+	if delivered != 1 || err != nil || sent != 1 {
+		t.Errorf("DeliverDue() = %d, %v with %d sent, want the lapsed claim delivered", delivered, err, sent)
+	}
+}
+
+func TestDispatcher_Nil(t *testing.T) {
+	var d *Dispatcher
+	err := d.Publish(audit.EventDecision, &models.Load{})
+	if err != nil {
+		t.Errorf("Publish() on a nil Dispatcher error %v", err)
+	}
+	delivered, err := d.DeliverDue()
+	if delivered != 0 || err != nil {
+		t.Errorf("DeliverDue() on a nil Dispatcher = %d, %v", delivered, err)
+	}
+}